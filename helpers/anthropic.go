@@ -0,0 +1,509 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// AnthropicProvider talks to the Anthropic Messages API. Request.Reasoning
+// turns on extended thinking, whose budget counts in MaxTokens. The signed
+// thinking blocks the API wants back with tool results travel in
+// Message.ReasoningData.
+type AnthropicProvider struct {
+	APIKey     string
+	BaseURL    string       // Defaults to https://api.anthropic.com
+	Version    string       // Value of the anthropic-version header, defaults to 2023-06-01
+	MaxTokens  int          // Required by the API, defaults to 4096
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice    map[string]string  `json:"tool_choice,omitempty"`
+	Thinking      *anthropicThinking `json:"thinking,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	Data      string          `json:"data,omitempty"` // Redacted thinking
+	Id        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseId string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema *Parameters `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	Id         string           `json:"id"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      *anthropicUsage  `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int32 `json:"input_tokens"`
+	OutputTokens int32 `json:"output_tokens"`
+}
+
+// toUsage converts the usage of a message
+func (u *anthropicUsage) toUsage() *Usage {
+	if u == nil {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// anthropicEvent covers every event of the streaming API
+type anthropicEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *anthropicResponse `json:"message"`
+	ContentBlock *anthropicBlock    `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJson string `json:"partial_json"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *AnthropicProvider) url() string {
+	base := p.BaseURL
+	if base == "" {
+		base = "https://api.anthropic.com"
+	}
+	return strings.TrimRight(base, "/") + "/v1/messages"
+}
+
+func (p *AnthropicProvider) headers() map[string]string {
+	version := p.Version
+	if version == "" {
+		version = "2023-06-01"
+	}
+	return map[string]string{
+		"x-api-key":         p.APIKey,
+		"anthropic-version": version,
+	}
+}
+
+// toAnthropic translates a request to the Messages API format. System
+// messages move to the system field and tool results become user messages.
+func (p *AnthropicProvider) toAnthropic(req *Request) *anthropicRequest {
+	out := &anthropicRequest{
+		Model:     req.Model,
+		MaxTokens: p.MaxTokens,
+		Stream:    req.Stream,
+	}
+	if req.MaxTokens > 0 {
+		out.MaxTokens = req.MaxTokens
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = 4096
+	}
+	if req.Reasoning != nil || req.IncludeReasoning != nil && *req.IncludeReasoning {
+		budget := thinkingBudget(req.Reasoning, out.MaxTokens)
+		// max_tokens counts the thinking too, leave room for the answer
+		if budget >= out.MaxTokens {
+			out.MaxTokens += budget
+		}
+		out.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+	} else {
+		// The API rejects a temperature with thinking
+		out.Temperature = req.Temperature
+	}
+	out.TopP = req.TopP
+	out.StopSequences = req.Stop
+
+	var system []string
+	for i := range req.Messages {
+		m := &req.Messages[i]
+		var msg anthropicMessage
+		switch m.Role {
+		case "system":
+			system = append(system, m.Content)
+			continue
+		case "tool":
+			msg = anthropicMessage{
+				Role: "user",
+				Content: []anthropicBlock{{
+					Type:      "tool_result",
+					ToolUseId: m.ToolCallID,
+					Content:   m.Content,
+				}},
+			}
+		default:
+			msg = anthropicMessage{Role: m.Role}
+			if m.Role == "assistant" {
+				msg.Content = thinkingBlocks(m.ReasoningData)
+			}
+			if m.Content != "" {
+				msg.Content = append(msg.Content, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				if tc == nil || tc.Function == nil {
+					continue
+				}
+				msg.Content = append(msg.Content, anthropicBlock{
+					Type:  "tool_use",
+					Id:    tc.Id,
+					Name:  tc.Function.Name,
+					Input: toolArguments(tc.Function.Arguments),
+				})
+			}
+		}
+
+		// The API expects alternating roles, so merge consecutive messages
+		if n := len(out.Messages); n > 0 && out.Messages[n-1].Role == msg.Role {
+			out.Messages[n-1].Content = append(out.Messages[n-1].Content, msg.Content...)
+		} else {
+			out.Messages = append(out.Messages, msg)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, t := range req.Tools {
+		if t == nil || t.Function == nil {
+			continue
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        t.Function.Name,
+			Description: t.Description,
+			InputSchema: t.Function.Parameters,
+		})
+	}
+	if len(out.Tools) > 0 {
+		switch {
+		case req.ToolChoice == "required":
+			out.ToolChoice = map[string]string{"type": "any"}
+		case req.ToolChoice.forced():
+			out.ToolChoice = map[string]string{"type": "tool", "name": string(req.ToolChoice)}
+		case req.ToolChoice != "":
+			out.ToolChoice = map[string]string{"type": string(req.ToolChoice)}
+		}
+	}
+
+	return out
+}
+
+// anthropicMinThinking is the smallest thinking budget the API accepts
+const anthropicMinThinking = 1024
+
+// thinkingBudget converts the reasoning settings to a thinking budget. The
+// efforts take the shares of max_tokens OpenRouter uses.
+func thinkingBudget(reasoning *Reasoning, maxTokens int) int {
+	budget := 0
+	if reasoning != nil {
+		budget = reasoning.MaxTokens
+	}
+	if budget == 0 {
+		share := 0.5
+		if reasoning != nil {
+			switch reasoning.Effort {
+			case "low":
+				share = 0.2
+			case "high":
+				share = 0.8
+			}
+		}
+		budget = int(float64(maxTokens) * share)
+	}
+	return max(budget, anthropicMinThinking)
+}
+
+// thinkingData encodes the thinking blocks of a response, with their
+// signatures, for Message.ReasoningData
+func thinkingData(blocks []anthropicBlock) string {
+	var thinking []anthropicBlock
+	for _, block := range blocks {
+		if block.Type == "thinking" || block.Type == "redacted_thinking" {
+			thinking = append(thinking, anthropicBlock{
+				Type:      block.Type,
+				Thinking:  block.Thinking,
+				Signature: block.Signature,
+				Data:      block.Data,
+			})
+		}
+	}
+	if len(thinking) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(thinking)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// thinkingBlocks decodes the thinking blocks kept by thinkingData. Data from
+// other providers is not sent.
+func thinkingBlocks(data string) []anthropicBlock {
+	var blocks []anthropicBlock
+	if data == "" || json.Unmarshal([]byte(data), &blocks) != nil {
+		return nil
+	}
+	for _, block := range blocks {
+		if block.Type != "thinking" && block.Type != "redacted_thinking" {
+			return nil
+		}
+	}
+	return blocks
+}
+
+// excludeReasoning reports whether the reasoning must not be returned
+func excludeReasoning(req *Request) bool {
+	return req.Reasoning != nil && req.Reasoning.Exclude
+}
+
+// anthropicFinishReason maps stop reasons to OpenAI finish reasons
+func anthropicFinishReason(reason string) string {
+	switch reason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "tool_use":
+		return "tool_calls"
+	case "max_tokens":
+		return "length"
+	case "refusal":
+		return "content_filter"
+	}
+	return reason
+}
+
+// fromAnthropic translates a Messages API response
+func fromAnthropic(resp *anthropicResponse) *ResponseData {
+	message := &Message{Role: "assistant"}
+	var text, thinking []string
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "thinking":
+			thinking = append(thinking, block.Thinking)
+		case "tool_use":
+			message.ToolCalls = append(message.ToolCalls, &ToolCall{
+				Id:   block.Id,
+				Type: "function",
+				Function: &Function{
+					Name:      block.Name,
+					Arguments: string(toolArguments(string(block.Input))),
+				},
+				Index: int32(len(message.ToolCalls)),
+			})
+		}
+	}
+	message.Content = strings.Join(text, "")
+	message.Reasoning = strings.Join(thinking, "")
+	message.ReasoningData = thinkingData(resp.Content)
+
+	return &ResponseData{
+		Id:     resp.Id,
+		Object: "chat.completion",
+		Model:  resp.Model,
+		Choices: []*Choice{{
+			Message:      message,
+			FinishReason: anthropicFinishReason(resp.StopReason),
+		}},
+		Usage: resp.Usage.toUsage(),
+	}
+}
+
+func (p *AnthropicProvider) Chat(ctx context.Context, req *Request) (*ResponseData, error) {
+	body := p.toAnthropic(req)
+	body.Stream = false
+
+	resp, err := postJSON(ctx, p.HTTPClient, p.url(), p.headers(), body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var anthropicResp anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	response := fromAnthropic(&anthropicResp)
+	if excludeReasoning(req) {
+		response.Choices[0].Message.Reasoning = ""
+	}
+	return response, nil
+}
+
+func (p *AnthropicProvider) ChatStream(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error) {
+	chunks := make(chan *ResponseData)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		defer close(errs)
+
+		body := p.toAnthropic(req)
+		body.Stream = true
+
+		resp, err := postJSON(ctx, p.HTTPClient, p.url(), p.headers(), body)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		var id, model string
+		usage := &anthropicUsage{}
+		// Content block index to tool call index
+		toolIndex := make(map[int]int32)
+		// The blocks, put together to keep their thinking
+		var blocks []*anthropicBlock
+		blockIndex := make(map[int]*anthropicBlock)
+
+		err = readSSE(resp.Body, func(_, data string) error {
+			var ev anthropicEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				return fmt.Errorf("error decoding stream event: %w", err)
+			}
+
+			delta := &Delta{}
+			choice := &Choice{Delta: delta}
+			var chunkUsage *Usage
+			switch ev.Type {
+			case "message_start":
+				if ev.Message != nil {
+					id, model = ev.Message.Id, ev.Message.Model
+					if ev.Message.Usage != nil {
+						usage.InputTokens = ev.Message.Usage.InputTokens
+					}
+				}
+				return nil
+			case "content_block_start":
+				if ev.ContentBlock == nil {
+					return nil
+				}
+				block := *ev.ContentBlock
+				blocks = append(blocks, &block)
+				blockIndex[ev.Index] = &block
+				if block.Type != "tool_use" {
+					return nil
+				}
+				index := int32(len(toolIndex))
+				toolIndex[ev.Index] = index
+				delta.ToolCalls = []*ToolCall{{
+					Id:       ev.ContentBlock.Id,
+					Type:     "function",
+					Function: &Function{Name: ev.ContentBlock.Name},
+					Index:    index,
+				}}
+			case "content_block_delta":
+				switch ev.Delta.Type {
+				case "text_delta":
+					delta.Content = ev.Delta.Text
+				case "thinking_delta":
+					if block := blockIndex[ev.Index]; block != nil {
+						block.Thinking += ev.Delta.Thinking
+					}
+					if excludeReasoning(req) {
+						return nil
+					}
+					delta.Reasoning = ev.Delta.Thinking
+				case "signature_delta":
+					if block := blockIndex[ev.Index]; block != nil {
+						block.Signature += ev.Delta.Signature
+					}
+					return nil
+				case "input_json_delta":
+					delta.ToolCalls = []*ToolCall{{
+						Function: &Function{Arguments: ev.Delta.PartialJson},
+						Index:    toolIndex[ev.Index],
+					}}
+				default:
+					return nil
+				}
+			case "message_delta":
+				choice.FinishReason = anthropicFinishReason(ev.Delta.StopReason)
+				if ev.Usage != nil {
+					usage.OutputTokens = ev.Usage.OutputTokens
+					chunkUsage = usage.toUsage()
+				}
+			case "message_stop":
+				// The thinking goes with the last chunk, signed
+				kept := make([]anthropicBlock, len(blocks))
+				for i, block := range blocks {
+					kept[i] = *block
+				}
+				delta.ReasoningData = thinkingData(kept)
+				if delta.ReasoningData == "" {
+					return io.EOF
+				}
+				if err := send(ctx, chunks, &ResponseData{
+					Id:      id,
+					Object:  "chat.completion.chunk",
+					Model:   model,
+					Choices: []*Choice{choice},
+				}); err != nil {
+					return err
+				}
+				return io.EOF
+			case "error":
+				if ev.Error != nil {
+					return fmt.Errorf("stream error: %s: %s", ev.Error.Type, ev.Error.Message)
+				}
+				return fmt.Errorf("stream error")
+			default:
+				return nil
+			}
+
+			return send(ctx, chunks, &ResponseData{
+				Id:      id,
+				Object:  "chat.completion.chunk",
+				Model:   model,
+				Choices: []*Choice{choice},
+				Usage:   chunkUsage,
+			})
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return chunks, errs
+}
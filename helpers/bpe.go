@@ -0,0 +1,269 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The vocabs of the encodings are embedded by the tiktoken subpackage, kept
+// apart because of their size. Importing it is enough for CountTokens to count
+// exact tokens:
+//
+//	import _ "github.com/desarso/go_llm_functions/helpers/tiktoken"
+//
+// They can also be loaded with LoadEncoding from the tiktoken files, one
+// base64 token and its rank per line, published at
+// https://openaipublic.blob.core.windows.net/encodings/<encoding>.tiktoken
+
+// ErrVocabNotFound is returned for an encoding whose vocab was neither
+// registered nor loaded
+var ErrVocabNotFound = errors.New("vocab not found")
+
+// Pre-tokenizer patterns of the tiktoken encodings. Go's regexp has no
+// lookahead, so the trailing `\s+(?!\S)` is emulated in Encoding.split: the
+// last group only matches runs of whitespace, which give back their last
+// character when followed by something else.
+const (
+	contractions  = `(?i:'s|'t|'re|'ve|'m|'ll|'d)`
+	cl100kPattern = contractions + `|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|(\s+)`
+	o200kPattern  = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+` + contractions + `?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*` + contractions + `?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|(\s+)`
+)
+
+// Encoding is a byte pair encoding tokenizer like the ones of tiktoken
+type Encoding struct {
+	Name    string
+	pattern *regexp.Regexp
+	ranks   map[string]int
+	tokens  map[int]string
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[string]*Encoding{}
+	vocabs      = map[string]func() (io.ReadCloser, error){}
+	patterns    = map[string]string{
+		"cl100k_base": cl100kPattern,
+		"o200k_base":  o200kPattern,
+	}
+)
+
+// modelEncodings maps model name prefixes to their encoding, matched like
+// ContextSizes
+var modelEncodings = map[string]string{
+	"gpt-3.5-turbo":          "cl100k_base",
+	"gpt-4":                  "cl100k_base",
+	"gpt-4o":                 "o200k_base",
+	"gpt-4.1":                "o200k_base",
+	"gpt-4.5":                "o200k_base",
+	"o1":                     "o200k_base",
+	"o3":                     "o200k_base",
+	"o4":                     "o200k_base",
+	"text-embedding-3":       "cl100k_base",
+	"text-embedding-ada-002": "cl100k_base",
+}
+
+// EncodingForModel returns the encoding used by an OpenAI model
+func EncodingForModel(model string) (*Encoding, error) {
+	name := matchModel(model, modelEncodings)
+	if name == "" {
+		return nil, fmt.Errorf("no encoding known for model %s", model)
+	}
+	return GetEncoding(name)
+}
+
+// GetEncoding returns an encoding by name, reading its registered vocab on
+// first use
+func GetEncoding(name string) (*Encoding, error) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	if e, ok := encodings[name]; ok {
+		return e, nil
+	}
+	if _, ok := patterns[name]; !ok {
+		return nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+
+	open, ok := vocabs[name]
+	if !ok {
+		return nil, fmt.Errorf("error loading encoding %s: %w", name, ErrVocabNotFound)
+	}
+	f, err := open()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error loading encoding %s: %w", name, ErrVocabNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading encoding %s: %w", name, err)
+	}
+	defer f.Close()
+	e, err := newEncoding(name, f)
+	if err != nil {
+		return nil, err
+	}
+	encodings[name] = e
+	return e, nil
+}
+
+// RegisterVocab sets where the vocab of a known encoding is read from on
+// first use. The tiktoken subpackage registers its embedded vocabs.
+func RegisterVocab(name string, open func() (io.ReadCloser, error)) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	vocabs[name] = open
+}
+
+// LoadEncoding reads the vocab of a known encoding from a tiktoken file,
+// replacing the one loaded before
+func LoadEncoding(name string, r io.Reader) error {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	if _, ok := patterns[name]; !ok {
+		return fmt.Errorf("unknown encoding: %s", name)
+	}
+	e, err := newEncoding(name, r)
+	if err != nil {
+		return err
+	}
+	encodings[name] = e
+	return nil
+}
+
+// newEncoding parses a tiktoken file
+func newEncoding(name string, r io.Reader) (*Encoding, error) {
+	e := &Encoding{
+		Name:    name,
+		pattern: regexp.MustCompile(patterns[name]),
+		ranks:   make(map[string]int),
+		tokens:  make(map[int]string),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		token, rank, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return nil, fmt.Errorf("error parsing encoding %s: invalid line %q", name, line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(token))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing encoding %s: %w", name, err)
+		}
+		n, err := strconv.Atoi(string(rank))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing encoding %s: %w", name, err)
+		}
+		e.ranks[string(decoded)] = n
+		e.tokens[n] = string(decoded)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading encoding %s: %w", name, err)
+	}
+	return e, nil
+}
+
+// split cuts text into the pieces that are encoded separately
+func (e *Encoding) split(text string) []string {
+	var pieces []string
+	for len(text) > 0 {
+		loc := e.pattern.FindStringSubmatchIndex(text)
+		if loc == nil {
+			// Only invalid UTF-8 is left
+			pieces = append(pieces, text)
+			break
+		}
+		start, end := loc[0], loc[1]
+		if end == start {
+			// Skip what the pattern can't match, one character at a time
+			_, size := utf8.DecodeRuneInString(text[start:])
+			end = start + size
+		}
+
+		// A whitespace run followed by a word leaves its last character to it
+		if loc[2] >= 0 && end < len(text) {
+			if last, size := utf8.DecodeLastRuneInString(text[start:end]); unicode.IsSpace(last) && end-size > start {
+				next, _ := utf8.DecodeRuneInString(text[end:])
+				if !unicode.IsSpace(next) {
+					end -= size
+				}
+			}
+		}
+
+		if start > 0 {
+			pieces = append(pieces, text[:start])
+		}
+		pieces = append(pieces, text[start:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+// Encode returns the tokens of text
+func (e *Encoding) Encode(text string) []int {
+	var tokens []int
+	for _, piece := range e.split(text) {
+		tokens = e.encodePiece(tokens, piece)
+	}
+	return tokens
+}
+
+// Count returns the number of tokens of text
+func (e *Encoding) Count(text string) int {
+	return len(e.Encode(text))
+}
+
+// Decode returns the text of tokens
+func (e *Encoding) Decode(tokens []int) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(e.tokens[t])
+	}
+	return b.String()
+}
+
+// encodePiece merges the bytes of a piece, lowest ranked pair first
+func (e *Encoding) encodePiece(tokens []int, piece string) []int {
+	if rank, ok := e.ranks[piece]; ok {
+		return append(tokens, rank)
+	}
+
+	// Boundaries of the parts, starting with one part per byte
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+	for len(bounds) > 2 {
+		best, bestRank := -1, 0
+		for i := 0; i+2 < len(bounds); i++ {
+			rank, ok := e.ranks[piece[bounds[i]:bounds[i+2]]]
+			if ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+
+	for i := 0; i+1 < len(bounds); i++ {
+		if rank, ok := e.ranks[piece[bounds[i]:bounds[i+1]]]; ok {
+			tokens = append(tokens, rank)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// toyVocab is a tiktoken file with every byte and a few merges
+func toyVocab() string {
+	var b strings.Builder
+	rank := 0
+	add := func(token string) {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+		rank++
+	}
+	for i := 0; i < 256; i++ {
+		add(string([]byte{byte(i)}))
+	}
+	for _, merge := range []string{"he", "ll", "hell", "hello", " w", "or", " wor", "ld", " world"} {
+		add(merge)
+	}
+	return b.String()
+}
+
+// withToyEncoding loads the toy vocab as name for the length of a test
+func withToyEncoding(t *testing.T, name string) *Encoding {
+	if err := LoadEncoding(name, strings.NewReader(toyVocab())); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		encodingsMu.Lock()
+		delete(encodings, name)
+		encodingsMu.Unlock()
+	})
+	e, err := GetEncoding(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEncodingNotLoaded(t *testing.T) {
+	if _, err := EncodingForModel("gpt-4o"); !errors.Is(err, ErrVocabNotFound) {
+		t.Fatalf("got %v, want ErrVocabNotFound", err)
+	}
+	messages := []Message{{Role: "user", Content: "hello world"}}
+	if got, want := CountTokens("gpt-4o", messages, nil), EstimateTokens("gpt-4o", messages, nil); got != want {
+		t.Fatalf("got %d, want the estimate %d", got, want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	e := withToyEncoding(t, "o200k_base")
+
+	tests := []struct {
+		text   string
+		tokens []int
+	}{
+		{"hello world", []int{259, 264}},
+		{"hello!", []int{259, '!'}},
+		{"12345", []int{'1', '2', '3', '4', '5'}},
+		{"hell", []int{258}},
+		{"a  b", []int{'a', ' ', ' ', 'b'}},
+	}
+	for _, tt := range tests {
+		tokens := e.Encode(tt.text)
+		if !reflect.DeepEqual(tokens, tt.tokens) {
+			t.Errorf("Encode(%q) = %v, want %v", tt.text, tokens, tt.tokens)
+		}
+		if got := e.Decode(tokens); got != tt.text {
+			t.Errorf("Decode(Encode(%q)) = %q", tt.text, got)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	e := withToyEncoding(t, "cl100k_base")
+
+	got := e.split("Hello  world, it's 12345!\n\nBye")
+	want := []string{"Hello", " ", " world", ",", " it", "'s", " ", "123", "45", "!\n\n", "Bye"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestCountTokensWithEncoding(t *testing.T) {
+	e := withToyEncoding(t, "o200k_base")
+
+	messages := []Message{{Role: "user", Content: "hello world"}}
+	want := tokensPerReply + tokensPerMessage + e.Count("user") + 2
+	if got := CountTokens("gpt-4o-mini", messages, nil); got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+}
+
+func TestRegisterVocab(t *testing.T) {
+	opened := 0
+	RegisterVocab("cl100k_base", func() (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(strings.NewReader(toyVocab())), nil
+	})
+	t.Cleanup(func() {
+		encodingsMu.Lock()
+		delete(vocabs, "cl100k_base")
+		delete(encodings, "cl100k_base")
+		encodingsMu.Unlock()
+	})
+
+	for i := 0; i < 2; i++ {
+		e, err := EncodingForModel("gpt-4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.Count("hello world"); got != 2 {
+			t.Errorf("got %d tokens, want 2", got)
+		}
+	}
+	if opened != 1 {
+		t.Errorf("opened the vocab %d times, want once", opened)
+	}
+
+	// A registered vocab whose file is missing falls back like an unknown one
+	RegisterVocab("o200k_base", func() (io.ReadCloser, error) { return nil, fs.ErrNotExist })
+	t.Cleanup(func() {
+		encodingsMu.Lock()
+		delete(vocabs, "o200k_base")
+		encodingsMu.Unlock()
+	})
+	if _, err := GetEncoding("o200k_base"); !errors.Is(err, ErrVocabNotFound) {
+		t.Errorf("got %v, want ErrVocabNotFound", err)
+	}
+}
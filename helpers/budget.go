@@ -0,0 +1,224 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Budget limits the requests made by an LLM function or a client. Pass it to
+// LLM like the other options, or set Client.Budgets. Zero limits are ignored.
+//
+// Without a Window, a budget given to LLM applies to each call of the
+// function and a client budget applies to everything sent through the client
+// since the last ResetUsage. With a Window, the limits apply to the requests
+// made during the last Window.
+//
+// Every attempt sent to a provider is checked and counted as it starts,
+// fallbacks and retries of middleware included, so MaxRequests is never
+// exceeded. Tokens and cost are only known once the answer arrives: requests
+// running at the same time, like the candidates of Options.Top, each pass the
+// check and can together go over MaxTokens or MaxCost by their own usage.
+type Budget struct {
+	MaxTokens   int
+	MaxCost     float64 // In dollars
+	MaxRequests int
+	Window      time.Duration
+}
+
+// BudgetExceededError is returned when a request would go over a budget. The
+// tool loop stops and the error holds the transcript up to that point.
+type BudgetExceededError struct {
+	Scope    string // "call", "function" or "client"
+	Limit    string // "tokens", "cost" or "requests"
+	Budget   Budget
+	Used     UsageTotals
+	Messages []Message // The transcript when the budget ran out
+}
+
+func (e *BudgetExceededError) Error() string {
+	switch e.Limit {
+	case "tokens":
+		return fmt.Sprintf("%s budget exceeded: used %d of %d tokens", e.Scope, e.Used.TotalTokens, e.Budget.MaxTokens)
+	case "cost":
+		return fmt.Sprintf("%s budget exceeded: spent $%.4f of $%.4f", e.Scope, e.Used.Cost, e.Budget.MaxCost)
+	}
+	return fmt.Sprintf("%s budget exceeded: made %d of %d requests", e.Scope, e.Used.Requests, e.Budget.MaxRequests)
+}
+
+// exceeded returns the limit another request would go over, if any
+func (b Budget) exceeded(used UsageTotals) string {
+	switch {
+	case b.MaxRequests > 0 && used.Requests >= b.MaxRequests:
+		return "requests"
+	case b.MaxTokens > 0 && used.TotalTokens >= b.MaxTokens:
+		return "tokens"
+	case b.MaxCost > 0 && used.Cost >= b.MaxCost:
+		return "cost"
+	}
+	return ""
+}
+
+// ledger keeps the usage of the last window
+type ledger struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries []ledgerEntry
+}
+
+type ledgerEntry struct {
+	at     time.Time
+	totals UsageTotals
+}
+
+func (l *ledger) add(totals UsageTotals) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, ledgerEntry{at: time.Now(), totals: totals})
+}
+
+// total returns the usage of the window, forgetting older entries
+func (l *ledger) total() UsageTotals {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := time.Now().Add(-l.window)
+	i := 0
+	for i < len(l.entries) && l.entries[i].at.Before(cutoff) {
+		i++
+	}
+	l.entries = l.entries[i:]
+
+	var totals UsageTotals
+	for _, e := range l.entries {
+		totals.Add(e.totals)
+	}
+	return totals
+}
+
+// budgetState pairs a budget with where its usage is counted. Budgets
+// without a window count the usage of the call, or of the client.
+type budgetState struct {
+	budget Budget
+	scope  string
+	ledger *ledger
+}
+
+func newBudgetState(budget Budget, scope string) *budgetState {
+	state := &budgetState{budget: budget, scope: scope}
+	if budget.Window > 0 {
+		state.ledger = &ledger{window: budget.Window}
+	}
+	return state
+}
+
+type budgetKey struct{}
+
+// withBudgets makes the budgets of a function apply to the requests made
+// with ctx
+func withBudgets(ctx context.Context, budgets []*budgetState) context.Context {
+	if len(budgets) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, budgetKey{}, budgets)
+}
+
+// clientBudgets returns the state of the budgets of the client, made again
+// when Client.Budgets changed. The budgets that are still there keep the
+// usage of their window.
+func (c *Client) clientBudgets() []*budgetState {
+	if c == nil {
+		return nil
+	}
+	c.budgetsMu.Lock()
+	defer c.budgetsMu.Unlock()
+	if slices.Equal(c.budgetsOf, c.Budgets) {
+		return c.budgets
+	}
+
+	previous := slices.Clone(c.budgets)
+	c.budgets = nil
+	for _, b := range c.Budgets {
+		var state *budgetState
+		for i, p := range previous {
+			if p != nil && p.budget == b {
+				state, previous[i] = p, nil
+				break
+			}
+		}
+		if state == nil {
+			state = newBudgetState(b, "client")
+		}
+		c.budgets = append(c.budgets, state)
+	}
+	c.budgetsOf = slices.Clone(c.Budgets)
+	return c.budgets
+}
+
+// activeBudgets lists the budgets of the function calling and of the client
+func (c *Client) activeBudgets(ctx context.Context) []*budgetState {
+	var budgets []*budgetState
+	if functionBudgets, ok := ctx.Value(budgetKey{}).([]*budgetState); ok {
+		budgets = append(budgets, functionBudgets...)
+	}
+	return append(budgets, c.clientBudgets()...)
+}
+
+// reserveRequest returns a BudgetExceededError if another request would go
+// over a budget, and counts the request otherwise. Checking and counting
+// happen under a lock of the client so parallel requests see each other.
+func (c *Client) reserveRequest(ctx context.Context) error {
+	if c != nil {
+		c.reserveMu.Lock()
+		defer c.reserveMu.Unlock()
+	}
+	if err := c.checkBudgets(ctx); err != nil {
+		return err
+	}
+	c.recordTotals(ctx, UsageTotals{Requests: 1})
+	return nil
+}
+
+// checkBudgets returns a BudgetExceededError if another request would go
+// over a budget of the call, the function or the client
+func (c *Client) checkBudgets(ctx context.Context) error {
+	var call UsageTotals
+	if tracker, ok := ctx.Value(usageKey{}).(*usageTracker); ok {
+		call = tracker.get()
+	}
+
+	for _, state := range c.activeBudgets(ctx) {
+		used := call
+		switch {
+		case state.ledger != nil:
+			used = state.ledger.total()
+		case state.scope == "client":
+			used = c.Usage()
+		}
+
+		if limit := state.budget.exceeded(used); limit != "" {
+			scope := state.scope
+			if scope == "function" && state.ledger == nil {
+				scope = "call"
+			}
+			return &BudgetExceededError{
+				Scope:  scope,
+				Limit:  limit,
+				Budget: state.budget,
+				Used:   used,
+			}
+		}
+	}
+	return nil
+}
+
+// recordBudgets adds the usage of a request to the windowed budgets
+func (c *Client) recordBudgets(ctx context.Context, totals UsageTotals) {
+	for _, state := range c.activeBudgets(ctx) {
+		if state.ledger != nil {
+			state.ledger.add(totals)
+		}
+	}
+}
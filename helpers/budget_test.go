@@ -0,0 +1,94 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+func TestBudgetParallelRequests(t *testing.T) {
+	provider := fake.New()
+	provider.On("").After(10 * time.Millisecond).Respond("ok")
+	client := &llm.Client{Provider: provider, Budgets: []llm.Budget{{MaxRequests: 3}}}
+	f := llm.LLMWithResult(echo, client)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	exceeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var budgetErr *llm.BudgetExceededError
+			if _, err := f(context.Background(), "hi"); errors.As(err, &budgetErr) {
+				mu.Lock()
+				exceeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sent := len(provider.Requests()); sent != 3 || exceeded != 7 {
+		t.Errorf("got %d requests sent and %d refused, want 3 and 7", sent, exceeded)
+	}
+}
+
+func TestBudgetRetryMiddleware(t *testing.T) {
+	provider := fake.New()
+	provider.On("").Respond("not good enough")
+
+	// Retries answers it doesn't like, every attempt must count
+	retry := func(next llm.Handler) llm.Handler {
+		return func(ctx context.Context, req *llm.Request) (*llm.ResponseData, error) {
+			for {
+				if _, err := next(ctx, req); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	f := llm.LLMWithResult(echo, &llm.Client{Provider: provider}, retry, llm.Budget{MaxRequests: 2})
+
+	result, err := f(context.Background(), "hi")
+	var budgetErr *llm.BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("got %v, want a BudgetExceededError", err)
+	}
+	if sent := len(provider.Requests()); sent != 2 || result.Usage.Requests != 2 || result.Usage.TotalTokens == 0 {
+		t.Errorf("got %d requests sent, usage %+v, want 2 with their tokens", sent, result.Usage)
+	}
+}
+
+func TestBudgetChanged(t *testing.T) {
+	provider := fake.New()
+	provider.On("").Respond("ok")
+	client := &llm.Client{Provider: provider, Budgets: []llm.Budget{{MaxRequests: 1, Window: time.Hour}}}
+	f := llm.LLMWithResult(echo, client)
+	exceeded := func() bool {
+		var budgetErr *llm.BudgetExceededError
+		_, err := f(context.Background(), "hi")
+		return errors.As(err, &budgetErr)
+	}
+
+	if exceeded() || !exceeded() {
+		t.Fatal("want the second request refused")
+	}
+
+	// A new budget starts from an empty window
+	client.Budgets = []llm.Budget{{MaxRequests: 2, Window: time.Hour}}
+	if exceeded() {
+		t.Fatal("got the old budget after changing Budgets")
+	}
+
+	// The budgets that stay keep their window
+	client.Budgets = append(client.Budgets, llm.Budget{MaxCost: 100})
+	if exceeded() || !exceeded() {
+		t.Error("want the request over the kept budget refused")
+	}
+}
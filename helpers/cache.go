@@ -0,0 +1,317 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache stores encoded responses by key. Implement it to keep responses in
+// Redis, a database or anywhere else.
+type Cache interface {
+	// Get returns the value stored for key, false when it is missing or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value for key, forever when ttl is 0
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// ResponseCache answers requests that were already sent from a Cache instead
+// of the provider. Set it on the client, or in the options of a call.
+//
+// Only deterministic requests are cached: the ones with a temperature of 0.
+// Set Force to cache the others too, like when replaying prompts in tests.
+type ResponseCache struct {
+	Backend Cache
+	TTL     time.Duration // How long responses are kept, forever when 0
+	Force   bool          // Cache requests with a non-zero or default temperature
+}
+
+// cacheKeyVersion changes when the key of a request is computed differently
+const cacheKeyVersion = "v1"
+
+// cacheKey is a hash of everything in a request that changes the response
+func cacheKey(request *Request) (string, error) {
+	normalized := *request
+	normalized.Stream = false
+	normalized.StreamOptions = nil
+	data, err := json.Marshal(&normalized)
+	if err != nil {
+		return "", fmt.Errorf("error encoding request: %w", err)
+	}
+	sum := sha256.Sum256(append([]byte(cacheKeyVersion+"\n"), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cacheable reports whether the response to a request can be reused
+func (c *ResponseCache) cacheable(request *Request) bool {
+	if c == nil || c.Backend == nil {
+		return false
+	}
+	if c.Force {
+		return true
+	}
+	return request.Temperature != nil && *request.Temperature == 0
+}
+
+// get returns the cached response to the request with key
+func (c *ResponseCache) get(ctx context.Context, key string) (*ResponseData, bool, error) {
+	data, ok, err := c.Backend.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	response := &ResponseData{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, false, fmt.Errorf("error decoding cached response: %w", err)
+	}
+	return response, true, nil
+}
+
+// set caches the response to the request with key
+func (c *ResponseCache) set(ctx context.Context, key string, response *ResponseData) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("error encoding response: %w", err)
+	}
+	return c.Backend.Set(ctx, key, data, c.TTL)
+}
+
+// responseCache returns the cache used for a call
+func (c *Client) responseCache(options Options) *ResponseCache {
+	if options.Cache != nil {
+		return options.Cache
+	}
+	if c != nil {
+		return c.Cache
+	}
+	return nil
+}
+
+// answeredBy is set by the fallbacks to the model that answered a request,
+// which is not the requested one when a fallback did
+type answeredBy struct {
+	model string
+}
+
+type answeredKey struct{}
+
+// setAnsweredBy records the model that answered the request of ctx
+func setAnsweredBy(ctx context.Context, model string) {
+	if by, ok := ctx.Value(answeredKey{}).(*answeredBy); ok {
+		by.model = model
+	}
+}
+
+// withAnsweredBy returns ctx with somewhere for the fallbacks to record the
+// model that answered, reusing the one ctx already has
+func withAnsweredBy(ctx context.Context) (context.Context, *answeredBy) {
+	if by, ok := ctx.Value(answeredKey{}).(*answeredBy); ok {
+		return ctx, by
+	}
+	by := &answeredBy{}
+	return context.WithValue(ctx, answeredKey{}, by), by
+}
+
+// cachedChat answers from the cache when it can, and caches what fetch
+// returns under the key of the model that answered
+func cachedChat(ctx context.Context, client *Client, messages []Message, options Options, tools []*Tool, fetch func(ctx context.Context) (*ResponseData, error)) (*ResponseData, error) {
+	cache := client.responseCache(options)
+	request := newRequest(client, messages, options, tools...)
+	if !cache.cacheable(request) {
+		return fetch(ctx)
+	}
+
+	key, err := cacheKey(request)
+	if err != nil {
+		return nil, err
+	}
+	response, ok, err := cache.get(ctx, key)
+	if err != nil {
+		client.logger(options).WarnContext(ctx, "cache read failed", "error", err)
+	}
+	if ok {
+		client.logger(options).DebugContext(ctx, "cache hit", "key", key)
+		client.recordTotals(ctx, UsageTotals{CacheHits: 1})
+		replay(response, options)
+		return response, nil
+	}
+
+	client.recordTotals(ctx, UsageTotals{CacheMisses: 1})
+	by := &answeredBy{}
+	response, err = fetch(context.WithValue(ctx, answeredKey{}, by))
+	if err != nil {
+		return nil, err
+	}
+	if by.model != "" && by.model != request.Model {
+		answered := *request
+		answered.Model = by.model
+		if key, err = cacheKey(&answered); err != nil {
+			return nil, err
+		}
+	}
+	if err := cache.set(ctx, key, response); err != nil {
+		client.logger(options).WarnContext(ctx, "cache write failed", "error", err)
+	}
+	return response, nil
+}
+
+// replay sends a cached answer to the streaming callbacks in one piece
+func replay(response *ResponseData, options Options) {
+	if len(response.Choices) == 0 || response.Choices[0] == nil || response.Choices[0].Message == nil {
+		return
+	}
+	message := response.Choices[0].Message
+	if options.OnReasoning != nil && message.Reasoning != "" {
+		options.OnReasoning(message.Reasoning)
+	}
+	if options.OnContent != nil && message.Content != "" {
+		options.OnContent(message.Content)
+	}
+}
+
+// LRUCache is an in-memory Cache keeping the most recently used entries
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // Most recently used first
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache returns a cache keeping at most size entries
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{size: size, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.size > 0 && c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of entries, including expired ones not evicted yet
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// DiskCache is a Cache keeping one file per entry in Dir, so responses
+// survive restarts and can be shared between processes
+type DiskCache struct {
+	Dir string
+}
+
+// diskEntry is the content of a cache file. Values are kept readable when
+// they are JSON, like responses are.
+type diskEntry struct {
+	Expires *time.Time      `json:"expires,omitempty"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Raw     []byte          `json:"raw,omitempty"`
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+func (c *DiskCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading cache entry: %w", err)
+	}
+	var entry diskEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("error decoding cache entry: %w", err)
+	}
+	if entry.Expires != nil && !entry.Expires.IsZero() && time.Now().After(*entry.Expires) {
+		os.Remove(c.path(key))
+		return nil, false, nil
+	}
+	if entry.Raw != nil {
+		return entry.Raw, true, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (c *DiskCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+	entry := diskEntry{Value: value}
+	if !json.Valid(value) {
+		entry = diskEntry{Raw: value}
+	}
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		entry.Expires = &expires
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see half an entry
+	tmp, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package llm_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+func forModel(name string) func(*llm.Request) bool {
+	return func(req *llm.Request) bool { return req.Model == name }
+}
+
+// TestCacheFallbackAnswer checks that the answer of a fallback is cached
+// under the key of the fallback model, not the one of the requested model
+func TestCacheFallbackAnswer(t *testing.T) {
+	provider := fake.New()
+	provider.OnRequest(forModel("primary")).FailStatus(500, "down").Respond("From primary.")
+	provider.OnRequest(forModel("backup")).Respond("From backup.")
+	client := &llm.Client{
+		Provider:  provider,
+		Model:     "primary",
+		Fallbacks: []llm.Fallback{{Model: "backup"}},
+		Cache:     &llm.ResponseCache{Backend: llm.NewLRUCache(10), Force: true},
+	}
+	f := llm.LLMWithResult(forecast, client)
+	ctx := context.Background()
+
+	result, err := f(ctx, "Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "From backup." {
+		t.Fatalf("got %q, want the answer of the fallback", result.Content)
+	}
+
+	// The primary model is back, its answer is not in the cache
+	result, err = f(ctx, "Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "From primary." {
+		t.Errorf("got %q, want the answer of the primary model", result.Content)
+	}
+
+	// Asking the fallback model directly is answered from the cache
+	requests := len(provider.Requests())
+	result, err = llm.LLMWithResult(forecast, client, llm.Options{Model: "backup"})(ctx, "Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "From backup." || len(provider.Requests()) != requests {
+		t.Errorf("got %q after %d new requests, want the cached answer of the fallback", result.Content, len(provider.Requests())-requests)
+	}
+}
+
+func TestDiskCacheExpires(t *testing.T) {
+	cache := &llm.DiskCache{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	if err := cache.Set(ctx, "forever", []byte(`"value"`), 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(cache.Dir, "forever.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "expires") {
+		t.Errorf("got %s, want no expiry for an entry kept forever", data)
+	}
+	if _, ok, err := cache.Get(ctx, "forever"); err != nil || !ok {
+		t.Errorf("got %v, %v, want the entry", ok, err)
+	}
+
+	if err := cache.Set(ctx, "expired", []byte(`"value"`), time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, ok, err := cache.Get(ctx, "expired"); err != nil || ok {
+		t.Errorf("got %v, %v, want an expired entry", ok, err)
+	}
+}
@@ -0,0 +1,321 @@
+// Package cassette records the HTTP requests sent to providers and replays
+// them, so code built on LLM functions can be tested without an API key:
+//
+//	rec, err := cassette.New("testdata/weather.json", cassette.ModeAuto)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer rec.Stop()
+//	client := &llm.Client{Provider: &llm.OpenAIProvider{HTTPClient: rec.Client()}}
+//
+// Requests are matched on their method, URL and body, with JSON bodies
+// compared after normalizing them. Streamed responses are recorded whole and
+// replayed as one body. API keys and cookies are replaced in the recorded
+// headers and URLs.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Mode selects whether a Recorder records or replays
+type Mode int
+
+const (
+	// ModeReplay serves the recorded responses and fails on unknown requests
+	ModeReplay Mode = iota
+	// ModeRecord sends every request and records it, replacing the cassette
+	ModeRecord
+	// ModeAuto replays when the cassette exists and records otherwise
+	ModeAuto
+)
+
+// redacted replaces secrets in recorded requests
+const redacted = "REDACTED"
+
+// DefaultRedactHeaders are the request and response headers replaced in
+// recordings
+var DefaultRedactHeaders = []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key", "Api-Key", "Cookie", "Set-Cookie"}
+
+// DefaultRedactParams are the URL query parameters replaced in recordings
+var DefaultRedactParams = []string{"key", "api_key"}
+
+// ErrNoInteraction is returned when replaying a request that was not recorded
+var ErrNoInteraction = errors.New("cassette: no recorded interaction matches the request")
+
+// Cassette is the content of a fixture file
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it got
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+
+	used bool
+}
+
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+type Response struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"` // Server-sent events are kept as sent
+}
+
+// Recorder is an http.RoundTripper recording to or replaying from a cassette.
+// Build it with New, or set its fields and the cassette is opened on first
+// use.
+type Recorder struct {
+	Path          string
+	Mode          Mode              // ModeAuto is resolved when the cassette is opened
+	Transport     http.RoundTripper // Sends the requests when recording, defaults to http.DefaultTransport
+	RedactHeaders []string          // Defaults to DefaultRedactHeaders
+	RedactParams  []string          // Defaults to DefaultRedactParams
+
+	mu       sync.Mutex
+	cassette *Cassette
+}
+
+// New opens the cassette at path. In ModeReplay the file must exist.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{Path: path, Mode: mode}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open reads the cassette unless it was already, resolving ModeAuto. r.mu
+// must be held.
+func (r *Recorder) open() error {
+	if r.cassette != nil {
+		return nil
+	}
+	if r.Mode == ModeRecord {
+		r.cassette = &Cassette{}
+		return nil
+	}
+	data, err := os.ReadFile(r.Path)
+	if errors.Is(err, os.ErrNotExist) && r.Mode == ModeAuto {
+		r.Mode = ModeRecord
+		r.cassette = &Cassette{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading cassette: %w", err)
+	}
+	cassette := &Cassette{}
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return fmt.Errorf("error decoding cassette: %w", err)
+	}
+	r.Mode = ModeReplay
+	r.cassette = cassette
+	return nil
+}
+
+// mode opens the cassette and returns the resolved mode
+func (r *Recorder) mode() (Mode, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.open(); err != nil {
+		return r.Mode, err
+	}
+	return r.Mode, nil
+}
+
+// Client returns an http.Client using the recorder, to set as the HTTPClient
+// of a provider
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Stop saves the cassette when recording
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	if err := r.open(); err != nil || r.Mode != ModeRecord {
+		r.mu.Unlock()
+		return err
+	}
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding cassette: %w", err)
+	}
+	if dir := filepath.Dir(r.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("error creating cassette directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(r.Path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing cassette: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	mode, err := r.mode()
+	if err != nil {
+		return nil, err
+	}
+	if mode == ModeReplay {
+		return r.replay(req, body)
+	}
+	return r.record(req, body)
+}
+
+// record sends the request and keeps it with its response
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the whole body, streams included, then hand a copy to the caller
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := &Interaction{
+		Request: Request{
+			Method:  req.Method,
+			URL:     r.redactURL(req.URL),
+			Headers: r.redactHeaders(req.Header),
+			Body:    string(body),
+		},
+		Response: Response{
+			Status:  resp.StatusCode,
+			Headers: r.redactHeaders(resp.Header),
+			Body:    string(respBody),
+		},
+	}
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// replay serves the first unused interaction matching the request. Once
+// every match was used, the last one is served again.
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	url := r.redactURL(req.URL)
+	normalized := normalizeBody(body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var match *Interaction
+	for _, interaction := range r.cassette.Interactions {
+		if interaction.Request.Method != req.Method || interaction.Request.URL != url ||
+			normalizeBody([]byte(interaction.Request.Body)) != normalized {
+			continue
+		}
+		match = interaction
+		if !interaction.used {
+			break
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, url)
+	}
+	match.used = true
+
+	header := match.Response.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", match.Response.Status, http.StatusText(match.Response.Status)),
+		StatusCode:    match.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(match.Response.Body)),
+		ContentLength: int64(len(match.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// normalizeBody returns JSON bodies re-encoded with sorted keys and no
+// whitespace, and other bodies as they are
+func normalizeBody(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return string(body)
+	}
+	return string(normalized)
+}
+
+func (r *Recorder) redactHeaders(header http.Header) http.Header {
+	names := r.RedactHeaders
+	if names == nil {
+		names = DefaultRedactHeaders
+	}
+	out := header.Clone()
+	for _, name := range names {
+		if out.Get(name) != "" {
+			out.Set(name, redacted)
+		}
+	}
+	return out
+}
+
+func (r *Recorder) redactURL(u *url.URL) string {
+	params := r.RedactParams
+	if params == nil {
+		params = DefaultRedactParams
+	}
+	redactedURL := *u
+	query := redactedURL.Query()
+	changed := false
+	for _, param := range params {
+		if query.Has(param) {
+			query.Set(param, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		redactedURL.RawQuery = query.Encode()
+	}
+	return redactedURL.String()
+}
@@ -0,0 +1,78 @@
+package cassette_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/cassette"
+)
+
+func weather(city string) string {
+	return "Sunny, 22°C in " + city
+}
+
+var getWeather = llm.CreateTool("get_weather", "Gets the weather of a city", weather)
+
+func weatherClient(rec *cassette.Recorder) *llm.Client {
+	return &llm.Client{
+		Model: "gpt-4o-mini",
+		Provider: &llm.OpenAIProvider{
+			URL:        "https://api.openai.com/v1/chat/completions",
+			APIKey:     "sk-test0123456789",
+			HTTPClient: rec.Client(),
+		},
+	}
+}
+
+func TestReplayToolLoop(t *testing.T) {
+	// Built without New, the cassette is opened by the first request
+	rec := &cassette.Recorder{Path: "testdata/weather.json", Mode: cassette.ModeReplay}
+	defer rec.Stop()
+
+	forecast := llm.LLMWithResult(func(city string) string {
+		return "What is the weather in " + city + "?"
+	}, weatherClient(rec), getWeather)
+	result, err := forecast(context.Background(), "Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "It is sunny and 22°C in Paris." {
+		t.Errorf("got %q", result.Content)
+	}
+	var toolResult string
+	for i := range result.Messages {
+		if result.Messages[i].Role == "tool" {
+			toolResult = result.Messages[i].Content
+		}
+	}
+	if toolResult != "Sunny, 22°C in Paris" {
+		t.Errorf("got tool result %q, want the one of get_weather", toolResult)
+	}
+	if result.Usage.Requests != 2 {
+		t.Errorf("got %d requests, want the tool call and the answer", result.Usage.Requests)
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	rec := &cassette.Recorder{Path: "testdata/weather.json", Mode: cassette.ModeReplay}
+	ask := llm.LLMWithResult(func(s string) string { return s }, weatherClient(rec))
+	if _, err := ask(context.Background(), "Something else"); !errors.Is(err, cassette.ErrNoInteraction) {
+		t.Errorf("got %v, want ErrNoInteraction", err)
+	}
+}
+
+func TestFixtureIsRedacted(t *testing.T) {
+	data, err := os.ReadFile("testdata/weather.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"sk-test0123456789", "__cf_bm"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("the fixture contains %q", secret)
+		}
+	}
+}
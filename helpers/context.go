@@ -0,0 +1,368 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ContextWindow keeps the messages of each request within the context of the
+// model. Set it on Options: the history kept by a Conversation stays whole,
+// only what is sent is shortened.
+type ContextWindow struct {
+	MaxTokens int             // Context size, defaults to ContextSize of the model
+	Reserve   int             // Tokens left free for the answer, defaults to 1024
+	Strategy  ContextStrategy // Defaults to dropping old tool results, then old turns
+	Counter   TokenCounter    // Defaults to CountTokens
+}
+
+// defaultReserve is the room left for the answer when ContextWindow.Reserve
+// is not set
+const defaultReserve = 1024
+
+// ContextSizes holds the context size of known models. Models are matched by
+// the longest prefix of their name, without the vendor part used by
+// OpenRouter, so "openai/gpt-4o-2024-08-06" gets the size of "gpt-4o".
+var ContextSizes = map[string]int{
+	"gpt-3.5-turbo":    16385,
+	"gpt-4":            8192,
+	"gpt-4-turbo":      128000,
+	"gpt-4o":           128000,
+	"gpt-4.1":          1047576,
+	"o1":               200000,
+	"o3":               200000,
+	"o4-mini":          200000,
+	"claude-3":         200000,
+	"claude-sonnet-4":  200000,
+	"claude-opus-4":    200000,
+	"gemini-1.5-flash": 1048576,
+	"gemini-1.5-pro":   2097152,
+	"gemini-2":         1048576,
+	"llama-3.1":        131072,
+	"llama-3.3":        131072,
+	"llama3":           8192,
+	"mixtral-8x7b":     32768,
+	"deepseek":         65536,
+	"qwen":             32768,
+}
+
+// ContextSize returns the context size of a model, 0 when it is unknown
+func ContextSize(model string) int {
+	return matchModel(model, ContextSizes)
+}
+
+// matchModel looks a model up in a table keyed by name prefixes, ignoring the
+// vendor part of the name
+func matchModel[V any](model string, table map[string]V) V {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	model = strings.ToLower(model)
+
+	var value V
+	longest := 0
+	for prefix, v := range table {
+		if strings.HasPrefix(model, prefix) && len(prefix) > longest {
+			value, longest = v, len(prefix)
+		}
+	}
+	return value
+}
+
+// TokenCounter counts the tokens a request takes in the context of a model
+type TokenCounter func(model string, messages []Message, tools []*Tool) int
+
+// ContextStrategy shortens the messages of a request that doesn't fit in the
+// context. It must not modify messages, and may return messages that still
+// don't fit when it can do no better.
+type ContextStrategy interface {
+	Fit(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error)
+}
+
+// ContextStrategyFunc adapts a plain function to the ContextStrategy interface
+type ContextStrategyFunc func(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error)
+
+func (f ContextStrategyFunc) Fit(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error) {
+	return f(ctx, messages, limit, count)
+}
+
+// defaultContextStrategy is used when ContextWindow.Strategy is not set
+var defaultContextStrategy = Chain(DropToolResults(), SlidingWindow())
+
+// fitContext shortens the messages of a request to the context window of the
+// options. The transcript itself is left untouched.
+func fitContext(ctx context.Context, client *Client, messages []Message, options Options, tools []*Tool) ([]Message, error) {
+	window := options.ContextWindow
+	if window == nil {
+		return messages, nil
+	}
+
+	model := client.model(options)
+	size := window.MaxTokens
+	if size <= 0 {
+		size = ContextSize(model)
+	}
+	if size <= 0 {
+		// Nothing to fit into
+		return messages, nil
+	}
+	reserve := window.Reserve
+	if reserve <= 0 {
+		reserve = defaultReserve
+	}
+	if reserve >= size {
+		reserve = size / 4
+	}
+
+	counter := window.Counter
+	if counter == nil {
+		counter = CountTokens
+	}
+	count := func(m []Message) int {
+		return counter(model, m, tools)
+	}
+
+	limit := size - reserve
+	if count(messages) <= limit {
+		return messages, nil
+	}
+
+	strategy := window.Strategy
+	if strategy == nil {
+		strategy = defaultContextStrategy
+	}
+	fitted, err := strategy.Fit(ctx, messages, limit, count)
+	if err != nil {
+		return nil, fmt.Errorf("error fitting the context window: %w", err)
+	}
+	client.logger(options).InfoContext(ctx, "context shortened",
+		"messages", len(messages), "tokens", count(messages),
+		"fitted_messages", len(fitted), "fitted_tokens", count(fitted))
+	return fitted, nil
+}
+
+// leadingSystem returns the number of system messages at the start of
+// messages, which strategies always keep
+func leadingSystem(messages []Message) int {
+	n := 0
+	for n < len(messages) && messages[n].Role == "system" {
+		n++
+	}
+	return n
+}
+
+// turnStarts returns the index of every user message after from. Messages are
+// dropped by whole turns so tool results never lose their call.
+func turnStarts(messages []Message, from int) []int {
+	var starts []int
+	for i := from; i < len(messages); i++ {
+		if messages[i].Role == "user" {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// joinMessages concatenates message lists into a new slice
+func joinMessages(lists ...[]Message) []Message {
+	var out []Message
+	for _, list := range lists {
+		for i := range list {
+			out = append(out, cloneMessage(&list[i]))
+		}
+	}
+	return out
+}
+
+// SlidingWindow drops the oldest turns until the messages fit. The system
+// messages and the last turn are always kept.
+func SlidingWindow() ContextStrategy {
+	return ContextStrategyFunc(func(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error) {
+		head := leadingSystem(messages)
+		starts := turnStarts(messages, head)
+		if len(starts) == 0 {
+			return messages, nil
+		}
+		for i, start := range starts {
+			fitted := joinMessages(messages[:head], messages[start:])
+			if i == len(starts)-1 || count(fitted) <= limit {
+				return fitted, nil
+			}
+		}
+		return messages, nil
+	})
+}
+
+// KeepLast keeps the system messages and the last n other messages, whatever
+// their size. Tool results are kept with the call that asked for them.
+func KeepLast(n int) ContextStrategy {
+	return ContextStrategyFunc(func(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error) {
+		head := leadingSystem(messages)
+		start := len(messages) - n
+		if start <= head {
+			return messages, nil
+		}
+		for start > head && messages[start].Role == "tool" {
+			start--
+		}
+		return joinMessages(messages[:head], messages[start:]), nil
+	})
+}
+
+// droppedToolResult replaces the content of the tool results dropped by
+// DropToolResults
+const droppedToolResult = "[tool result removed to save space]"
+
+// DropToolResults empties the oldest tool results until the messages fit. The
+// results of the current turn are kept since the model still needs them.
+func DropToolResults() ContextStrategy {
+	return ContextStrategyFunc(func(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error) {
+		current := len(messages)
+		if starts := turnStarts(messages, 0); len(starts) > 0 {
+			current = starts[len(starts)-1]
+		}
+
+		fitted := joinMessages(messages)
+		for i := 0; i < current; i++ {
+			if fitted[i].Role != "tool" || fitted[i].Content == droppedToolResult {
+				continue
+			}
+			fitted[i].Content = droppedToolResult
+			if count(fitted) <= limit {
+				break
+			}
+		}
+		return fitted, nil
+	})
+}
+
+// Chain applies strategies in order until the messages fit
+func Chain(strategies ...ContextStrategy) ContextStrategy {
+	return ContextStrategyFunc(func(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error) {
+		for _, strategy := range strategies {
+			if count(messages) <= limit {
+				break
+			}
+			var err error
+			messages, err = strategy.Fit(ctx, messages, limit, count)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return messages, nil
+	})
+}
+
+// summarizePrompt asks for the summary of the dropped turns
+const summarizePrompt = `Summarize the conversation below so it can go on without it. Keep names, facts, decisions, tool results that matter and open questions. Use at most %d words and answer with the summary only.`
+
+// summarizer keeps a running summary of the turns it dropped so far
+type summarizer struct {
+	model string
+
+	mu      sync.Mutex
+	covered []Message // The turns the summary covers
+	summary string
+}
+
+// Summarize replaces the oldest turns with a summary written by model, or
+// the model of the call when empty. The summary is kept and extended with the
+// next dropped turns rather than rewritten on every request, so use a
+// separate strategy for each conversation.
+func Summarize(model string) ContextStrategy {
+	return &summarizer{model: model}
+}
+
+func (s *summarizer) Fit(ctx context.Context, messages []Message, limit int, count func([]Message) int) ([]Message, error) {
+	head := leadingSystem(messages)
+	starts := turnStarts(messages, head)
+	if len(starts) < 2 {
+		// Only the current turn, nothing to summarize
+		return messages, nil
+	}
+
+	// Keep the most recent turns that fit next to a summary of about an
+	// eighth of the context
+	allowance := limit / 8
+	cut := starts[len(starts)-1]
+	for _, start := range starts[1:] {
+		if count(joinMessages(messages[:head], messages[start:])) <= limit-allowance {
+			cut = start
+			break
+		}
+	}
+
+	summary, err := s.summarize(ctx, messages[head:cut], allowance*3/4)
+	if err != nil {
+		return nil, err
+	}
+	return joinMessages(messages[:head], []Message{{
+		Role:    "system",
+		Content: "Summary of the earlier conversation:\n" + summary,
+	}}, messages[cut:]), nil
+}
+
+// summarize returns the summary of dropped, only summarizing the turns the
+// previous summary doesn't cover
+func (s *summarizer) summarize(ctx context.Context, dropped []Message, words int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, from := "", 0
+	if len(s.covered) <= len(dropped) && sameMessages(s.covered, dropped[:len(s.covered)]) {
+		if len(s.covered) == len(dropped) {
+			return s.summary, nil
+		}
+		previous, from = s.summary, len(s.covered)
+	}
+
+	var transcript strings.Builder
+	if previous != "" {
+		fmt.Fprintf(&transcript, "Summary so far:\n%s\n\nThen:\n", previous)
+	}
+	for i := from; i < len(dropped); i++ {
+		m := &dropped[i]
+		content := m.Content
+		for _, tc := range m.ToolCalls {
+			if tc != nil && tc.Function != nil {
+				content += fmt.Sprintf("\n[called %s(%s)]", tc.Function.Name, tc.Function.Arguments)
+			}
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", m.Role, content)
+	}
+
+	if words < 50 {
+		words = 50
+	}
+	messages := []Message{
+		{Role: "system", Content: fmt.Sprintf(summarizePrompt, words)},
+		{Role: "user", Content: transcript.String()},
+	}
+	client := ClientFrom(ctx)
+	response, err := chat(ctx, client, messages, Options{Model: s.model})
+	if err != nil {
+		return "", fmt.Errorf("error summarizing conversation: %w", err)
+	}
+	if len(response.Choices) == 0 || response.Choices[0].Message == nil {
+		return "", fmt.Errorf("error summarizing conversation: no response from LLM")
+	}
+
+	s.covered = cloneMessages(dropped)
+	s.summary = strings.TrimSpace(response.Choices[0].Message.Content)
+	return s.summary, nil
+}
+
+// sameMessages reports whether two lists hold the same messages
+func sameMessages(a, b []Message) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Role != b[i].Role || a[i].Content != b[i].Content ||
+			a[i].ToolCallID != b[i].ToolCallID || len(a[i].ToolCalls) != len(b[i].ToolCalls) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package llm_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+// calling is an assistant message calling lookup for city
+func calling(id, city string) llm.Message {
+	return llm.Message{Role: "assistant", ToolCalls: []*llm.ToolCall{{
+		Id:       id,
+		Type:     "function",
+		Function: &llm.Function{Name: "lookup", Arguments: `{"city":"` + city + `"}`},
+	}}}
+}
+
+// history is a conversation of three turns, the last one waiting for the
+// answer to its tool call
+func history() []llm.Message {
+	return []llm.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Weather in Paris?"},
+		calling("call_1", "Paris"),
+		{Role: "tool", ToolCallID: "call_1", Content: "sunny in Paris, 24 degrees with a light wind from the west all day"},
+		{Role: "assistant", Content: "Sunny."},
+		{Role: "user", Content: "Thanks"},
+		{Role: "assistant", Content: "You're welcome."},
+		{Role: "user", Content: "And Rome?"},
+		calling("call_2", "Rome"),
+		{Role: "tool", ToolCallID: "call_2", Content: "sunny in Rome"},
+	}
+}
+
+// perMessage counts ten tokens per message
+func perMessage(messages []llm.Message) int {
+	return 10 * len(messages)
+}
+
+// perCharacter counts a token per character of content
+func perCharacter(messages []llm.Message) int {
+	n := 0
+	for i := range messages {
+		n += len(messages[i].Content)
+	}
+	return n
+}
+
+// checkPairs fails when a tool result lost the call asking for it
+func checkPairs(t *testing.T, messages []llm.Message) {
+	t.Helper()
+	calls := map[string]bool{}
+	for i := range messages {
+		for _, tc := range messages[i].ToolCalls {
+			calls[tc.Id] = true
+		}
+		if messages[i].Role == "tool" && !calls[messages[i].ToolCallID] {
+			t.Errorf("tool result %s kept without its call in %q", messages[i].ToolCallID, contents(messages))
+		}
+	}
+}
+
+func TestContextStrategies(t *testing.T) {
+	all := contents(history())
+	tests := []struct {
+		name     string
+		strategy llm.ContextStrategy
+		limit    int
+		count    func([]llm.Message) int
+		want     []string
+	}{
+		{"sliding window", llm.SlidingWindow(), 60, perMessage, append(all[:1:1], all[5:]...)},
+		{"sliding window keeps the last turn", llm.SlidingWindow(), 10, perMessage, append(all[:1:1], all[7:]...)},
+		{"keep last", llm.KeepLast(3), 0, perMessage, append(all[:1:1], all[7:]...)},
+		{"keep last with its call", llm.KeepLast(1), 0, perMessage, append(all[:1:1], all[8:]...)},
+		{"keep last everything", llm.KeepLast(20), 0, perMessage, all},
+		{"drop tool results", llm.DropToolResults(), 120, perCharacter, append(append(all[:3:3], "tool: [tool result removed to save space]"), all[4:]...)},
+		{"chain", llm.Chain(llm.DropToolResults(), llm.SlidingWindow()), 60, perMessage, append(all[:1:1], all[5:]...)},
+	}
+	for _, tt := range tests {
+		messages := history()
+		fitted, err := tt.strategy.Fit(context.Background(), messages, tt.limit, tt.count)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := contents(fitted); !equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		checkPairs(t, fitted)
+		if got := contents(messages); !equal(got, all) {
+			t.Errorf("%s: the messages were modified: %q", tt.name, got)
+		}
+	}
+}
+
+func TestChainStopsOnceFitting(t *testing.T) {
+	fail := llm.ContextStrategyFunc(func(ctx context.Context, messages []llm.Message, limit int, count func([]llm.Message) int) ([]llm.Message, error) {
+		t.Error("the chain went on after the messages fit")
+		return messages, nil
+	})
+	fitted, err := llm.Chain(llm.KeepLast(3), fail).Fit(context.Background(), history(), 40, perMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fitted) != 4 {
+		t.Errorf("got %q, want the last turn", contents(fitted))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	provider := fake.New()
+	provider.On("").Respond("Paris is sunny.").Respond("Paris is sunny, thanks were said.")
+	ctx := llm.WithClient(context.Background(), &llm.Client{Provider: provider})
+	strategy := llm.Summarize("summary-model")
+
+	// The first turn is summarized
+	messages := history()
+	fitted, err := strategy.Fit(ctx, messages, 80, perMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := contents(messages)
+	want := append([]string{all[0], "system: Summary of the earlier conversation:\nParis is sunny."}, all[5:]...)
+	if got := contents(fitted); !equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	checkPairs(t, fitted)
+	requests := provider.Requests()
+	if len(requests) != 1 || requests[0].Model != "summary-model" {
+		t.Fatalf("got %d requests, want 1 to the summary model", len(requests))
+	}
+
+	// The next turns extend the summary instead of rewriting it
+	messages = append(messages, llm.Message{Role: "assistant", Content: "Sunny too."}, llm.Message{Role: "user", Content: "Bye"})
+	fitted, err = strategy.Fit(ctx, messages, 80, perMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all = contents(messages)
+	want = append([]string{all[0], "system: Summary of the earlier conversation:\nParis is sunny, thanks were said."}, all[7:]...)
+	if got := contents(fitted); !equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	requests = provider.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	transcript := requests[1].Messages[len(requests[1].Messages)-1].Content
+	if !strings.HasPrefix(transcript, "Summary so far:\nParis is sunny.") || strings.Contains(transcript, "Weather in Paris?") ||
+		!strings.Contains(transcript, "user: Thanks") {
+		t.Errorf("got transcript %q, want the summary so far and the new turn only", transcript)
+	}
+
+	// The same turns don't need a new summary
+	if _, err := strategy.Fit(ctx, messages, 80, perMessage); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(provider.Requests()); n != 2 {
+		t.Errorf("got %d requests, want the summary reused", n)
+	}
+}
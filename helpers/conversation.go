@@ -0,0 +1,394 @@
+package llm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Conversation keeps the history of a chat so that every Send continues
+// where the previous one stopped. It takes the same optional parameters as
+// LLM: a system message, Options, tools, a *Client and budgets. Given a
+// ConversationStore it is saved after every Send.
+type Conversation struct {
+	config
+
+	mu        sync.Mutex
+	id        string
+	messages  []Message
+	meta      []MessageMeta // One per message
+	usage     UsageTotals
+	createdAt time.Time
+	updatedAt time.Time
+	store     ConversationStore
+}
+
+// NewConversation starts an empty conversation
+func NewConversation(opts ...interface{}) *Conversation {
+	now := time.Now()
+	c := &Conversation{
+		config:    newConfig(opts...),
+		id:        newConversationID(),
+		createdAt: now,
+		updatedAt: now,
+	}
+	for _, opt := range opts {
+		if store, ok := opt.(ConversationStore); ok {
+			c.store = store
+		}
+	}
+	return c
+}
+
+// LoadConversation restores a conversation from a store. The options are
+// the same as NewConversation, the stored system message, model and tools
+// take precedence. A record without them keeps the ones of the options.
+func LoadConversation(ctx context.Context, store ConversationStore, id string, opts ...interface{}) (*Conversation, error) {
+	record, err := store.Load(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading conversation %s: %w", id, err)
+	}
+	c := NewConversation(opts...)
+	c.store = store
+	c.setRecord(record)
+	return c, nil
+}
+
+// newConversationID returns a random ID
+func newConversationID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// ID returns the ID the conversation is stored under
+func (c *Conversation) ID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.id
+}
+
+// Usage returns the usage of every turn of the conversation
+func (c *Conversation) Usage() UsageTotals {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+// cloneMessage copies a message, tool calls included, so histories don't
+// share their messages
+func cloneMessage(m *Message) Message {
+	var toolCalls []*ToolCall
+	for _, tc := range m.ToolCalls {
+		toolCalls = append(toolCalls, proto.Clone(tc).(*ToolCall))
+	}
+	return Message{
+		Role:          m.Role,
+		Content:       m.Content,
+		ToolCalls:     toolCalls,
+		ToolCallID:    m.ToolCallID,
+		Reasoning:     m.Reasoning,
+		ReasoningData: m.ReasoningData,
+	}
+}
+
+// cloneMessages copies a list of messages
+func cloneMessages(messages []Message) []Message {
+	out := make([]Message, 0, len(messages))
+	for i := range messages {
+		out = append(out, cloneMessage(&messages[i]))
+	}
+	return out
+}
+
+// Send adds a user message, runs the tool loop and adds the answer to the
+// history. On error the history is left unchanged and the result holds the
+// transcript up to the failure. An error saving to the store is returned
+// with the result.
+func (c *Conversation) Send(ctx context.Context, text string) (*Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, err := c.send(ctx, text)
+	if err != nil {
+		return result, err
+	}
+	return result, c.autosave(ctx)
+}
+
+func (c *Conversation) send(ctx context.Context, text string) (*Result, error) {
+	ctx, usage := c.context(ctx)
+	result := &Result{Prompt: text, Client: c.client}
+	defer func() {
+		result.Usage = usage.get()
+	}()
+
+	// The system message is not part of the history so it can be changed
+	var messages []Message
+	if c.systemMessage != "" {
+		messages = append(messages, Message{
+			Role:    "system",
+			Content: c.systemMessage,
+		})
+	}
+	messages = append(messages, cloneMessages(c.messages)...)
+	messages = append(messages, Message{
+		Role:    "user",
+		Content: text,
+	})
+
+	sent := time.Now()
+	messages, response, err := toolLoop(ctx, c.client, messages, c.options, c.tools)
+	if err != nil {
+		return result.failed(messages, err)
+	}
+	result.choose(ctx, c.options, messages, response)
+	result.Usage = usage.get()
+
+	// Keep the turn, without the system message, and the selected answer
+	if c.systemMessage != "" {
+		messages = messages[1:]
+	}
+	if result.Selected < len(result.Candidates) {
+		messages = append(messages, cloneMessage(result.Candidates[result.Selected].Message))
+		if answer := &messages[len(messages)-1]; answer.Role == "" {
+			answer.Role = "assistant"
+		}
+	}
+
+	model := response.Model
+	if model == "" {
+		model = c.client.model(c.options)
+	}
+	now := time.Now()
+	for i := len(c.messages); i < len(messages); i++ {
+		meta := MessageMeta{CreatedAt: now}
+		switch {
+		case i == len(c.messages):
+			meta.CreatedAt = sent
+		case messages[i].Role == "assistant":
+			meta.Model = model
+		}
+		c.meta = append(c.meta, meta)
+	}
+	// The usage of the turn goes with its answer
+	if answer := len(messages) - 1; messages[answer].Role == "assistant" {
+		turn := result.Usage
+		c.meta[answer].Usage = &turn
+	}
+
+	c.messages = messages
+	c.usage.Add(result.Usage)
+	c.updatedAt = now
+	return result, nil
+}
+
+// Messages returns a copy of the history, without the system message
+func (c *Conversation) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cloneMessages(c.messages)
+}
+
+// SetMessages replaces the history
+func (c *Conversation) SetMessages(messages []Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = cloneMessages(messages)
+	c.meta = make([]MessageMeta, len(messages))
+	c.updatedAt = time.Now()
+	for i := range c.meta {
+		c.meta[i].CreatedAt = c.updatedAt
+	}
+}
+
+// System returns the system message
+func (c *Conversation) System() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.systemMessage
+}
+
+// SetSystem changes the system message used by the next requests
+func (c *Conversation) SetSystem(systemMessage string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.systemMessage = systemMessage
+}
+
+// Turns returns the number of user messages in the history
+func (c *Conversation) Turns() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(turnStarts(c.messages, 0))
+}
+
+// Rewind removes the last n turns, each made of a user message and
+// everything that followed it
+func (c *Conversation) Rewind(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rewind(n)
+}
+
+func (c *Conversation) rewind(n int) {
+	if n <= 0 {
+		return
+	}
+	starts := turnStarts(c.messages, 0)
+	c.updatedAt = time.Now()
+	if n >= len(starts) {
+		c.messages = nil
+		c.meta = nil
+		return
+	}
+	c.messages = c.messages[:starts[len(starts)-n]]
+	c.meta = c.meta[:starts[len(starts)-n]]
+}
+
+// EditLastTurn replaces the last user message with text and sends it again
+func (c *Conversation) EditLastTurn(ctx context.Context, text string) (*Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous, previousMeta, updatedAt := c.messages, c.meta, c.updatedAt
+	c.rewind(1)
+	result, err := c.send(ctx, text)
+	if err != nil {
+		// Leave the history as it was
+		c.messages, c.meta, c.updatedAt = previous, previousMeta, updatedAt
+		return result, err
+	}
+	return result, c.autosave(ctx)
+}
+
+// Fork returns an independent copy of the conversation sharing its
+// configuration. The copy gets a new ID and is saved to the same store.
+func (c *Conversation) Fork() *Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &Conversation{
+		config:    c.config,
+		id:        newConversationID(),
+		messages:  cloneMessages(c.messages),
+		meta:      append([]MessageMeta(nil), c.meta...),
+		usage:     c.usage,
+		createdAt: time.Now(),
+		updatedAt: time.Now(),
+		store:     c.store,
+	}
+}
+
+// Clear empties the history
+func (c *Conversation) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+	c.meta = nil
+	c.updatedAt = time.Now()
+}
+
+// Save writes the conversation to store
+func (c *Conversation) Save(ctx context.Context, store ConversationStore) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := store.Save(ctx, c.record()); err != nil {
+		return fmt.Errorf("error saving conversation %s: %w", c.id, err)
+	}
+	return nil
+}
+
+// autosave saves the conversation to its store, if it has one
+func (c *Conversation) autosave(ctx context.Context) error {
+	if c.store == nil {
+		return nil
+	}
+	if err := c.store.Save(ctx, c.record()); err != nil {
+		return fmt.Errorf("error saving conversation %s: %w", c.id, err)
+	}
+	return nil
+}
+
+// record returns the stored form of the conversation
+func (c *Conversation) record() *ConversationRecord {
+	messages := cloneMessages(c.messages)
+	stored := make([]StoredMessage, len(messages))
+	for i := range messages {
+		stored[i] = StoredMessage{Message: &messages[i], MessageMeta: c.meta[i]}
+	}
+	return &ConversationRecord{
+		ID:        c.id,
+		System:    c.systemMessage,
+		Model:     c.options.Model,
+		Tools:     c.tools,
+		Messages:  stored,
+		Usage:     c.usage,
+		CreatedAt: c.createdAt,
+		UpdatedAt: c.updatedAt,
+	}
+}
+
+// setRecord restores the conversation from its stored form. Other options
+// keep their current value.
+func (c *Conversation) setRecord(record *ConversationRecord) {
+	if c.client == nil {
+		c.client = DefaultClient
+	}
+	if record.ID != "" {
+		c.id = record.ID
+	}
+	// Records saved without them keep the ones the conversation was given
+	if record.System != "" {
+		c.systemMessage = record.System
+	}
+	if record.Model != "" {
+		c.options.Model = record.Model
+	}
+	if len(record.Tools) > 0 {
+		c.tools = record.Tools
+	}
+	c.usage = record.Usage
+	c.createdAt = record.CreatedAt
+	c.updatedAt = record.UpdatedAt
+
+	c.messages = make([]Message, 0, len(record.Messages))
+	c.meta = make([]MessageMeta, 0, len(record.Messages))
+	for _, m := range record.Messages {
+		if m.Message == nil {
+			continue
+		}
+		c.messages = append(c.messages, cloneMessage(m.Message))
+		c.meta = append(c.meta, m.MessageMeta)
+	}
+}
+
+// MarshalJSON encodes the conversation as a ConversationRecord
+func (c *Conversation) MarshalJSON() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return json.Marshal(c.record())
+}
+
+// UnmarshalJSON restores the ID, history, system message, model and tools.
+// Other options keep their current value.
+func (c *Conversation) UnmarshalJSON(data []byte) error {
+	var record ConversationRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.id == "" {
+		c.id = newConversationID()
+	}
+	c.setRecord(&record)
+	return nil
+}
@@ -0,0 +1,206 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+// contents returns the role and content of every message
+func contents(messages []llm.Message) []string {
+	var out []string
+	for i := range messages {
+		out = append(out, messages[i].Role+": "+messages[i].Content)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestLoadConversationKeepsOptions(t *testing.T) {
+	ctx := context.Background()
+	provider := fake.New()
+	provider.On("").Respond("Hello.")
+	client := &llm.Client{Provider: provider}
+	tool := llm.CreateTool("lookup", "Weather of a city", lookup)
+
+	store := llm.NewMemoryStore()
+	store.Save(ctx, &llm.ConversationRecord{ID: "without"})
+	store.Save(ctx, &llm.ConversationRecord{
+		ID:     "with",
+		System: "Stored system.",
+		Model:  "stored-model",
+		Tools:  []*llm.Tool{llm.CreateTool("forecast", "Forecast of a city", lookup)},
+	})
+
+	tests := []struct {
+		id, system, model, tool string
+	}{
+		{"without", "Given system.", "given-model", "lookup"},
+		{"with", "Stored system.", "stored-model", "forecast"},
+	}
+	for _, tt := range tests {
+		c, err := llm.LoadConversation(ctx, store, tt.id, client, "Given system.", llm.Options{Model: "given-model"}, tool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Send(ctx, "Hi"); err != nil {
+			t.Fatal(err)
+		}
+		requests := provider.Requests()
+		req := requests[len(requests)-1]
+		if req.Model != tt.model {
+			t.Errorf("%s: got model %q, want %q", tt.id, req.Model, tt.model)
+		}
+		if req.Messages[0].Role != "system" || req.Messages[0].Content != tt.system {
+			t.Errorf("%s: got first message %v, want the system message %q", tt.id, &req.Messages[0], tt.system)
+		}
+		if len(req.Tools) != 1 || req.Tools[0].Name != tt.tool {
+			t.Errorf("%s: got tools %v, want %s", tt.id, req.Tools, tt.tool)
+		}
+	}
+}
+
+func TestConversationSend(t *testing.T) {
+	ctx := context.Background()
+	provider := fake.New()
+	provider.On("Paris").CallTool("lookup", map[string]string{"city": "Paris"}).Respond("Sunny in Paris.")
+	provider.On("London").Respond("Rainy in London.")
+	c := llm.NewConversation(&llm.Client{Provider: provider}, "Be brief.", llm.CreateTool("lookup", "Weather of a city", lookup))
+
+	if _, err := c.Send(ctx, "Weather in Paris?"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.Send(ctx, "And London?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "Rainy in London." {
+		t.Errorf("got %q, want the second answer", result.Content)
+	}
+
+	want := []string{
+		"user: Weather in Paris?",
+		"assistant: ",
+		"tool: sunny in Paris",
+		"assistant: Sunny in Paris.",
+		"user: And London?",
+		"assistant: Rainy in London.",
+	}
+	if got := contents(c.Messages()); !equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if c.Turns() != 2 {
+		t.Errorf("got %d turns, want 2", c.Turns())
+	}
+	if usage := c.Usage(); usage.Requests != 3 {
+		t.Errorf("got %d requests, want 3", usage.Requests)
+	}
+
+	// The second request continues the history, after the system message
+	requests := provider.Requests()
+	sent := contents(requests[len(requests)-1].Messages)
+	if !equal(sent, append([]string{"system: Be brief."}, want[:5]...)) {
+		t.Errorf("got request %q, want the history", sent)
+	}
+}
+
+func TestConversationRewind(t *testing.T) {
+	ctx := context.Background()
+	provider := fake.New()
+	provider.On("Paris").CallTool("lookup", map[string]string{"city": "Paris"}).Respond("Sunny in Paris.")
+	provider.On("").Respond("Noted.")
+	c := llm.NewConversation(&llm.Client{Provider: provider}, llm.CreateTool("lookup", "Weather of a city", lookup))
+
+	for _, text := range []string{"Hello", "Weather in Paris?"} {
+		if _, err := c.Send(ctx, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The turn goes with its tool call and result
+	c.Rewind(1)
+	want := []string{"user: Hello", "assistant: Noted."}
+	if got := contents(c.Messages()); !equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	c.Rewind(5)
+	if got := c.Messages(); len(got) != 0 {
+		t.Errorf("got %q, want an empty history", contents(got))
+	}
+}
+
+func TestConversationFork(t *testing.T) {
+	ctx := context.Background()
+	provider := fake.New()
+	provider.On("Paris").CallTool("lookup", map[string]string{"city": "Paris"}).Respond("Sunny in Paris.")
+	provider.On("").Respond("Noted.")
+	c := llm.NewConversation(&llm.Client{Provider: provider}, llm.CreateTool("lookup", "Weather of a city", lookup))
+	if _, err := c.Send(ctx, "Weather in Paris?"); err != nil {
+		t.Fatal(err)
+	}
+
+	fork := c.Fork()
+	if fork.ID() == c.ID() {
+		t.Error("the fork has the ID of the conversation")
+	}
+	if _, err := fork.Send(ctx, "Thanks"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Turns() != 1 || fork.Turns() != 2 {
+		t.Errorf("got %d and %d turns, want 1 and 2", c.Turns(), fork.Turns())
+	}
+
+	// Tool calls are copied, not shared
+	messages := fork.Messages()
+	fork.SetMessages(messages)
+	messages[1].ToolCalls[0].Function.Arguments = `{"city":"Rome"}`
+	for _, history := range [][]llm.Message{c.Messages(), fork.Messages()} {
+		if args := history[1].ToolCalls[0].Function.Arguments; args != `{"city":"Paris"}` {
+			t.Errorf("got arguments %s, want the ones of the call", args)
+		}
+	}
+}
+
+func TestConversationEditLastTurn(t *testing.T) {
+	ctx := context.Background()
+	provider := fake.New()
+	provider.On("Paris").Respond("Sunny in Paris.")
+	provider.On("Rome").Respond("Sunny in Rome.")
+	provider.On("Oslo").Fail(errors.New("unavailable"))
+	c := llm.NewConversation(&llm.Client{Provider: provider})
+
+	for _, text := range []string{"Hello Paris", "Weather in Paris?"} {
+		if _, err := c.Send(ctx, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.EditLastTurn(ctx, "Weather in Rome?"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"user: Hello Paris", "assistant: Sunny in Paris.", "user: Weather in Rome?", "assistant: Sunny in Rome."}
+	if got := contents(c.Messages()); !equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A failed edit leaves the history as it was
+	if _, err := c.EditLastTurn(ctx, "Weather in Oslo?"); err == nil {
+		t.Fatal("got no error, want the one of the provider")
+	}
+	if got := contents(c.Messages()); !equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
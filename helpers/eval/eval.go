@@ -0,0 +1,428 @@
+// Package eval runs LLM functions over datasets and scores their answers, so
+// changes to prompts and models can be compared:
+//
+//	assistant := llm.LLMWithResult(prompt, "You are a weather assistant", getWeather)
+//	e := &eval.Eval{
+//		Name:    "weather",
+//		Dataset: dataset,
+//		Target:  assistant,
+//		Metrics: []eval.Metric{eval.ToolCalled("getWeather", nil), eval.Rubric("Gives the temperature")},
+//	}
+//	report, err := e.Run(ctx)
+//	fmt.Println(eval.Compare(previous, report))
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+// Case is an input of a dataset with what is expected of the answer
+type Case struct {
+	Name     string            `json:"name,omitempty"` // Identifies the case in comparisons, defaults to the input
+	Input    string            `json:"input"`
+	Expected string            `json:"expected,omitempty"` // Reference answer used by the metrics
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ID returns the name of the case, or its input
+func (c *Case) ID() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Input
+}
+
+// Dataset is a list of cases
+type Dataset struct {
+	Name  string `json:"name,omitempty"`
+	Cases []Case `json:"cases"`
+}
+
+// LoadDataset reads a dataset from a JSON file holding a Dataset or a list of
+// cases, or from a JSONL file with one case per line
+func LoadDataset(path string) (*Dataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dataset: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	dataset := &Dataset{Name: name}
+
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case filepath.Ext(path) == ".jsonl":
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			var c Case
+			if err := json.Unmarshal(text, &c); err != nil {
+				return nil, fmt.Errorf("error decoding case on line %d: %w", line, err)
+			}
+			dataset.Cases = append(dataset.Cases, c)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading dataset: %w", err)
+		}
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &dataset.Cases); err != nil {
+			return nil, fmt.Errorf("error decoding dataset: %w", err)
+		}
+	default:
+		if err := json.Unmarshal(trimmed, dataset); err != nil {
+			return nil, fmt.Errorf("error decoding dataset: %w", err)
+		}
+		if dataset.Name == "" {
+			dataset.Name = name
+		}
+	}
+	return dataset, nil
+}
+
+// Target is the function evaluated, as returned by llm.LLMWithResult
+type Target func(ctx context.Context, input string) (*llm.Result, error)
+
+// Eval runs a target over a dataset and scores every answer with the metrics
+type Eval struct {
+	Name        string
+	Dataset     *Dataset
+	Target      Target
+	Metrics     []Metric
+	Concurrency int // Cases run at once, defaults to 4
+}
+
+// defaultConcurrency is the number of cases run at once when
+// Eval.Concurrency is not set
+const defaultConcurrency = 4
+
+// Run runs every case and returns the report. Errors of the target are
+// recorded in the report; Run only fails when ctx is done.
+func (e *Eval) Run(ctx context.Context) (*Report, error) {
+	if e.Dataset == nil || e.Target == nil {
+		return nil, fmt.Errorf("eval needs a dataset and a target")
+	}
+	concurrency := e.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	report := &Report{
+		Name:      e.Name,
+		Dataset:   e.Dataset.Name,
+		StartedAt: time.Now(),
+		Results:   make([]*CaseResult, len(e.Dataset.Cases)),
+	}
+	for _, metric := range e.Metrics {
+		report.Metrics = append(report.Metrics, metric.Name())
+	}
+
+	var wg sync.WaitGroup
+	queue := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				report.Results[i] = e.runCase(ctx, e.Dataset.Cases[i])
+			}
+		}()
+	}
+	for i := range e.Dataset.Cases {
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	report.Duration = time.Since(report.StartedAt)
+	report.summarize()
+	return report, nil
+}
+
+// runCase runs the target on a case and scores the answer
+func (e *Eval) runCase(ctx context.Context, c Case) *CaseResult {
+	start := time.Now()
+	result, err := e.Target(ctx, c.Input)
+	sample := &Sample{Case: c, Result: result, Err: err}
+	if result != nil {
+		sample.Output = result.Content
+	}
+
+	out := &CaseResult{
+		Case:     c,
+		Output:   sample.Output,
+		Scores:   make(map[string]Score),
+		Duration: time.Since(start),
+	}
+	if result != nil {
+		out.Usage = result.Usage
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+
+	// Judges without a client use the one of the target
+	if result != nil && result.Client != nil {
+		ctx = llm.WithClient(ctx, result.Client)
+	}
+	for _, metric := range e.Metrics {
+		score, err := metric.Score(ctx, sample)
+		if err != nil {
+			score = Score{Reason: fmt.Sprintf("error scoring: %v", err)}
+		}
+		out.Scores[metric.Name()] = score
+	}
+	return out
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Name      string                   `json:"name"`
+	Dataset   string                   `json:"dataset"`
+	StartedAt time.Time                `json:"started_at"`
+	Duration  time.Duration            `json:"duration"`
+	Metrics   []string                 `json:"metrics"` // In the order they were given
+	Summary   map[string]MetricSummary `json:"summary"`
+	Usage     llm.UsageTotals          `json:"usage"`
+	Errors    int                      `json:"errors"` // Cases where the target failed
+	Results   []*CaseResult            `json:"results"`
+}
+
+// CaseResult is the answer to a case and its scores
+type CaseResult struct {
+	Case     Case             `json:"case"`
+	Output   string           `json:"output"`
+	Error    string           `json:"error,omitempty"`
+	Scores   map[string]Score `json:"scores"`
+	Usage    llm.UsageTotals  `json:"usage"`
+	Duration time.Duration    `json:"duration"`
+}
+
+// MetricSummary aggregates the scores of a metric over the cases
+type MetricSummary struct {
+	Mean     float64 `json:"mean"`
+	PassRate float64 `json:"pass_rate"`
+	Count    int     `json:"count"`
+}
+
+// summarize fills the summary and totals of the report
+func (r *Report) summarize() {
+	r.Summary = make(map[string]MetricSummary)
+	r.Usage = llm.UsageTotals{}
+	r.Errors = 0
+	for _, result := range r.Results {
+		if result == nil {
+			continue
+		}
+		r.Usage.Add(result.Usage)
+		if result.Error != "" {
+			r.Errors++
+		}
+	}
+	for _, name := range r.Metrics {
+		var summary MetricSummary
+		passed := 0
+		for _, result := range r.Results {
+			if result == nil {
+				continue
+			}
+			score, ok := result.Scores[name]
+			if !ok {
+				continue
+			}
+			summary.Count++
+			summary.Mean += score.Value
+			if score.Pass {
+				passed++
+			}
+		}
+		if summary.Count > 0 {
+			summary.Mean /= float64(summary.Count)
+			summary.PassRate = float64(passed) / float64(summary.Count)
+		}
+		r.Summary[name] = summary
+	}
+}
+
+// Save writes the report as JSON
+func (r *Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
+
+// LoadReport reads a report written by Save
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %w", err)
+	}
+	report := &Report{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("error decoding report: %w", err)
+	}
+	return report, nil
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s on %s: %d cases, %d errors, %d tokens, $%.4f, %s\n",
+		r.Name, r.Dataset, len(r.Results), r.Errors, r.Usage.TotalTokens, r.Usage.Cost, r.Duration.Round(time.Millisecond))
+	for _, name := range r.Metrics {
+		summary := r.Summary[name]
+		fmt.Fprintf(&b, "  %-24s mean %.3f  pass %5.1f%%\n", name, summary.Mean, summary.PassRate*100)
+	}
+	return b.String()
+}
+
+// Comparison holds the differences between two runs on the same dataset
+type Comparison struct {
+	Base, Head   string
+	Metrics      []MetricDelta
+	Regressions  []CaseDelta // Cases that passed in the base and fail in the head
+	Improvements []CaseDelta // Cases that failed in the base and pass in the head
+}
+
+// MetricDelta is the change of a metric between two runs
+type MetricDelta struct {
+	Metric        string
+	Base, Head    MetricSummary
+	MeanDelta     float64
+	PassRateDelta float64
+	Only          string // "base" or "head" when the metric was only in one run
+}
+
+// CaseDelta is the change of the score of a case
+type CaseDelta struct {
+	Case       string
+	Metric     string
+	Base, Head Score
+}
+
+// Compare compares a run with a base run. Cases are matched by ID.
+func Compare(base, head *Report) *Comparison {
+	c := &Comparison{Base: base.Name, Head: head.Name}
+
+	names := append([]string(nil), head.Metrics...)
+	for _, name := range base.Metrics {
+		if _, ok := head.Summary[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		b, inBase := base.Summary[name]
+		h, inHead := head.Summary[name]
+		delta := MetricDelta{Metric: name, Base: b, Head: h}
+		switch {
+		case !inBase:
+			delta.Only = "head"
+		case !inHead:
+			delta.Only = "base"
+		default:
+			delta.MeanDelta = h.Mean - b.Mean
+			delta.PassRateDelta = h.PassRate - b.PassRate
+		}
+		c.Metrics = append(c.Metrics, delta)
+	}
+
+	baseCases := make(map[string]*CaseResult)
+	for _, result := range base.Results {
+		if result != nil {
+			baseCases[result.Case.ID()] = result
+		}
+	}
+	for _, result := range head.Results {
+		if result == nil {
+			continue
+		}
+		before, ok := baseCases[result.Case.ID()]
+		if !ok {
+			continue
+		}
+		for _, name := range head.Metrics {
+			b, okBase := before.Scores[name]
+			h, okHead := result.Scores[name]
+			if !okBase || !okHead || b.Pass == h.Pass {
+				continue
+			}
+			delta := CaseDelta{Case: result.Case.ID(), Metric: name, Base: b, Head: h}
+			if b.Pass {
+				c.Regressions = append(c.Regressions, delta)
+			} else {
+				c.Improvements = append(c.Improvements, delta)
+			}
+		}
+	}
+	sortDeltas(c.Regressions)
+	sortDeltas(c.Improvements)
+	return c
+}
+
+func sortDeltas(deltas []CaseDelta) {
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].Case != deltas[j].Case {
+			return deltas[i].Case < deltas[j].Case
+		}
+		return deltas[i].Metric < deltas[j].Metric
+	})
+}
+
+func (c *Comparison) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s -> %s\n", c.Base, c.Head)
+	for _, m := range c.Metrics {
+		switch m.Only {
+		case "head":
+			fmt.Fprintf(&b, "  %-24s new: mean %.3f  pass %5.1f%%\n", m.Metric, m.Head.Mean, m.Head.PassRate*100)
+		case "base":
+			fmt.Fprintf(&b, "  %-24s removed\n", m.Metric)
+		default:
+			fmt.Fprintf(&b, "  %-24s mean %.3f -> %.3f (%+.3f)  pass %5.1f%% -> %5.1f%% (%+.1f)\n",
+				m.Metric, m.Base.Mean, m.Head.Mean, m.MeanDelta,
+				m.Base.PassRate*100, m.Head.PassRate*100, m.PassRateDelta*100)
+		}
+	}
+	for _, d := range c.Regressions {
+		fmt.Fprintf(&b, "  regressed: %s [%s] %s\n", truncate(d.Case, 60), d.Metric, d.Head.Reason)
+	}
+	for _, d := range c.Improvements {
+		fmt.Fprintf(&b, "  improved:  %s [%s]\n", truncate(d.Case, 60), d.Metric)
+	}
+	return b.String()
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(strings.Join(strings.Fields(s), " "))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n-3]) + "..."
+}
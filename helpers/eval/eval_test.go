@@ -0,0 +1,95 @@
+package eval_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/eval"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+func ask(question string) string {
+	return question
+}
+
+func TestLoadDataset(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file, content string
+		name          string
+		inputs        []string
+	}{
+		{"math.json", `{"name": "arithmetic", "cases": [{"input": "2+2", "expected": "4"}]}`, "arithmetic", []string{"2+2"}},
+		{"named.json", `{"cases": [{"input": "2+2"}]}`, "named", []string{"2+2"}},
+		{"list.json", ` [{"input": "2+2"}, {"input": "3+3"}]`, "list", []string{"2+2", "3+3"}},
+		{"lines.jsonl", "{\"input\": \"2+2\"}\n\n{\"input\": \"3+3\", \"metadata\": {\"level\": \"easy\"}}\n", "lines", []string{"2+2", "3+3"}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		dataset, err := eval.LoadDataset(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.file, err)
+		}
+		var inputs []string
+		for _, c := range dataset.Cases {
+			inputs = append(inputs, c.Input)
+		}
+		if dataset.Name != tt.name || strings.Join(inputs, ",") != strings.Join(tt.inputs, ",") {
+			t.Errorf("%s: got %q with %q, want %q with %q", tt.file, dataset.Name, inputs, tt.name, tt.inputs)
+		}
+	}
+
+	path := filepath.Join(dir, "broken.jsonl")
+	os.WriteFile(path, []byte("{\"input\": \"2+2\"}\n{\"input\": \n"), 0o644)
+	if _, err := eval.LoadDataset(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v, want the line of the broken case", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	provider := fake.New()
+	provider.On("Rubric:").Respond(`{"score": 10, "rationale": "Correct."}`)
+	provider.On("2+2").Respond("4")
+	provider.On("3+3").Respond("7")
+	provider.On("fail").Fail(errors.New("down"))
+	target := llm.LLMWithResult(ask, &llm.Client{Provider: provider})
+
+	e := &eval.Eval{
+		Name: "math",
+		Dataset: &eval.Dataset{Name: "arithmetic", Cases: []eval.Case{
+			{Input: "2+2", Expected: "4"},
+			{Input: "3+3", Expected: "6"},
+			{Input: "fail", Expected: "0"},
+		}},
+		Target: eval.Target(target),
+		// The judge has no client, it uses the one of the target
+		Metrics: []eval.Metric{eval.ExactMatch(), eval.Rubric("Gives the sum")},
+	}
+	report, err := e.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Errors != 1 || report.Results[2].Error == "" {
+		t.Errorf("got %d errors, want the failed case", report.Errors)
+	}
+	exact := report.Summary["exact_match"]
+	if exact.Count != 3 || exact.PassRate != 1.0/3 {
+		t.Errorf("got exact match %+v, want 1 of 3 passing", exact)
+	}
+	rubric := report.Results[0].Scores["rubric:Gives the sum"]
+	if !rubric.Pass || rubric.Value != 1 || rubric.Reason != "Correct." {
+		t.Errorf("got rubric score %+v, want the verdict of the judge", rubric)
+	}
+	if !strings.Contains(report.Results[2].Scores["rubric:Gives the sum"].Reason, "target failed") {
+		t.Errorf("got %+v, want the failed target to score 0", report.Results[2].Scores)
+	}
+}
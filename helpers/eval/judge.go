@@ -0,0 +1,301 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+// gradeSystem is the system message of the judge when grading an answer
+const gradeSystem = `You grade answers to questions against a rubric. Reply with a JSON object only: {"score": <integer from 1 to %d>, "rationale": "<one or two sentences>"}.`
+
+// selectSystem is the system message of the judge when picking a candidate
+const selectSystem = `You pick the best of several answers to a question against a rubric. Judge the content only: ignore the order of the answers and their length. Reply with a JSON object only: {"best": <number of the best answer>, "rationale": "<one or two sentences>"}.`
+
+// defaultRubric judges answers when the judge has no rubric
+const defaultRubric = "The answer is accurate, helpful and complete."
+
+// compareSystem is the system message of the judge when comparing answers
+const compareSystem = `You compare two answers to a question against a rubric and pick the better one. Judge the content only: ignore the order of the answers and their length. Reply with a JSON object only: {"winner": "A", "B" or "tie", "rationale": "<one or two sentences>"}.`
+
+// Judge grades answers with a model following a rubric. Without a client
+// in its options, the judge uses the client of the function it judges: the
+// one on the context (see llm.WithClient), which Eval sets to the client of
+// the target. A Judge is also a llm.Selector, picking among the candidates
+// of Options.Top.
+//
+//	judge := eval.NewJudge("Answers the question with the current temperature", llm.Options{Model: "openai/gpt-4o"})
+//	verdict, err := judge.Grade(ctx, question, answer, "")
+//	ask := llm.LLM(prompt, llm.Options{Top: 3, Selector: judge})
+type Judge struct {
+	Rubric    string        // Defaults to judging overall quality
+	Scale     int           // Highest score, defaults to 10
+	PassScore float64       // Lowest passing score, defaults to 70% of the scale
+	Options   []interface{} // Optional parameters of llm.LLM for the judge, like its client and options
+}
+
+// NewJudge returns a judge for rubric. The optional parameters are the ones
+// of llm.LLM, to pick the client and model of the judge.
+func NewJudge(rubric string, opts ...interface{}) *Judge {
+	return &Judge{Rubric: rubric, Options: opts}
+}
+
+func (j *Judge) rubric() string {
+	if j.Rubric != "" {
+		return j.Rubric
+	}
+	return defaultRubric
+}
+
+func (j *Judge) scale() int {
+	if j.Scale > 1 {
+		return j.Scale
+	}
+	return 10
+}
+
+func (j *Judge) passScore() float64 {
+	if j.PassScore > 0 {
+		return j.PassScore
+	}
+	return 0.7 * float64(j.scale())
+}
+
+// Verdict is the grade of an answer by a judge
+type Verdict struct {
+	Score     float64         `json:"score"`     // Between 1 and the scale of the judge
+	Value     float64         `json:"value"`     // The score scaled to 0 to 1
+	Pass      bool            `json:"pass"`      // Whether the score reaches the pass score
+	Rationale string          `json:"rationale"` // Why the judge gave this score
+	Usage     llm.UsageTotals `json:"usage"`
+}
+
+// Grade grades an answer to input. The reference answer is optional.
+func (j *Judge) Grade(ctx context.Context, input, answer, reference string) (*Verdict, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Rubric:\n%s\n\nQuestion:\n%s\n\n", j.rubric(), input)
+	if reference != "" {
+		fmt.Fprintf(&prompt, "Reference answer:\n%s\n\n", reference)
+	}
+	fmt.Fprintf(&prompt, "Answer to grade:\n%s", answer)
+
+	var reply struct {
+		Score     float64 `json:"score"`
+		Rationale string  `json:"rationale"`
+		Reason    string  `json:"reason"` // Models sometimes rename the field
+	}
+	usage, err := j.ask(ctx, fmt.Sprintf(gradeSystem, j.scale()), prompt.String(), &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := float64(j.scale())
+	score := max(1, min(scale, reply.Score))
+	verdict := &Verdict{
+		Score:     score,
+		Value:     (score - 1) / (scale - 1),
+		Pass:      score >= j.passScore(),
+		Rationale: reply.Rationale,
+		Usage:     usage,
+	}
+	if verdict.Rationale == "" {
+		verdict.Rationale = reply.Reason
+	}
+	return verdict, nil
+}
+
+// Preference is the outcome of comparing two answers
+type Preference struct {
+	Winner     string          `json:"winner"`     // "a", "b" or "tie"
+	Consistent bool            `json:"consistent"` // Both orders picked the same winner
+	Rationale  string          `json:"rationale"`
+	Usage      llm.UsageTotals `json:"usage"`
+}
+
+// Compare compares two answers to input. Models tend to prefer the answer
+// they read first, so the answers are judged in both orders. When the two
+// orders disagree the answers are called a tie; when one of them is a tie
+// the other decides, but the preference is not consistent.
+func (j *Judge) Compare(ctx context.Context, input, a, b, reference string) (*Preference, error) {
+	type outcome struct {
+		winner    string
+		rationale string
+		usage     llm.UsageTotals
+		err       error
+	}
+	var outcomes [2]outcome
+	var wg sync.WaitGroup
+	for i, pair := range [2][2]string{{a, b}, {b, a}} {
+		wg.Add(1)
+		go func(i int, first, second string) {
+			defer wg.Done()
+			o := &outcomes[i]
+			o.winner, o.rationale, o.usage, o.err = j.compareOnce(ctx, input, first, second, reference)
+			// Map the winner of the swapped order back to a and b
+			if i == 1 {
+				switch o.winner {
+				case "a":
+					o.winner = "b"
+				case "b":
+					o.winner = "a"
+				}
+			}
+		}(i, pair[0], pair[1])
+	}
+	wg.Wait()
+
+	preference := &Preference{}
+	for _, o := range outcomes {
+		if o.err != nil {
+			return nil, o.err
+		}
+		preference.Usage.Add(o.usage)
+	}
+
+	first, second := outcomes[0], outcomes[1]
+	switch {
+	case first.winner == second.winner:
+		preference.Winner = first.winner
+		preference.Consistent = true
+		preference.Rationale = first.rationale
+	case first.winner == "tie":
+		preference.Winner = second.winner
+		preference.Rationale = second.rationale
+	case second.winner == "tie":
+		preference.Winner = first.winner
+		preference.Rationale = first.rationale
+	default:
+		preference.Winner = "tie"
+		preference.Rationale = "The judge preferred whichever answer came first: " + first.rationale
+	}
+	return preference, nil
+}
+
+// compareOnce asks the judge which of first and second is better, answering
+// "a" for first
+func (j *Judge) compareOnce(ctx context.Context, input, first, second, reference string) (string, string, llm.UsageTotals, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Rubric:\n%s\n\nQuestion:\n%s\n\n", j.rubric(), input)
+	if reference != "" {
+		fmt.Fprintf(&prompt, "Reference answer:\n%s\n\n", reference)
+	}
+	fmt.Fprintf(&prompt, "Answer A:\n%s\n\nAnswer B:\n%s", first, second)
+
+	var reply struct {
+		Winner    string `json:"winner"`
+		Rationale string `json:"rationale"`
+	}
+	usage, err := j.ask(ctx, compareSystem, prompt.String(), &reply)
+	if err != nil {
+		return "", "", usage, err
+	}
+	switch strings.ToLower(strings.TrimSpace(reply.Winner)) {
+	case "a", "answer a":
+		return "a", reply.Rationale, usage, nil
+	case "b", "answer b":
+		return "b", reply.Rationale, usage, nil
+	case "tie", "none", "equal", "both":
+		return "tie", reply.Rationale, usage, nil
+	}
+	return "", "", usage, fmt.Errorf("error decoding judge verdict: unknown winner %q", reply.Winner)
+}
+
+// Select asks the judge which candidate answers prompt best, so a Judge can
+// be the llm.Selector of Options.Top
+func (j *Judge) Select(ctx context.Context, prompt string, candidates []llm.Candidate) (int, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Rubric:\n%s\n\nQuestion:\n%s", j.rubric(), prompt)
+	for i := range candidates {
+		fmt.Fprintf(&sb, "\n\nAnswer %d:\n%s", i+1, candidates[i].Content)
+	}
+
+	var reply struct {
+		Best      int    `json:"best"`
+		Rationale string `json:"rationale"`
+	}
+	if _, err := j.ask(ctx, selectSystem, sb.String(), &reply); err != nil {
+		return 0, err
+	}
+	if reply.Best < 1 || reply.Best > len(candidates) {
+		return 0, fmt.Errorf("error decoding judge verdict: no answer %d", reply.Best)
+	}
+	return reply.Best - 1, nil
+}
+
+// ask sends a prompt to the judge model and decodes its JSON reply. The
+// client on the context goes first so one in the options of the judge wins.
+func (j *Judge) ask(ctx context.Context, system, prompt string, reply interface{}) (llm.UsageTotals, error) {
+	messages := []llm.Message{
+		{Role: "system", Content: system},
+		{Role: "user", Content: prompt},
+	}
+	opts := append([]interface{}{llm.ClientFrom(ctx)}, j.Options...)
+	result, err := llm.Chat(ctx, messages, opts...)
+	if err != nil {
+		return llm.UsageTotals{}, fmt.Errorf("error calling judge: %w", err)
+	}
+	if err := decodeJSON(result.Content, reply); err != nil {
+		return result.Usage, fmt.Errorf("error decoding judge verdict: %w", err)
+	}
+	return result.Usage, nil
+}
+
+// Rubric grades answers with a Judge following rubric, scaled to 0 to 1.
+// Answers scoring 7 out of 10 or more pass. The optional parameters are the
+// ones of llm.LLM, to pick the model of the judge and a client other than
+// the one of the target.
+func Rubric(rubric string, opts ...interface{}) Metric {
+	return JudgeMetric(NewJudge(rubric, opts...))
+}
+
+// JudgeMetric grades answers with judge, comparing them to the expected
+// answer of the case when there is one
+func JudgeMetric(judge *Judge) Metric {
+	return MetricFunc("rubric:"+truncate(judge.rubric(), 40), func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		verdict, err := judge.Grade(ctx, sample.Case.Input, sample.Output, sample.Case.Expected)
+		if err != nil {
+			return Score{}, err
+		}
+		return Score{Value: verdict.Value, Pass: verdict.Pass, Reason: verdict.Rationale}, nil
+	})
+}
+
+// Pairwise compares answers with the expected answer of the case using
+// judge. Wins score 1, ties 0.5 and both pass.
+func Pairwise(judge *Judge) Metric {
+	return MetricFunc("pairwise:"+truncate(judge.rubric(), 40), func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		preference, err := judge.Compare(ctx, sample.Case.Input, sample.Output, sample.Case.Expected, "")
+		if err != nil {
+			return Score{}, err
+		}
+		switch preference.Winner {
+		case "a":
+			return Score{Value: 1, Pass: true, Reason: preference.Rationale}, nil
+		case "tie":
+			return Score{Value: 0.5, Pass: true, Reason: preference.Rationale}, nil
+		}
+		return Score{Reason: preference.Rationale}, nil
+	})
+}
+
+// decodeJSON decodes the first JSON object of a model answer, which may be
+// wrapped in a code fence or text
+func decodeJSON(text string, v interface{}) error {
+	text = stripFences(text)
+	start := strings.IndexByte(text, '{')
+	end := strings.LastIndexByte(text, '}')
+	if start < 0 || end < start {
+		return fmt.Errorf("no JSON object in %q", truncate(text, 80))
+	}
+	return json.Unmarshal([]byte(text[start:end+1]), v)
+}
@@ -0,0 +1,123 @@
+package eval_test
+
+import (
+	"context"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/eval"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+// judging returns a client whose model replies reply to every request
+func judging(reply string) *llm.Client {
+	provider := fake.New()
+	provider.On("").Respond(reply)
+	return &llm.Client{Provider: provider}
+}
+
+func TestJudgeGrade(t *testing.T) {
+	tests := []struct {
+		reply     string
+		score     float64
+		value     float64
+		pass      bool
+		rationale string
+	}{
+		{`{"score": 8, "rationale": "Good."}`, 8, 7.0 / 9, true, "Good."},
+		{"```json\n{\"score\": 5, \"reason\": \"Vague.\"}\n```", 5, 4.0 / 9, false, "Vague."},
+		{`My verdict: {"score": 14, "rationale": "Perfect."} Thanks.`, 10, 1, true, "Perfect."},
+		{`{"score": 0, "rationale": "Wrong."}`, 1, 0, false, "Wrong."},
+	}
+	for _, tt := range tests {
+		judge := eval.NewJudge("Gives the weather", judging(tt.reply))
+		verdict, err := judge.Grade(context.Background(), "Weather in Paris?", "Sunny", "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.reply, err)
+		}
+		if verdict.Score != tt.score || verdict.Value != tt.value || verdict.Pass != tt.pass || verdict.Rationale != tt.rationale {
+			t.Errorf("%s: got %+v, want score %v, value %v, pass %v", tt.reply, verdict, tt.score, tt.value, tt.pass)
+		}
+	}
+
+	judge := eval.NewJudge("Gives the weather", judging("I can't grade this."))
+	if _, err := judge.Grade(context.Background(), "Weather in Paris?", "Sunny", ""); err == nil {
+		t.Error("got no error, want the reply without JSON to fail")
+	}
+}
+
+// preferring returns a client whose model picks the answer containing
+// favorite, wherever it is, or replies tie
+func preferring(favorite string) *llm.Client {
+	provider := fake.New()
+	provider.On("Answer A:\n" + favorite).Respond(`{"winner": "A", "rationale": "A is right."}`)
+	provider.On("Answer B:\n" + favorite).Respond(`{"winner": "B", "rationale": "B is right."}`)
+	provider.On("").Respond(`{"winner": "tie", "rationale": "Same."}`)
+	return &llm.Client{Provider: provider}
+}
+
+// halfTie returns a client whose model picks favorite when it comes first
+// and calls a tie otherwise
+func halfTie(favorite string) *llm.Client {
+	provider := fake.New()
+	provider.On("Answer A:\n" + favorite).Respond(`{"winner": "A"}`)
+	provider.On("").Respond(`{"winner": "tie"}`)
+	return &llm.Client{Provider: provider}
+}
+
+func TestJudgeCompare(t *testing.T) {
+	tests := []struct {
+		name       string
+		client     *llm.Client
+		a, b       string
+		winner     string
+		consistent bool
+	}{
+		{"first", preferring("Sunny"), "Sunny", "Rainy", "a", true},
+		{"second", preferring("Sunny"), "Rainy", "Sunny", "b", true},
+		{"neither", preferring("Sunny"), "Rainy", "Cloudy", "tie", true},
+		// Always picking the first answer is no preference
+		{"position bias", judging(`{"winner": "A"}`), "Sunny", "Rainy", "tie", false},
+		// A tie in one order, the other order decides
+		{"half tie", halfTie("Rainy"), "Sunny", "Rainy", "b", false},
+	}
+	for _, tt := range tests {
+		judge := eval.NewJudge("Gives the weather", tt.client)
+		preference, err := judge.Compare(context.Background(), "Weather in Paris?", tt.a, tt.b, "")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if preference.Winner != tt.winner || preference.Consistent != tt.consistent {
+			t.Errorf("%s: got %+v, want winner %s, consistent %v", tt.name, preference, tt.winner, tt.consistent)
+		}
+		if preference.Usage.Requests != 2 {
+			t.Errorf("%s: got %d requests, want both orders", tt.name, preference.Usage.Requests)
+		}
+	}
+
+	judge := eval.NewJudge("Gives the weather", judging(`{"winner": "C"}`))
+	if _, err := judge.Compare(context.Background(), "Weather in Paris?", "Sunny", "Rainy", ""); err == nil {
+		t.Error("got no error, want an unknown winner to fail")
+	}
+}
+
+func TestJudgeSelect(t *testing.T) {
+	var _ llm.Selector = &eval.Judge{}
+	candidates := []llm.Candidate{{Content: "Lyon"}, {Content: "Paris"}, {Content: "Nice"}}
+
+	// Without a client of its own, the judge uses the one on the context
+	judge := eval.NewJudge("Names the capital")
+	ctx := llm.WithClient(context.Background(), judging(`{"best": 2, "rationale": "Paris is the capital."}`))
+	selected, err := judge.Select(ctx, "Capital of France?", candidates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected != 1 {
+		t.Errorf("got candidate %d, want 1", selected)
+	}
+
+	ctx = llm.WithClient(context.Background(), judging(`{"best": 4}`))
+	if _, err := judge.Select(ctx, "Capital of France?", candidates); err == nil {
+		t.Error("got no error, want an answer out of range to fail")
+	}
+}
@@ -0,0 +1,260 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+// Sample is the answer to a case, as given to the metrics
+type Sample struct {
+	Case   Case
+	Output string      // The content of the answer
+	Result *llm.Result // nil when the target failed without a result
+	Err    error       // The error of the target
+}
+
+// ToolCalls returns the tool calls made while answering, in order
+func (s *Sample) ToolCalls() []*llm.ToolCall {
+	if s.Result == nil {
+		return nil
+	}
+	var calls []*llm.ToolCall
+	for i := range s.Result.Messages {
+		m := &s.Result.Messages[i]
+		if m.Role != "assistant" {
+			continue
+		}
+		for _, tc := range m.ToolCalls {
+			if tc != nil && tc.Function != nil {
+				calls = append(calls, tc)
+			}
+		}
+	}
+	return calls
+}
+
+// Score is the grade of an answer, between 0 and 1
+type Score struct {
+	Value  float64 `json:"value"`
+	Pass   bool    `json:"pass"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// Metric grades answers
+type Metric interface {
+	Name() string
+	Score(ctx context.Context, sample *Sample) (Score, error)
+}
+
+// MetricFunc adapts a function to the Metric interface
+func MetricFunc(name string, fn func(ctx context.Context, sample *Sample) (Score, error)) Metric {
+	return &metricFunc{name: name, fn: fn}
+}
+
+type metricFunc struct {
+	name string
+	fn   func(ctx context.Context, sample *Sample) (Score, error)
+}
+
+func (m *metricFunc) Name() string {
+	return m.name
+}
+
+func (m *metricFunc) Score(ctx context.Context, sample *Sample) (Score, error) {
+	return m.fn(ctx, sample)
+}
+
+// boolScore is a score that passes or fails
+func boolScore(pass bool, reason string) Score {
+	if pass {
+		return Score{Value: 1, Pass: true}
+	}
+	return Score{Reason: reason}
+}
+
+// failed is the score of a sample whose target failed
+func failed(sample *Sample) (Score, bool) {
+	if sample.Err == nil {
+		return Score{}, false
+	}
+	return Score{Reason: fmt.Sprintf("target failed: %v", sample.Err)}, true
+}
+
+// ExactMatch passes when the answer is the expected one, ignoring case and
+// surrounding whitespace
+func ExactMatch() Metric {
+	return MetricFunc("exact_match", func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		pass := strings.EqualFold(strings.TrimSpace(sample.Output), strings.TrimSpace(sample.Case.Expected))
+		return boolScore(pass, fmt.Sprintf("expected %q", sample.Case.Expected)), nil
+	})
+}
+
+// Contains passes when the answer contains the expected one, ignoring case
+func Contains() Metric {
+	return MetricFunc("contains", func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		pass := strings.Contains(strings.ToLower(sample.Output), strings.ToLower(strings.TrimSpace(sample.Case.Expected)))
+		return boolScore(pass, fmt.Sprintf("%q not found", sample.Case.Expected)), nil
+	})
+}
+
+// Regex passes when the answer matches pattern. It panics when the pattern
+// does not compile, like regexp.MustCompile.
+func Regex(pattern string) Metric {
+	re := regexp.MustCompile(pattern)
+	return MetricFunc("regex:"+pattern, func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		return boolScore(re.MatchString(sample.Output), "no match"), nil
+	})
+}
+
+// JSONValid passes when the answer is JSON. Code fences around it are
+// ignored.
+func JSONValid() Metric {
+	return MetricFunc("json_valid", func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(stripFences(sample.Output)), &v); err != nil {
+			return Score{Reason: err.Error()}, nil
+		}
+		return Score{Value: 1, Pass: true}, nil
+	})
+}
+
+// JSONSchema passes when the answer is JSON valid against schema, see
+// Schema for the keywords checked
+func JSONSchema(schema *Schema) Metric {
+	return MetricFunc("json_schema", func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(stripFences(sample.Output)), &v); err != nil {
+			return Score{Reason: err.Error()}, nil
+		}
+		if errs := schema.Validate(v); len(errs) > 0 {
+			return Score{Reason: strings.Join(errs, "; ")}, nil
+		}
+		return Score{Value: 1, Pass: true}, nil
+	})
+}
+
+// stripFences removes a markdown code fence around text
+func stripFences(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// ToolCalled passes when the tool was called while answering. When args is
+// not nil, one of the calls must have these arguments, others are ignored.
+func ToolCalled(name string, args map[string]interface{}) Metric {
+	return MetricFunc("tool_called:"+name, func(ctx context.Context, sample *Sample) (Score, error) {
+		calls := sample.ToolCalls()
+		called := false
+		for _, tc := range calls {
+			if tc.Function.Name != name {
+				continue
+			}
+			called = true
+			if args == nil || argumentsMatch(tc.Function.Arguments, args) {
+				return Score{Value: 1, Pass: true}, nil
+			}
+		}
+		if called {
+			return Score{Reason: fmt.Sprintf("%s was not called with %v", name, args)}, nil
+		}
+		return Score{Reason: fmt.Sprintf("%s was not called, calls: %s", name, callNames(calls))}, nil
+	})
+}
+
+// ToolNotCalled passes when the tool was not called
+func ToolNotCalled(name string) Metric {
+	return MetricFunc("tool_not_called:"+name, func(ctx context.Context, sample *Sample) (Score, error) {
+		for _, tc := range sample.ToolCalls() {
+			if tc.Function.Name == name {
+				return Score{Reason: fmt.Sprintf("%s was called with %s", name, tc.Function.Arguments)}, nil
+			}
+		}
+		return Score{Value: 1, Pass: true}, nil
+	})
+}
+
+// argumentsMatch reports whether the JSON arguments of a call hold the
+// expected values. Numbers are compared as float64.
+func argumentsMatch(arguments string, expected map[string]interface{}) bool {
+	var actual map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &actual); err != nil {
+		return false
+	}
+	// Round trip the expected values so their types match the decoded ones
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	var want map[string]interface{}
+	if err := json.Unmarshal(data, &want); err != nil {
+		return false
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(actual[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+func callNames(calls []*llm.ToolCall) string {
+	if len(calls) == 0 {
+		return "none"
+	}
+	names := make([]string, len(calls))
+	for i, tc := range calls {
+		names[i] = tc.Function.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Similarity scores the cosine similarity between the embeddings of the
+// answer and the expected one, passing above threshold
+func Similarity(embedder Embedder, threshold float64) Metric {
+	return MetricFunc("similarity", func(ctx context.Context, sample *Sample) (Score, error) {
+		if score, ok := failed(sample); ok {
+			return score, nil
+		}
+		vectors, err := embedder.Embed(ctx, []string{sample.Output, sample.Case.Expected})
+		if err != nil {
+			return Score{}, err
+		}
+		if len(vectors) != 2 {
+			return Score{}, fmt.Errorf("expected 2 embeddings, got %d", len(vectors))
+		}
+		similarity := Cosine(vectors[0], vectors[1])
+		score := Score{Value: similarity, Pass: similarity >= threshold}
+		if !score.Pass {
+			score.Reason = fmt.Sprintf("similarity %.3f below %.3f", similarity, threshold)
+		}
+		return score, nil
+	})
+}
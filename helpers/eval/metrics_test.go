@@ -0,0 +1,70 @@
+package eval_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/eval"
+)
+
+// answered is a sample answering output, after calls to get_weather with
+// the given arguments
+func answered(expected, output string, arguments ...string) *eval.Sample {
+	result := &llm.Result{Content: output}
+	for i, args := range arguments {
+		result.Messages = append(result.Messages, llm.Message{Role: "assistant", ToolCalls: []*llm.ToolCall{{
+			Id:       "call_" + string(rune('1'+i)),
+			Type:     "function",
+			Function: &llm.Function{Name: "get_weather", Arguments: args},
+		}}})
+	}
+	return &eval.Sample{Case: eval.Case{Input: "Weather?", Expected: expected}, Output: output, Result: result}
+}
+
+func TestMetrics(t *testing.T) {
+	schema, err := eval.ParseSchema([]byte(`{"type": "object", "required": ["temperature"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := &eval.Sample{Case: eval.Case{Expected: "Sunny"}, Err: errors.New("timeout")}
+
+	tests := []struct {
+		name   string
+		metric eval.Metric
+		sample *eval.Sample
+		pass   bool
+	}{
+		{"exact", eval.ExactMatch(), answered("Sunny", " sunny\n"), true},
+		{"exact other", eval.ExactMatch(), answered("Sunny", "Sunny today"), false},
+		{"exact failed", eval.ExactMatch(), failed, false},
+		{"contains", eval.Contains(), answered("sunny", "It is Sunny today"), true},
+		{"contains other", eval.Contains(), answered("rainy", "It is Sunny today"), false},
+		{"regex", eval.Regex(`\d+°C`), answered("", "It is 21°C"), true},
+		{"regex other", eval.Regex(`\d+°C`), answered("", "It is warm"), false},
+		{"json", eval.JSONValid(), answered("", "```json\n{\"temperature\": 21}\n```"), true},
+		{"json broken", eval.JSONValid(), answered("", `{"temperature": }`), false},
+		{"schema", eval.JSONSchema(schema), answered("", `{"temperature": 21}`), true},
+		{"schema other", eval.JSONSchema(schema), answered("", `{"city": "Paris"}`), false},
+		{"called", eval.ToolCalled("get_weather", nil), answered("", "", `{}`), true},
+		{"called with", eval.ToolCalled("get_weather", map[string]interface{}{"days": 2}), answered("", "", `{"city": "Paris"}`, `{"city": "Paris", "days": 2}`), true},
+		{"called with other", eval.ToolCalled("get_weather", map[string]interface{}{"city": "Rome"}), answered("", "", `{"city": "Paris"}`), false},
+		{"not called", eval.ToolCalled("get_weather", nil), answered("", "Sunny"), false},
+		{"called failed", eval.ToolCalled("get_weather", nil), &eval.Sample{Err: errors.New("timeout")}, false},
+		{"not called ok", eval.ToolNotCalled("get_weather"), answered("", "Sunny"), true},
+		{"not called but was", eval.ToolNotCalled("get_weather"), answered("", "", `{}`), false},
+	}
+	for _, tt := range tests {
+		score, err := tt.metric.Score(context.Background(), tt.sample)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if score.Pass != tt.pass || (score.Value == 1) != tt.pass {
+			t.Errorf("%s: got %+v, want pass %v", tt.name, score, tt.pass)
+		}
+		if !score.Pass && score.Reason == "" {
+			t.Errorf("%s: got no reason for the failure", tt.name)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package eval_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/desarso/go_llm_functions/helpers/eval"
+)
+
+const personSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 2, "maxLength": 10, "pattern": "^[A-Z]"},
+		"age": {"type": "integer", "minimum": 0, "maximum": 150},
+		"role": {"enum": ["admin", "user"]},
+		"email": {"type": ["string", "null"]},
+		"tags": {"type": "array", "minItems": 1, "maxItems": 2, "items": {"type": "string"}}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := eval.ParseSchema([]byte(personSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, value string
+		want        []string // Substrings of the violations, in order
+	}{
+		{"valid", `{"name": "Ada", "age": 36, "role": "admin", "email": null, "tags": ["math"]}`, nil},
+		{"not an object", `[]`, []string{"$: expected object, got array"}},
+		{"missing", `{"name": "Ada"}`, []string{`missing property "age"`}},
+		{"additional", `{"name": "Ada", "age": 36, "city": "London"}`, []string{`unexpected property "city"`}},
+		{"integer", `{"name": "Ada", "age": 36.5}`, []string{"$.age: expected integer, got number"}},
+		{"range", `{"name": "Ada", "age": 200}`, []string{"$.age: 200 is above the maximum 150"}},
+		{"length and pattern", `{"name": "a", "age": 1}`, []string{"at least 2 characters", `does not match "^[A-Z]"`}},
+		{"enum", `{"name": "Ada", "age": 1, "role": "root"}`, []string{"$.role: root is not one of [admin user]"}},
+		{"type list", `{"name": "Ada", "age": 1, "email": 3}`, []string{"$.email: expected [string null], got number"}},
+		{"items", `{"name": "Ada", "age": 1, "tags": ["a", 2, "c"]}`, []string{"at most 2 items", "$.tags[1]: expected string"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v interface{}
+			if err := json.Unmarshal([]byte(tt.value), &v); err != nil {
+				t.Fatal(err)
+			}
+			errs := schema.Validate(v)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("got %q, want %d violations", errs, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(errs[i], want) {
+					t.Errorf("got %q, want %q", errs[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+// Package fake provides a scripted llm.Provider for unit tests. Requests go
+// through the real tool loop of LLM functions, but the answers come from a
+// script:
+//
+//	p := fake.New()
+//	p.On("weather in Paris").
+//		CallTool("get_weather", map[string]interface{}{"city": "Paris"}).
+//		Respond("It is sunny in Paris")
+//	client := &llm.Client{Provider: p}
+//	weather := llm.LLM(func(s string) string { return s }, client, weatherTool)
+//
+//	weather("What's the weather in Paris?") // "It is sunny in Paris"
+//	p.Called("get_weather")                 // true, see CallsTo for the arguments
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+// ErrNoScript is returned for requests no script matches
+var ErrNoScript = errors.New("fake: no script matches the request")
+
+// Provider is an llm.Provider answering from scripts
+type Provider struct {
+	mu       sync.Mutex
+	scripts  []*Script
+	requests []*llm.Request
+	calls    int // Tool calls answered, numbers their IDs
+}
+
+// New returns a provider without scripts
+func New() *Provider {
+	return &Provider{}
+}
+
+// Script is the sequence of answers given to the requests it matches. Every
+// request gets the next step; the last one is repeated once they ran out.
+type Script struct {
+	p     *Provider // Its lock guards the script too
+	match func(*llm.Request) bool
+	steps []*Step
+	next  int
+	delay time.Duration // Delay of the step added next
+}
+
+// Step is a single answer of a script
+type Step struct {
+	Content   string
+	Reasoning string
+	ToolCalls []*llm.ToolCall
+	Err       error         // Returned instead of a response
+	Delay     time.Duration // Waited before answering
+	Empty     bool          // Answer without any choice
+	Usage     *llm.Usage    // Defaults to an estimate from the lengths
+}
+
+// On adds a script for the requests whose last user message contains input.
+// Scripts are tried in the order they were added; On("") matches anything.
+func (p *Provider) On(input string) *Script {
+	return p.OnRequest(func(req *llm.Request) bool {
+		return strings.Contains(lastUserMessage(req), input)
+	})
+}
+
+// OnRequest adds a script for the requests match accepts
+func (p *Provider) OnRequest(match func(*llm.Request) bool) *Script {
+	s := &Script{p: p, match: match}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scripts = append(p.scripts, s)
+	return s
+}
+
+// Respond adds a step answering text
+func (s *Script) Respond(text string) *Script {
+	return s.Add(&Step{Content: text})
+}
+
+// Reason adds a step answering text after some reasoning
+func (s *Script) Reason(reasoning, text string) *Script {
+	return s.Add(&Step{Reasoning: reasoning, Content: text})
+}
+
+// CallTool adds a step calling a tool. The arguments are encoded to JSON,
+// except strings which are sent as they are, malformed or not.
+func (s *Script) CallTool(name string, arguments interface{}) *Script {
+	return s.CallTools(Call{Name: name, Arguments: encodeArguments(arguments)})
+}
+
+// CallTools adds a step calling several tools at once
+func (s *Script) CallTools(calls ...Call) *Script {
+	step := &Step{}
+	for i, call := range calls {
+		step.ToolCalls = append(step.ToolCalls, &llm.ToolCall{
+			Type:     "function",
+			Index:    int32(i),
+			Function: &llm.Function{Name: call.Name, Arguments: call.Arguments},
+		})
+	}
+	return s.Add(step)
+}
+
+// Fail adds a step returning err
+func (s *Script) Fail(err error) *Script {
+	return s.Add(&Step{Err: err})
+}
+
+// FailStatus adds a step failing like a provider answering with an HTTP
+// error, which the fallbacks of the client react to
+func (s *Script) FailStatus(statusCode int, body string) *Script {
+	return s.Fail(&llm.APIError{StatusCode: statusCode, Body: body})
+}
+
+// Empty adds a step answering without any choice
+func (s *Script) Empty() *Script {
+	return s.Add(&Step{Empty: true})
+}
+
+// After delays the step added next
+func (s *Script) After(delay time.Duration) *Script {
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	s.delay = delay
+	return s
+}
+
+// Add adds a step
+func (s *Script) Add(step *Step) *Script {
+	s.p.mu.Lock()
+	defer s.p.mu.Unlock()
+	if s.delay > 0 {
+		step.Delay = s.delay
+		s.delay = 0
+	}
+	s.steps = append(s.steps, step)
+	return s
+}
+
+// step returns the step answering the next request
+func (p *Provider) step(req *llm.Request) (*Step, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, copyRequest(req))
+
+	for _, s := range p.scripts {
+		if len(s.steps) == 0 || !s.match(req) {
+			continue
+		}
+		scripted := s.steps[s.next]
+		if s.next < len(s.steps)-1 {
+			s.next++
+		}
+
+		// Every call gets its own ID, like the calls of a real model
+		step := *scripted
+		step.ToolCalls = nil
+		for _, tc := range scripted.ToolCalls {
+			p.calls++
+			step.ToolCalls = append(step.ToolCalls, &llm.ToolCall{
+				Id:       fmt.Sprintf("call_%d", p.calls),
+				Type:     tc.Type,
+				Index:    tc.Index,
+				Function: &llm.Function{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+			})
+		}
+		return &step, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoScript, lastUserMessage(req))
+}
+
+// wait waits for the delay of a step or the end of ctx
+func wait(ctx context.Context, step *Step) error {
+	if step.Delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(step.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Provider) Chat(ctx context.Context, req *llm.Request) (*llm.ResponseData, error) {
+	step, err := p.step(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(ctx, step); err != nil {
+		return nil, err
+	}
+	if step.Err != nil {
+		return nil, step.Err
+	}
+
+	response := &llm.ResponseData{
+		Id:      "fake",
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Usage:   step.usage(req),
+	}
+	if step.Empty {
+		return response, nil
+	}
+
+	n := req.N
+	if n < 1 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		response.Choices = append(response.Choices, &llm.Choice{
+			Index:        int32(i),
+			FinishReason: step.finishReason(),
+			Message: &llm.Message{
+				Role:      "assistant",
+				Content:   step.Content,
+				Reasoning: step.Reasoning,
+				ToolCalls: step.ToolCalls,
+			},
+		})
+	}
+	return response, nil
+}
+
+// ChatStream streams the answer of a step word by word
+func (p *Provider) ChatStream(ctx context.Context, req *llm.Request) (<-chan *llm.ResponseData, <-chan error) {
+	chunks := make(chan *llm.ResponseData)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		defer close(errs)
+
+		step, err := p.step(req)
+		if err != nil {
+			errs <- err
+			return
+		}
+		if err := wait(ctx, step); err != nil {
+			errs <- err
+			return
+		}
+		if step.Err != nil {
+			errs <- step.Err
+			return
+		}
+
+		send := func(chunk *llm.ResponseData) bool {
+			chunk.Id = "fake"
+			chunk.Object = "chat.completion.chunk"
+			chunk.Model = req.Model
+			select {
+			case chunks <- chunk:
+				return true
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return false
+			}
+		}
+		delta := func(d *llm.Delta) *llm.ResponseData {
+			return &llm.ResponseData{Choices: []*llm.Choice{{Delta: d}}}
+		}
+
+		if !step.Empty {
+			for _, piece := range split(step.Reasoning) {
+				if !send(delta(&llm.Delta{Reasoning: piece})) {
+					return
+				}
+			}
+			for _, piece := range split(step.Content) {
+				if !send(delta(&llm.Delta{Content: piece})) {
+					return
+				}
+			}
+			if len(step.ToolCalls) > 0 && !send(delta(&llm.Delta{ToolCalls: step.ToolCalls})) {
+				return
+			}
+		}
+
+		last := &llm.ResponseData{Usage: step.usage(req)}
+		if !step.Empty {
+			last.Choices = []*llm.Choice{{Delta: &llm.Delta{}, FinishReason: step.finishReason()}}
+		}
+		send(last)
+	}()
+
+	return chunks, errs
+}
+
+func (s *Step) finishReason() string {
+	if len(s.ToolCalls) > 0 {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// usage returns the usage of the step, or an estimate of four characters
+// per token
+func (s *Step) usage(req *llm.Request) *llm.Usage {
+	if s.Usage != nil {
+		return s.Usage
+	}
+	prompt := 0
+	for i := range req.Messages {
+		prompt += len(req.Messages[i].Content)
+	}
+	completion := len(s.Content) + len(s.Reasoning)
+	for _, tc := range s.ToolCalls {
+		completion += len(tc.Function.Name) + len(tc.Function.Arguments)
+	}
+	usage := &llm.Usage{PromptTokens: int32((prompt + 3) / 4), CompletionTokens: int32((completion + 3) / 4)}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// split cuts text into words, keeping the spaces
+func split(text string) []string {
+	var pieces []string
+	for text != "" {
+		i := strings.IndexByte(text[1:], ' ')
+		if i < 0 {
+			pieces = append(pieces, text)
+			break
+		}
+		pieces = append(pieces, text[:i+1])
+		text = text[i+1:]
+	}
+	return pieces
+}
+
+// Call is a tool call requested by a script, with the result the tool loop
+// sent back when recorded by the provider
+type Call struct {
+	Name      string
+	Arguments string // JSON encoded
+	Result    string
+}
+
+// Requests returns the requests received so far
+func (p *Provider) Requests() []*llm.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*llm.Request(nil), p.requests...)
+}
+
+// Calls returns the tool calls that were executed, in order, with their
+// results. A call is executed when its result is sent in a later request.
+func (p *Provider) Calls() []Call {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var calls []Call
+	seen := make(map[string]bool)
+	for _, req := range p.requests {
+		pending := make(map[string]*llm.ToolCall)
+		for i := range req.Messages {
+			m := &req.Messages[i]
+			for _, tc := range m.ToolCalls {
+				if tc != nil && tc.Function != nil {
+					pending[tc.Id] = tc
+				}
+			}
+			if m.Role != "tool" || seen[m.ToolCallID] {
+				continue
+			}
+			if tc, ok := pending[m.ToolCallID]; ok {
+				seen[m.ToolCallID] = true
+				calls = append(calls, Call{Name: tc.Function.Name, Arguments: tc.Function.Arguments, Result: m.Content})
+			}
+		}
+	}
+	return calls
+}
+
+// CallsTo returns the executed calls of a tool
+func (p *Provider) CallsTo(name string) []Call {
+	var calls []Call
+	for _, call := range p.Calls() {
+		if call.Name == name {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Called reports whether a tool was executed
+func (p *Provider) Called(name string) bool {
+	return len(p.CallsTo(name)) > 0
+}
+
+// Reset forgets the requests and restarts the scripts and the numbering of
+// tool calls
+func (p *Provider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = nil
+	p.calls = 0
+	for _, s := range p.scripts {
+		s.next = 0
+	}
+}
+
+// lastUserMessage returns the content of the last user message of a request
+func lastUserMessage(req *llm.Request) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			return req.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// copyRequest copies a request so later changes to its messages are not seen
+func copyRequest(req *llm.Request) *llm.Request {
+	out := *req
+	out.Messages = append([]llm.Message(nil), req.Messages...)
+	return &out
+}
+
+func encodeArguments(arguments interface{}) string {
+	if s, ok := arguments.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(arguments)
+	if err != nil {
+		return fmt.Sprintf("%v", arguments)
+	}
+	return string(data)
+}
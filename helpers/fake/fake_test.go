@@ -0,0 +1,188 @@
+package fake_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+func ask(question string) string {
+	return question
+}
+
+func weather(city string) string {
+	return "sunny in " + city
+}
+
+// request is a request whose last user message is text
+func request(text string) *llm.Request {
+	return &llm.Request{Model: "test-model", Messages: []llm.Message{{Role: "user", Content: text}}}
+}
+
+// content returns the answer of p to text, or its error
+func content(p *fake.Provider, text string) string {
+	response, err := p.Chat(context.Background(), request(text))
+	if err != nil {
+		return err.Error()
+	}
+	if len(response.Choices) == 0 {
+		return "no choice"
+	}
+	return response.Choices[0].Message.Content
+}
+
+func TestScripts(t *testing.T) {
+	p := fake.New()
+	p.On("Paris").Respond("Sunny.").Respond("Still sunny.")
+	p.On("Rome").Fail(errors.New("unavailable"))
+	p.On("Oslo").FailStatus(503, "overloaded").Empty()
+	p.On("").Respond("Hello.")
+
+	for _, tt := range []struct{ text, want string }{
+		{"Weather in Paris?", "Sunny."},
+		{"Paris again?", "Still sunny."},
+		{"Paris once more?", "Still sunny."}, // The last step repeats
+		{"Weather in Rome?", "unavailable"},
+		{"Weather in Oslo?", "request failed with status code: 503: overloaded"},
+		{"Weather in Oslo?", "no choice"},
+		{"Hi", "Hello."},
+	} {
+		if got := content(p, tt.text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+	if n := len(p.Requests()); n != 7 {
+		t.Errorf("got %d requests, want 7", n)
+	}
+
+	if _, err := fake.New().Chat(context.Background(), request("Hi")); !errors.Is(err, fake.ErrNoScript) {
+		t.Errorf("got %v, want ErrNoScript", err)
+	}
+}
+
+func TestToolCalls(t *testing.T) {
+	p := fake.New()
+	p.On("Paris").CallTool("weather", map[string]string{"city": "Paris"}).Respond("Sunny in Paris.")
+	p.On("both").CallTools(
+		fake.Call{Name: "weather", Arguments: `{"city":"Rome"}`},
+		fake.Call{Name: "weather", Arguments: `{"city":"Oslo"}`},
+	).Respond("Sunny in both.")
+	tool := llm.CreateTool("weather", "Weather of a city", weather)
+	f := llm.LLM(ask, &llm.Client{Provider: p}, tool)
+
+	if got := f("Weather in Paris?"); got != "Sunny in Paris." {
+		t.Errorf("got %q, want the answer after the call", got)
+	}
+	if got := f("Weather in both?"); got != "Sunny in both." {
+		t.Errorf("got %q, want the answer after the calls", got)
+	}
+
+	calls := p.CallsTo("weather")
+	want := []fake.Call{
+		{Name: "weather", Arguments: `{"city":"Paris"}`, Result: "sunny in Paris"},
+		{Name: "weather", Arguments: `{"city":"Rome"}`, Result: "sunny in Rome"},
+		{Name: "weather", Arguments: `{"city":"Oslo"}`, Result: "sunny in Oslo"},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("got calls %+v, want %+v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("got call %+v, want %+v", calls[i], want[i])
+		}
+	}
+	if !p.Called("weather") || p.Called("forecast") {
+		t.Error("got Called wrong")
+	}
+
+	// Call IDs are numbered, Reset starts again from the first step and ID
+	ids := func() []string {
+		response, err := p.Chat(context.Background(), request("Weather in Paris?"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, tc := range response.Choices[0].Message.ToolCalls {
+			ids = append(ids, tc.Id)
+		}
+		return ids
+	}
+	p.Reset()
+	if len(p.Requests()) != 0 || p.Called("weather") {
+		t.Error("Reset kept the requests")
+	}
+	if got := ids(); len(got) != 1 || got[0] != "call_1" {
+		t.Errorf("got IDs %v after Reset, want call_1", got)
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	p := fake.New()
+	p.On("").Reason("Thinking about it.", "It is sunny in Paris.")
+
+	chunks, errs := p.ChatStream(context.Background(), request("Weather?"))
+	var reasoning, text strings.Builder
+	pieces := 0
+	for chunk := range chunks {
+		for _, choice := range chunk.Choices {
+			if choice.Delta != nil {
+				reasoning.WriteString(choice.Delta.Reasoning)
+				text.WriteString(choice.Delta.Content)
+				if choice.Delta.Content != "" {
+					pieces++
+				}
+			}
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if reasoning.String() != "Thinking about it." || text.String() != "It is sunny in Paris." {
+		t.Errorf("got %q and %q", reasoning.String(), text.String())
+	}
+	if pieces != 5 {
+		t.Errorf("got %d pieces, want one per word", pieces)
+	}
+}
+
+func TestAfter(t *testing.T) {
+	p := fake.New()
+	p.On("").After(time.Hour).Respond("Late.")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Chat(ctx, request("Hi")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline of the context", err)
+	}
+}
+
+// TestScriptWhileServing adds steps while requests are answered, for the
+// race detector
+func TestScriptWhileServing(t *testing.T) {
+	p := fake.New()
+	script := p.On("").Respond("First.")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				content(p, "Hi")
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		script.Respond("Next.")
+	}
+	wg.Wait()
+	p.Reset()
+	if got := content(p, "Hi"); got != "First." {
+		t.Errorf("got %q after Reset, want the first step", got)
+	}
+}
@@ -0,0 +1,311 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fallback is a model to try when the previous one fails
+type Fallback struct {
+	Model    string
+	Provider Provider // Defaults to the provider of the client
+}
+
+// FallbackPolicy decides when a request falls through to the next model of
+// Client.Fallbacks
+type FallbackPolicy struct {
+	OnError         bool          // Any error not covered below, such as a network failure, but not other 4xx status codes
+	OnServerError   bool          // 5xx status codes
+	OnRateLimit     bool          // 429 status codes
+	OnTimeout       bool          // The attempt took longer than Timeout, the request timed out or got a 408
+	OnContentFilter bool          // The response was stopped with the content_filter finish reason
+	OnContextLength bool          // The prompt doesn't fit in the context of the model
+	Timeout         time.Duration // Time limit for each attempt, 0 for none
+}
+
+// DefaultFallbackPolicy falls through on every kind of failure. Like with any
+// policy, the 4xx status codes not listed in FallbackPolicy never do: the
+// request itself is wrong and the next model would refuse it too.
+var DefaultFallbackPolicy = FallbackPolicy{
+	OnError:         true,
+	OnServerError:   true,
+	OnRateLimit:     true,
+	OnTimeout:       true,
+	OnContentFilter: true,
+	OnContextLength: true,
+}
+
+// ProviderPreferences is OpenRouter's `provider` routing object, see
+// https://openrouter.ai/docs/features/provider-routing
+type ProviderPreferences struct {
+	Order             []string `json:"order,omitempty"`
+	AllowFallbacks    *bool    `json:"allow_fallbacks,omitempty"`
+	RequireParameters bool     `json:"require_parameters,omitempty"`
+	DataCollection    string   `json:"data_collection,omitempty"` // "allow" or "deny"
+	Only              []string `json:"only,omitempty"`
+	Ignore            []string `json:"ignore,omitempty"`
+	Quantizations     []string `json:"quantizations,omitempty"`
+	Sort              string   `json:"sort,omitempty"` // "price", "throughput" or "latency"
+}
+
+// contextLengthErrors are fragments providers use to report prompts that
+// don't fit in the context window. Some are also used for tokens per minute
+// limits, which is why rate limits are told apart first.
+var contextLengthErrors = []string{
+	"context_length_exceeded",
+	"context length",
+	"context window",
+	"maximum context",
+	"prompt is too long",
+	"too many tokens",
+	"input is too long",
+}
+
+// rateLimitErrors are fragments of rate limits reported without a 429, like
+// the 413 of Groq for tokens per minute
+var rateLimitErrors = []string{
+	"rate_limit",
+	"rate limit",
+	"tokens per minute",
+}
+
+// isRateLimit reports whether err was caused by a rate limit
+func isRateLimit(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	body := strings.ToLower(apiErr.Body)
+	for _, fragment := range rateLimitErrors {
+		if strings.Contains(body, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsContextLengthError reports whether err was caused by a prompt too long
+// for the model. Rate limits never are, even when they mention tokens.
+func IsContextLengthError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || isRateLimit(err) {
+		return false
+	}
+	body := strings.ToLower(apiErr.Body)
+	for _, fragment := range contextLengthErrors {
+		if strings.Contains(body, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTimeout reports whether err is a deadline or network timeout, or a 408
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestTimeout {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// shouldFallback reports whether the policy lets the outcome of an attempt
+// fall through to the next model
+func (p FallbackPolicy) shouldFallback(response *ResponseData, err error) bool {
+	if err == nil {
+		if !p.OnContentFilter || response == nil {
+			return false
+		}
+		for _, choice := range response.Choices {
+			if choice != nil && choice.FinishReason == "content_filter" {
+				return true
+			}
+		}
+		return false
+	}
+
+	var apiErr *APIError
+	switch {
+	case isTimeout(err):
+		return p.OnTimeout
+	case isRateLimit(err):
+		return p.OnRateLimit
+	case IsContextLengthError(err):
+		return p.OnContextLength
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 500:
+		return p.OnServerError
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 400:
+		// Bad requests, keys or models would fail the same way again
+		return false
+	}
+	return p.OnError
+}
+
+// target is a model and the provider serving it
+type target struct {
+	model    string
+	provider Provider
+}
+
+// targets lists the primary model followed by the fallbacks of the client
+func (c *Client) targets(options Options) []target {
+	targets := []target{{model: c.model(options), provider: c.provider()}}
+	if c == nil {
+		return targets
+	}
+	for _, fb := range c.Fallbacks {
+		t := target{model: fb.Model, provider: fb.Provider}
+		if t.provider == nil {
+			t.provider = c.provider()
+		}
+		if t.model == "" {
+			t.model = c.model(options)
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// fallbackPolicy returns the policy of the client or the default one
+func (c *Client) fallbackPolicy() FallbackPolicy {
+	if c != nil && c.FallbackPolicy != nil {
+		return *c.FallbackPolicy
+	}
+	return DefaultFallbackPolicy
+}
+
+// chatWithFallbacks sends the request to each target in turn until one
+// succeeds or the policy stops the fall through
+func (c *Client) chatWithFallbacks(ctx context.Context, req *Request, options Options) (*ResponseData, error) {
+	policy := c.fallbackPolicy()
+	targets := c.targets(options)
+
+	var lastErr error
+	for i, t := range targets {
+		attempt := *req
+		attempt.Model = t.model
+
+		// Every attempt counts against the budgets
+		if err := c.reserveRequest(ctx); err != nil {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w (after falling back from: %v)", err, lastErr)
+			}
+			return nil, err
+		}
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if policy.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		}
+		response, err := t.provider.Chat(attemptCtx, &attempt)
+		cancel()
+		if err == nil {
+			c.recordUsage(ctx, t.model, response)
+		}
+
+		last := i == len(targets)-1
+		// Never fall through once the caller gave up
+		if last || ctx.Err() != nil || !policy.shouldFallback(response, err) {
+			if err != nil && lastErr != nil {
+				return nil, fmt.Errorf("%w (after falling back from: %v)", err, lastErr)
+			}
+			if err == nil {
+				setAnsweredBy(ctx, t.model)
+			}
+			return response, err
+		}
+
+		if err == nil {
+			err = fmt.Errorf("model %s stopped with content_filter", t.model)
+		}
+		c.logger(options).WarnContext(ctx, "retrying with fallback",
+			"model", t.model, "fallback", targets[i+1].model, "error", err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// chatStreamWithFallbacks works like chatWithFallbacks for streams. Once the
+// first chunk has been received the stream is committed to its model.
+func (c *Client) chatStreamWithFallbacks(ctx context.Context, req *Request, options Options) (<-chan *ResponseData, <-chan error) {
+	chunks := make(chan *ResponseData)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		defer close(errs)
+
+		policy := c.fallbackPolicy()
+		targets := c.targets(options)
+
+		for i, t := range targets {
+			attempt := *req
+			attempt.Model = t.model
+
+			if err := c.reserveRequest(ctx); err != nil {
+				errs <- err
+				return
+			}
+
+			attemptCtx, cancel := context.WithCancel(ctx)
+			var timer *time.Timer
+			if policy.Timeout > 0 {
+				// The timeout only applies until the first chunk
+				timer = time.AfterFunc(policy.Timeout, cancel)
+			}
+			in, inErrs := t.provider.ChatStream(attemptCtx, &attempt)
+
+			first, ok := <-in
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				err := <-inErrs
+				cancel()
+				if err != nil && attemptCtx.Err() != nil && ctx.Err() == nil {
+					err = fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+				}
+				last := i == len(targets)-1
+				if err == nil || last || ctx.Err() != nil || !policy.shouldFallback(nil, err) {
+					if err != nil {
+						errs <- err
+					}
+					return
+				}
+				c.logger(options).WarnContext(ctx, "retrying with fallback",
+					"model", t.model, "fallback", targets[i+1].model, "error", err)
+				continue
+			}
+
+			// Forward the rest of the stream
+			setAnsweredBy(ctx, t.model)
+			for chunk := first; ok; chunk, ok = <-in {
+				if err := send(ctx, chunks, chunk); err != nil {
+					cancel()
+					errs <- err
+					return
+				}
+			}
+			err := <-inErrs
+			cancel()
+			if err != nil {
+				errs <- err
+			}
+			return
+		}
+	}()
+
+	return chunks, errs
+}
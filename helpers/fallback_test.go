@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFallbackClassification(t *testing.T) {
+	policy := FallbackPolicy{OnRateLimit: true}
+	tests := []struct {
+		err           error
+		contextLength bool
+		rateLimit     bool
+	}{
+		{&APIError{StatusCode: 400, Body: `{"error":{"code":"context_length_exceeded"}}`}, true, false},
+		{&APIError{StatusCode: 400, Body: `prompt is too long: 210000 tokens > 200000 maximum`}, true, false},
+		{&APIError{StatusCode: 429, Body: `Rate limit reached: too many tokens per minute`}, false, true},
+		{&APIError{StatusCode: 413, Body: `{"error":{"code":"rate_limit_exceeded","message":"Request too large: too many tokens"}}`}, false, true},
+		{&APIError{StatusCode: 500, Body: `internal error`}, false, false},
+		{errors.New("connection reset"), false, false},
+	}
+	for _, test := range tests {
+		if got := IsContextLengthError(test.err); got != test.contextLength {
+			t.Errorf("IsContextLengthError(%v) = %v, want %v", test.err, got, test.contextLength)
+		}
+		if got := policy.shouldFallback(nil, test.err); got != test.rateLimit {
+			t.Errorf("falling back on rate limits for %v: got %v, want %v", test.err, got, test.rateLimit)
+		}
+	}
+}
+
+func TestDefaultFallbackPolicy(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&APIError{StatusCode: 500, Body: "internal error"}, true},
+		{&APIError{StatusCode: 429, Body: "slow down"}, true},
+		{&APIError{StatusCode: 408, Body: "request timeout"}, true},
+		{&APIError{StatusCode: 400, Body: "context_length_exceeded"}, true},
+		{&APIError{StatusCode: 400, Body: "invalid temperature"}, false},
+		{&APIError{StatusCode: 401, Body: "invalid api key"}, false},
+		{&APIError{StatusCode: 404, Body: "model not found"}, false},
+		{errors.New("connection reset"), true},
+	}
+	for _, test := range tests {
+		if got := DefaultFallbackPolicy.shouldFallback(nil, test.err); got != test.want {
+			t.Errorf("falling back on %v: got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+// downProvider fails every request with a server error
+type downProvider struct{}
+
+func (downProvider) Chat(ctx context.Context, req *Request) (*ResponseData, error) {
+	return nil, &APIError{StatusCode: 500, Body: "down"}
+}
+
+func (downProvider) ChatStream(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error) {
+	chunks := make(chan *ResponseData)
+	errs := make(chan error, 1)
+	close(chunks)
+	errs <- &APIError{StatusCode: 500, Body: "down"}
+	close(errs)
+	return chunks, errs
+}
+
+func TestStreamedFallbackUsage(t *testing.T) {
+	client := &Client{
+		Provider: downProvider{},
+		Model:    "primary",
+		Fallbacks: []Fallback{{Model: "backup", Provider: &streamProvider{
+			deltas: []string{"answer"},
+			usage:  &Usage{PromptTokens: 10, CompletionTokens: 10},
+		}}},
+		Prices: PriceTable{"primary": {Prompt: 100, Completion: 100}, "backup": {Prompt: 1, Completion: 1}},
+	}
+	result, err := LLMWithResult(func(s string) string { return s }, client, Options{OnContent: func(string) {}})(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "answer" || result.Usage.Cost != 20.0/1e6 {
+		t.Errorf("got %q costing %v, want the answer priced at the fallback", result.Content, result.Usage.Cost)
+	}
+}
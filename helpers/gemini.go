@@ -0,0 +1,366 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GeminiProvider talks to the Gemini generateContent API
+type GeminiProvider struct {
+	APIKey     string
+	BaseURL    string       // Defaults to https://generativelanguage.googleapis.com/v1beta
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent   `json:"contents"`
+	SystemInstruction *geminiContent    `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool      `json:"tools,omitempty"`
+	GenerationConfig  *geminiGeneration `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Parameters  *geminiSchema `json:"parameters,omitempty"`
+}
+
+type geminiSchema struct {
+	Type        string                   `json:"type"`
+	Description string                   `json:"description,omitempty"`
+	Properties  map[string]*geminiSchema `json:"properties,omitempty"`
+	Required    []string                 `json:"required,omitempty"`
+}
+
+type geminiGeneration struct {
+	CandidateCount  int             `json:"candidateCount,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"topP,omitempty"`
+	MaxOutputTokens int             `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string        `json:"stopSequences,omitempty"`
+	ThinkingConfig  *geminiThinking `json:"thinkingConfig,omitempty"`
+}
+
+type geminiThinking struct {
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+		Index        int32         `json:"index"`
+	} `json:"candidates"`
+	ModelVersion  string `json:"modelVersion"`
+	ResponseId    string `json:"responseId"`
+	UsageMetadata *struct {
+		PromptTokenCount     int32 `json:"promptTokenCount"`
+		CandidatesTokenCount int32 `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int32 `json:"thoughtsTokenCount"`
+		TotalTokenCount      int32 `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (p *GeminiProvider) url(model string, stream bool) string {
+	base := p.BaseURL
+	if base == "" {
+		base = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	if stream {
+		return fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", strings.TrimRight(base, "/"), model)
+	}
+	return fmt.Sprintf("%s/models/%s:generateContent", strings.TrimRight(base, "/"), model)
+}
+
+func (p *GeminiProvider) headers() map[string]string {
+	return map[string]string{"x-goog-api-key": p.APIKey}
+}
+
+// toGeminiSchema converts the tool parameters, Gemini uses upper case types
+func toGeminiSchema(params *Parameters) *geminiSchema {
+	if params == nil {
+		return nil
+	}
+	schema := &geminiSchema{
+		Type:     strings.ToUpper(params.Type),
+		Required: params.Required,
+	}
+	if len(params.Properties) > 0 {
+		schema.Properties = make(map[string]*geminiSchema)
+		for name, field := range params.Properties {
+			schema.Properties[name] = &geminiSchema{
+				Type:        strings.ToUpper(field.Type),
+				Description: field.Description,
+			}
+		}
+	}
+	return schema
+}
+
+// toGemini translates a request to the generateContent format. Tool results
+// are matched to their function by the ID of the call that produced them.
+func toGemini(req *Request) *geminiRequest {
+	out := &geminiRequest{}
+	names := toolNames(req.Messages)
+
+	var system []geminiPart
+	for i := range req.Messages {
+		m := &req.Messages[i]
+		var content geminiContent
+		switch m.Role {
+		case "system":
+			system = append(system, geminiPart{Text: m.Content})
+			continue
+		case "tool":
+			// Function responses must be objects
+			var response map[string]interface{}
+			if err := json.Unmarshal([]byte(m.Content), &response); err != nil {
+				response = map[string]interface{}{"result": m.Content}
+			}
+			content = geminiContent{
+				Role: "user",
+				Parts: []geminiPart{{
+					FunctionResponse: &geminiFunctionResponse{
+						Name:     names[m.ToolCallID],
+						Response: response,
+					},
+				}},
+			}
+		case "assistant":
+			content = geminiContent{Role: "model"}
+			if m.Content != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				if tc == nil || tc.Function == nil {
+					continue
+				}
+				content.Parts = append(content.Parts, geminiPart{
+					FunctionCall: &geminiFunctionCall{
+						Name: tc.Function.Name,
+						Args: toolArguments(tc.Function.Arguments),
+					},
+				})
+			}
+		default:
+			content = geminiContent{Role: "user", Parts: []geminiPart{{Text: m.Content}}}
+		}
+
+		// Merge consecutive turns of the same role
+		if n := len(out.Contents); n > 0 && out.Contents[n-1].Role == content.Role {
+			out.Contents[n-1].Parts = append(out.Contents[n-1].Parts, content.Parts...)
+		} else {
+			out.Contents = append(out.Contents, content)
+		}
+	}
+	if len(system) > 0 {
+		out.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	var declarations []geminiFunctionDeclaration
+	for _, t := range req.Tools {
+		if t == nil || t.Function == nil {
+			continue
+		}
+		declarations = append(declarations, geminiFunctionDeclaration{
+			Name:        t.Function.Name,
+			Description: t.Description,
+			Parameters:  toGeminiSchema(t.Function.Parameters),
+		})
+	}
+	if len(declarations) > 0 {
+		out.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+	}
+
+	if req.N > 1 || req.Temperature != nil || req.TopP != nil || req.MaxTokens > 0 || len(req.Stop) > 0 {
+		out.GenerationConfig = &geminiGeneration{
+			Temperature:     req.Temperature,
+			TopP:            req.TopP,
+			MaxOutputTokens: req.MaxTokens,
+			StopSequences:   req.Stop,
+		}
+		if req.N > 1 {
+			out.GenerationConfig.CandidateCount = req.N
+		}
+	}
+
+	// Thoughts are only returned when asked for
+	includeReasoning := req.IncludeReasoning != nil && *req.IncludeReasoning
+	if includeReasoning || req.Reasoning != nil {
+		thinking := &geminiThinking{IncludeThoughts: includeReasoning}
+		if req.Reasoning != nil {
+			thinking.IncludeThoughts = !req.Reasoning.Exclude
+			if req.Reasoning.MaxTokens > 0 {
+				thinking.ThinkingBudget = &req.Reasoning.MaxTokens
+			}
+		}
+		if out.GenerationConfig == nil {
+			out.GenerationConfig = &geminiGeneration{}
+		}
+		out.GenerationConfig.ThinkingConfig = thinking
+	}
+
+	return out
+}
+
+// geminiFinishReason maps finish reasons to OpenAI finish reasons
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	}
+	return strings.ToLower(reason)
+}
+
+// fromGemini translates a generateContent response. Gemini doesn't give
+// function calls an ID, so one is made up from the candidate and position.
+func fromGemini(resp *geminiResponse) *ResponseData {
+	out := &ResponseData{
+		Id:     resp.ResponseId,
+		Object: "chat.completion",
+		Model:  resp.ModelVersion,
+	}
+	if u := resp.UsageMetadata; u != nil {
+		// Thinking tokens are billed as output
+		out.Usage = &Usage{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.CandidatesTokenCount + u.ThoughtsTokenCount,
+			TotalTokens:      u.TotalTokenCount,
+		}
+	}
+	for i, candidate := range resp.Candidates {
+		message := &Message{Role: "assistant"}
+		var text, thoughts []string
+		for _, part := range candidate.Content.Parts {
+			if part.Thought {
+				thoughts = append(thoughts, part.Text)
+			} else if part.FunctionCall != nil {
+				message.ToolCalls = append(message.ToolCalls, &ToolCall{
+					Id:   fmt.Sprintf("call_%d_%d", i, len(message.ToolCalls)),
+					Type: "function",
+					Function: &Function{
+						Name:      part.FunctionCall.Name,
+						Arguments: string(toolArguments(string(part.FunctionCall.Args))),
+					},
+					Index: int32(len(message.ToolCalls)),
+				})
+			} else if part.Text != "" {
+				text = append(text, part.Text)
+			}
+		}
+		message.Content = strings.Join(text, "")
+		message.Reasoning = strings.Join(thoughts, "")
+
+		finishReason := geminiFinishReason(candidate.FinishReason)
+		if len(message.ToolCalls) > 0 && finishReason == "stop" {
+			finishReason = "tool_calls"
+		}
+		out.Choices = append(out.Choices, &Choice{
+			Index:        candidate.Index,
+			Message:      message,
+			FinishReason: finishReason,
+		})
+	}
+	return out
+}
+
+func (p *GeminiProvider) Chat(ctx context.Context, req *Request) (*ResponseData, error) {
+	resp, err := postJSON(ctx, p.HTTPClient, p.url(req.Model, false), p.headers(), toGemini(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var geminiResp geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	return fromGemini(&geminiResp), nil
+}
+
+func (p *GeminiProvider) ChatStream(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error) {
+	chunks := make(chan *ResponseData)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		defer close(errs)
+
+		resp, err := postJSON(ctx, p.HTTPClient, p.url(req.Model, true), p.headers(), toGemini(req))
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		// Function calls arrive whole, number them across chunks
+		toolCalls := int32(0)
+		err = readSSE(resp.Body, func(_, data string) error {
+			var geminiResp geminiResponse
+			if err := json.Unmarshal([]byte(data), &geminiResp); err != nil {
+				return fmt.Errorf("error decoding stream chunk: %w", err)
+			}
+
+			chunk := fromGemini(&geminiResp)
+			chunk.Object = "chat.completion.chunk"
+			for _, choice := range chunk.Choices {
+				for _, tc := range choice.Message.ToolCalls {
+					tc.Index = toolCalls
+					tc.Id = fmt.Sprintf("call_%d_%d", choice.Index, toolCalls)
+					toolCalls++
+				}
+				choice.Delta = &Delta{
+					Content:   choice.Message.Content,
+					Reasoning: choice.Message.Reasoning,
+					ToolCalls: choice.Message.ToolCalls,
+				}
+				choice.Message = nil
+
+				// Gemini reports STOP on the final chunk even with function calls
+				if toolCalls > 0 && choice.FinishReason == "stop" {
+					choice.FinishReason = "tool_calls"
+				}
+			}
+			return send(ctx, chunks, chunk)
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return chunks, errs
+}
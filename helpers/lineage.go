@@ -0,0 +1,587 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lineage versions an LLM function and records every call to a store. Pass
+// it to LLM like the other options:
+//
+//	store := &llm.FileInvocationStore{Dir: ".lineage"}
+//	hello := llm.LLM(prompt, "Be friendly", llm.Lineage{Store: store, Name: "hello", Source: true})
+//
+// The version is a hash of the system message, the options sent to the model,
+// the tool schemas and, with Source, the source of the prompt function. Any
+// change to them gives a new version.
+type Lineage struct {
+	Store  InvocationStore
+	Name   string // Name of the function, defaults to the name of the prompt function
+	Source bool   // Include the source of the prompt function in the version, when it can be read
+}
+
+// FunctionVersion describes a version of an LLM function
+type FunctionVersion struct {
+	Function  string          `json:"function"`
+	Version   string          `json:"version"`
+	System    string          `json:"system,omitempty"`
+	Options   json.RawMessage `json:"options,omitempty"`
+	Tools     []*Tool         `json:"tools,omitempty"`
+	Source    string          `json:"source,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Invocation is a recorded call of an LLM function
+type Invocation struct {
+	ID        string        `json:"id"`
+	Function  string        `json:"function"`
+	Version   string        `json:"version"`
+	Input     string        `json:"input"`
+	Prompt    string        `json:"prompt"` // Returned by the prompt function
+	Output    string        `json:"output"`
+	Reasoning string        `json:"reasoning,omitempty"`
+	Model     string        `json:"model"`
+	Usage     UsageTotals   `json:"usage"`
+	Error     string        `json:"error,omitempty"`
+	Messages  []Message     `json:"messages,omitempty"` // Transcript of the call, including tool calls
+	CreatedAt time.Time     `json:"created_at"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// InvocationQuery selects invocations. Empty fields match everything.
+type InvocationQuery struct {
+	Function string
+	Version  string
+	Since    time.Time
+	Limit    int // Most recent first, all of them when 0
+}
+
+func (q *InvocationQuery) matches(inv *Invocation) bool {
+	return (q.Function == "" || inv.Function == q.Function) &&
+		(q.Version == "" || inv.Version == q.Version) &&
+		(q.Since.IsZero() || !inv.CreatedAt.Before(q.Since))
+}
+
+// ErrInvocationNotFound is returned when a store has no invocation with the
+// requested ID
+var ErrInvocationNotFound = errors.New("invocation not found")
+
+// InvocationStore keeps the versions and invocations of LLM functions
+type InvocationStore interface {
+	SaveVersion(ctx context.Context, version *FunctionVersion) error
+	Versions(ctx context.Context, function string) ([]*FunctionVersion, error)
+	Record(ctx context.Context, invocation *Invocation) error
+	Invocations(ctx context.Context, query InvocationQuery) ([]*Invocation, error)
+	Invocation(ctx context.Context, id string) (*Invocation, error) // ErrInvocationNotFound when missing
+}
+
+// versionedOptions are the options that change what the model answers
+type versionedOptions struct {
+	Model               string               `json:"model,omitempty"`
+	Top                 int                  `json:"top,omitempty"`
+	Temperature         *float64             `json:"temperature,omitempty"`
+	TopP                *float64             `json:"top_p,omitempty"`
+	MaxTokens           int                  `json:"max_tokens,omitempty"`
+	Stop                []string             `json:"stop,omitempty"`
+	ToolChoice          ToolChoice           `json:"tool_choice,omitempty"`
+	MaxToolSteps        int                  `json:"max_tool_steps,omitempty"`
+	ContextWindow       *versionedWindow     `json:"context_window,omitempty"`
+	Models              []string             `json:"models,omitempty"`
+	Route               string               `json:"route,omitempty"`
+	ProviderPreferences *ProviderPreferences `json:"provider,omitempty"`
+	IncludeReasoning    bool                 `json:"include_reasoning,omitempty"`
+	Reasoning           *Reasoning           `json:"reasoning,omitempty"`
+}
+
+// versionedWindow is the part of a ContextWindow that changes the requests,
+// the strategy and counter are named by their type or function
+type versionedWindow struct {
+	MaxTokens int    `json:"max_tokens,omitempty"`
+	Reserve   int    `json:"reserve,omitempty"`
+	Strategy  string `json:"strategy,omitempty"`
+	Counter   string `json:"counter,omitempty"`
+}
+
+// newVersionedWindow describes a context window for the version
+func newVersionedWindow(window *ContextWindow) *versionedWindow {
+	if window == nil {
+		return nil
+	}
+	v := &versionedWindow{MaxTokens: window.MaxTokens, Reserve: window.Reserve}
+	switch s := window.Strategy.(type) {
+	case nil:
+	case ContextStrategyFunc:
+		v.Strategy = funcName(s)
+	case *summarizer:
+		v.Strategy = "Summarize(" + s.model + ")"
+	default:
+		v.Strategy = fmt.Sprintf("%T", s)
+	}
+	if window.Counter != nil {
+		v.Counter = funcName(window.Counter)
+	}
+	return v
+}
+
+// lineage is the state of a Lineage for one LLM function
+type lineage struct {
+	Lineage
+	version *FunctionVersion
+
+	mu    sync.Mutex
+	saved bool // The version is in the store
+}
+
+// newLineage computes the version of an LLM function
+func newLineage(l Lineage, fn func(string) string, c *config) *lineage {
+	if l.Name == "" {
+		l.Name = funcName(fn)
+	}
+
+	options, _ := json.Marshal(versionedOptions{
+		Model:               c.client.model(c.options),
+		Top:                 c.options.Top,
+		Temperature:         c.options.Temperature,
+		TopP:                c.options.TopP,
+		MaxTokens:           c.options.MaxTokens,
+		Stop:                c.options.Stop,
+		ToolChoice:          c.options.ToolChoice,
+		MaxToolSteps:        c.options.MaxToolSteps,
+		ContextWindow:       newVersionedWindow(c.options.ContextWindow),
+		Models:              c.options.Models,
+		Route:               c.options.Route,
+		ProviderPreferences: c.options.ProviderPreferences,
+		IncludeReasoning:    c.options.IncludeReasoning,
+		Reasoning:           c.options.Reasoning,
+	})
+
+	version := &FunctionVersion{
+		Function: l.Name,
+		System:   c.systemMessage,
+		Options:  options,
+		Tools:    c.tools,
+	}
+	if l.Source {
+		version.Source = funcSource(fn)
+	}
+
+	// Tools are sorted so their order doesn't change the version
+	tools := make([]string, 0, len(c.tools))
+	for _, tool := range c.tools {
+		data, _ := json.Marshal(tool)
+		tools = append(tools, string(data))
+	}
+	sort.Strings(tools)
+	hashed, _ := json.Marshal(struct {
+		System  string          `json:"system"`
+		Options json.RawMessage `json:"options"`
+		Tools   []string        `json:"tools"`
+		Source  string          `json:"source,omitempty"`
+	}{version.System, options, tools, version.Source})
+	sum := sha256.Sum256(hashed)
+	version.Version = hex.EncodeToString(sum[:])[:12]
+
+	return &lineage{Lineage: l, version: version}
+}
+
+// saveVersion saves the version until the store accepts it once
+func (l *lineage) saveVersion(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.saved {
+		return nil
+	}
+	version := *l.version
+	version.CreatedAt = time.Now()
+	if err := l.Store.SaveVersion(ctx, &version); err != nil {
+		return err
+	}
+	l.saved = true
+	return nil
+}
+
+// record saves the version, then the invocation
+func (l *lineage) record(ctx context.Context, c *config, input string, start time.Time, result *Result, callErr error) error {
+	if l.Store == nil {
+		return nil
+	}
+	if err := l.saveVersion(ctx); err != nil {
+		return err
+	}
+
+	invocation := &Invocation{
+		ID:        newConversationID(),
+		Function:  l.Name,
+		Version:   l.version.Version,
+		Input:     input,
+		CreatedAt: start,
+		Duration:  time.Since(start),
+		Model:     c.client.model(c.options),
+	}
+	if result != nil {
+		invocation.Prompt = result.Prompt
+		invocation.Output = result.Content
+		invocation.Reasoning = result.Reasoning
+		invocation.Usage = result.Usage
+		invocation.Messages = result.Messages
+		if result.Response != nil && result.Response.Model != "" {
+			invocation.Model = result.Response.Model
+		}
+	}
+	if callErr != nil {
+		invocation.Error = callErr.Error()
+	}
+	return l.Store.Record(ctx, invocation)
+}
+
+// funcName returns the name of a function, like main.main.func1 for a
+// function literal
+func funcName(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	return f.Name()
+}
+
+// funcSource returns the source of a function when the file it was compiled
+// from is still around, or "" otherwise
+func funcSource(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return ""
+	}
+	file, line := f.FileLine(f.Entry())
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+
+	fset := token.NewFileSet()
+	parsed, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return ""
+	}
+
+	// The entry of a function is on the line it starts or the line after
+	var found ast.Node
+	ast.Inspect(parsed, func(n ast.Node) bool {
+		if found != nil {
+			return false
+		}
+		switch n.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			start := fset.Position(n.Pos()).Line
+			end := fset.Position(n.End()).Line
+			if line >= start && line <= end && line <= start+1 {
+				found = n
+				return false
+			}
+		}
+		return true
+	})
+	if found == nil {
+		return ""
+	}
+	return string(src[fset.Position(found.Pos()).Offset:fset.Position(found.End()).Offset])
+}
+
+// VersionStats sums up the invocations of a version
+type VersionStats struct {
+	Version         *FunctionVersion
+	Invocations     int
+	Errors          int
+	Usage           UsageTotals
+	AverageTokens   float64
+	AverageCost     float64
+	AverageDuration time.Duration
+	FirstSeen       time.Time
+	LastSeen        time.Time
+}
+
+// CompareVersions sums up the invocations of every version of a function,
+// oldest version first
+func CompareVersions(ctx context.Context, store InvocationStore, function string) ([]VersionStats, error) {
+	versions, err := store.Versions(ctx, function)
+	if err != nil {
+		return nil, err
+	}
+	invocations, err := store.Invocations(ctx, InvocationQuery{Function: function})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]VersionStats, len(versions))
+	index := make(map[string]int)
+	for i, version := range versions {
+		stats[i].Version = version
+		index[version.Version] = i
+	}
+	durations := make([]time.Duration, len(versions))
+	for _, inv := range invocations {
+		i, ok := index[inv.Version]
+		if !ok {
+			continue
+		}
+		s := &stats[i]
+		s.Invocations++
+		if inv.Error != "" {
+			s.Errors++
+		}
+		s.Usage.Add(inv.Usage)
+		durations[i] += inv.Duration
+		if s.FirstSeen.IsZero() || inv.CreatedAt.Before(s.FirstSeen) {
+			s.FirstSeen = inv.CreatedAt
+		}
+		if inv.CreatedAt.After(s.LastSeen) {
+			s.LastSeen = inv.CreatedAt
+		}
+	}
+	for i := range stats {
+		s := &stats[i]
+		if s.Invocations == 0 {
+			continue
+		}
+		n := float64(s.Invocations)
+		s.AverageTokens = float64(s.Usage.TotalTokens) / n
+		s.AverageCost = s.Usage.Cost / n
+		s.AverageDuration = durations[i] / time.Duration(s.Invocations)
+	}
+	return stats, nil
+}
+
+// MemoryInvocationStore keeps versions and invocations in memory
+type MemoryInvocationStore struct {
+	mu          sync.Mutex
+	versions    []*FunctionVersion
+	invocations []*Invocation
+}
+
+// NewMemoryInvocationStore returns an empty store
+func NewMemoryInvocationStore() *MemoryInvocationStore {
+	return &MemoryInvocationStore{}
+}
+
+func (s *MemoryInvocationStore) SaveVersion(ctx context.Context, version *FunctionVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.versions {
+		if v.Function == version.Function && v.Version == version.Version {
+			return nil
+		}
+	}
+	s.versions = append(s.versions, version)
+	return nil
+}
+
+func (s *MemoryInvocationStore) Versions(ctx context.Context, function string) ([]*FunctionVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var versions []*FunctionVersion
+	for _, v := range s.versions {
+		if function == "" || v.Function == function {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (s *MemoryInvocationStore) Record(ctx context.Context, invocation *Invocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invocations = append(s.invocations, invocation)
+	return nil
+}
+
+func (s *MemoryInvocationStore) Invocations(ctx context.Context, query InvocationQuery) ([]*Invocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return selectInvocations(s.invocations, query), nil
+}
+
+func (s *MemoryInvocationStore) Invocation(ctx context.Context, id string) (*Invocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.invocations) - 1; i >= 0; i-- {
+		if s.invocations[i].ID == id {
+			return s.invocations[i], nil
+		}
+	}
+	return nil, ErrInvocationNotFound
+}
+
+// selectInvocations returns the invocations matching query, most recent first
+func selectInvocations(all []*Invocation, query InvocationQuery) []*Invocation {
+	var selected []*Invocation
+	for i := len(all) - 1; i >= 0; i-- {
+		if query.matches(all[i]) {
+			selected = append(selected, all[i])
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].CreatedAt.After(selected[j].CreatedAt)
+	})
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+	}
+	return selected
+}
+
+// FileInvocationStore keeps versions and invocations in Dir, in the JSONL
+// files versions.jsonl and invocations.jsonl
+type FileInvocationStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+func (s *FileInvocationStore) SaveVersion(ctx context.Context, version *FunctionVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var versions []*FunctionVersion
+	if err := s.read("versions.jsonl", func(data []byte) error {
+		v := &FunctionVersion{}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		versions = append(versions, v)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v.Function == version.Function && v.Version == version.Version {
+			return nil
+		}
+	}
+	return s.append("versions.jsonl", version)
+}
+
+func (s *FileInvocationStore) Versions(ctx context.Context, function string) ([]*FunctionVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var versions []*FunctionVersion
+	err := s.read("versions.jsonl", func(data []byte) error {
+		v := &FunctionVersion{}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		if function == "" || v.Function == function {
+			versions = append(versions, v)
+		}
+		return nil
+	})
+	return versions, err
+}
+
+func (s *FileInvocationStore) Record(ctx context.Context, invocation *Invocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append("invocations.jsonl", invocation)
+}
+
+func (s *FileInvocationStore) Invocations(ctx context.Context, query InvocationQuery) ([]*Invocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var invocations []*Invocation
+	err := s.read("invocations.jsonl", func(data []byte) error {
+		inv := &Invocation{}
+		if err := json.Unmarshal(data, inv); err != nil {
+			return err
+		}
+		if query.matches(inv) {
+			invocations = append(invocations, inv)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return selectInvocations(invocations, query), nil
+}
+
+// Invocation reads the invocations file once, only decoding the invocation
+// with the ID in full
+func (s *FileInvocationStore) Invocation(ctx context.Context, id string) (*Invocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *Invocation
+	err := s.read("invocations.jsonl", func(data []byte) error {
+		var line struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(data, &line); err != nil {
+			return err
+		}
+		if line.ID != id {
+			return nil
+		}
+		found = &Invocation{}
+		return json.Unmarshal(data, found)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrInvocationNotFound
+	}
+	return found, nil
+}
+
+// append adds a line to a file of the store
+func (s *FileInvocationStore) append(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %w", name, err)
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("error creating store directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(s.Dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", name, err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	return f.Close()
+}
+
+// read calls fn with every line of a file of the store
+func (s *FileInvocationStore) read(name string, fn func([]byte) error) error {
+	data, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", name, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return fmt.Errorf("error decoding %s line %d: %w", name, line, err)
+		}
+	}
+	return scanner.Err()
+}
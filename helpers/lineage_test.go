@@ -0,0 +1,224 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+// flakyStore fails to save versions a number of times
+type flakyStore struct {
+	*llm.MemoryInvocationStore
+	failures int
+}
+
+func (s *flakyStore) SaveVersion(ctx context.Context, version *llm.FunctionVersion) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	return s.MemoryInvocationStore.SaveVersion(ctx, version)
+}
+
+// version calls a function with a lineage once and returns its version
+func version(t *testing.T, opts ...interface{}) string {
+	t.Helper()
+	provider := fake.New()
+	provider.On("").Respond("Hello.")
+	store := llm.NewMemoryInvocationStore()
+	opts = append(opts, &llm.Client{Provider: provider, Model: "test-model"}, llm.Lineage{Store: store, Name: "hello"})
+	if _, err := llm.LLMWithResult(echo, opts...)(context.Background(), "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := store.Versions(context.Background(), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("got %d versions, want 1", len(versions))
+	}
+	return versions[0].Version
+}
+
+func TestLineageVersion(t *testing.T) {
+	lookupTool := llm.CreateTool("lookup", "Weather of a city", lookup)
+	echoTool := llm.CreateTool("echo", "Echoes the input", echo)
+	base := version(t, "Be brief.", lookupTool, echoTool)
+
+	if got := version(t, "Be brief.", echoTool, lookupTool); got != base {
+		t.Errorf("got version %s with the tools in another order, want %s", got, base)
+	}
+	changes := map[string][]interface{}{
+		"system":         {"Be long.", lookupTool, echoTool},
+		"tools":          {"Be brief.", lookupTool},
+		"model":          {"Be brief.", lookupTool, echoTool, llm.Options{Model: "other-model"}},
+		"tool choice":    {"Be brief.", lookupTool, echoTool, llm.Options{ToolChoice: "required"}},
+		"context window": {"Be brief.", lookupTool, echoTool, llm.Options{ContextWindow: &llm.ContextWindow{MaxTokens: 1000}}},
+		"strategy": {"Be brief.", lookupTool, echoTool, llm.Options{ContextWindow: &llm.ContextWindow{
+			MaxTokens: 1000,
+			Strategy:  llm.SlidingWindow(),
+		}}},
+	}
+	seen := map[string]string{base: "base"}
+	for name, opts := range changes {
+		got := version(t, opts...)
+		if other, ok := seen[got]; ok {
+			t.Errorf("changing the %s gives version %s, the one of %s", name, got, other)
+		}
+		seen[got] = name
+	}
+}
+
+func TestLineageRecord(t *testing.T) {
+	ctx := context.Background()
+	provider := fake.New()
+	provider.On("Hi").Respond("Hello.")
+	provider.On("Fail").Fail(errors.New("unavailable"))
+	store := &flakyStore{MemoryInvocationStore: llm.NewMemoryInvocationStore(), failures: 1}
+	f := llm.LLMWithResult(echo, &llm.Client{Provider: provider, Model: "test-model"}, llm.Lineage{Store: store, Name: "hello"})
+
+	// The version is saved by the first call the store accepts
+	for i := 0; i < 2; i++ {
+		if _, err := f(ctx, "Hi"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f(ctx, "Fail"); err == nil {
+		t.Fatal("got no error, want the one of the provider")
+	}
+	versions, _ := store.Versions(ctx, "hello")
+	if len(versions) != 1 {
+		t.Fatalf("got %d versions, want 1 after a failed save", len(versions))
+	}
+
+	invocations, err := store.Invocations(ctx, llm.InvocationQuery{Function: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invocations) != 2 {
+		t.Fatalf("got %d invocations, want the 2 after the version was saved", len(invocations))
+	}
+	failed, succeeded := invocations[0], invocations[1]
+	if succeeded.Input != "Hi" || succeeded.Prompt != "Hi" || succeeded.Output != "Hello." || succeeded.Model != "test-model" {
+		t.Errorf("got invocation %+v, want the input, prompt, output and model of the call", succeeded)
+	}
+	if succeeded.Version != versions[0].Version || succeeded.Usage.Requests != 1 || len(succeeded.Messages) != 1 {
+		t.Errorf("got invocation %+v, want the version, usage and transcript of the call", succeeded)
+	}
+	if failed.Error == "" || failed.Output != "" {
+		t.Errorf("got invocation %+v, want the error of the call", failed)
+	}
+}
+
+func TestFileInvocationStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := &llm.FileInvocationStore{Dir: dir}
+
+	for _, v := range []*llm.FunctionVersion{
+		{Function: "hello", Version: "v1"},
+		{Function: "hello", Version: "v1"},
+		{Function: "bye", Version: "v2"},
+	} {
+		if err := store.SaveVersion(ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for i, function := range []string{"hello", "bye", "hello", "hello"} {
+		inv := &llm.Invocation{ID: string(rune('a' + i)), Function: function, Version: "v1", CreatedAt: now.Add(time.Duration(i) * time.Minute)}
+		if err := store.Record(ctx, inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A new store reads what the first one wrote
+	store = &llm.FileInvocationStore{Dir: dir}
+	versions, err := store.Versions(ctx, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Version != "v1" {
+		t.Errorf("got versions %v, want v1 once", versions)
+	}
+
+	tests := []struct {
+		query llm.InvocationQuery
+		want  string
+	}{
+		{llm.InvocationQuery{}, "dcba"},
+		{llm.InvocationQuery{Function: "hello"}, "dca"},
+		{llm.InvocationQuery{Function: "hello", Limit: 2}, "dc"},
+		{llm.InvocationQuery{Since: now.Add(90 * time.Second)}, "dc"},
+		{llm.InvocationQuery{Version: "v2"}, ""},
+	}
+	for _, tt := range tests {
+		invocations, err := store.Invocations(ctx, tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, inv := range invocations {
+			got += inv.ID
+		}
+		if got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	if inv, err := store.Invocation(ctx, "c"); err != nil || inv.ID != "c" || inv.Function != "hello" {
+		t.Errorf("got invocation %+v and %v, want c", inv, err)
+	}
+	if _, err := store.Invocation(ctx, "z"); !errors.Is(err, llm.ErrInvocationNotFound) {
+		t.Errorf("got %v, want ErrInvocationNotFound", err)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	ctx := context.Background()
+	store := llm.NewMemoryInvocationStore()
+	store.SaveVersion(ctx, &llm.FunctionVersion{Function: "hello", Version: "v1"})
+	store.SaveVersion(ctx, &llm.FunctionVersion{Function: "hello", Version: "v2"})
+	store.SaveVersion(ctx, &llm.FunctionVersion{Function: "hello", Version: "v3"})
+
+	start := time.Now()
+	for i, inv := range []*llm.Invocation{
+		{Version: "v1", Duration: time.Second, Usage: llm.UsageTotals{TotalTokens: 10, Cost: 0.1}},
+		{Version: "v1", Duration: 3 * time.Second, Usage: llm.UsageTotals{TotalTokens: 30, Cost: 0.3}, Error: "failed"},
+		{Version: "v2", Duration: time.Second, Usage: llm.UsageTotals{TotalTokens: 5}},
+		{Version: "v9", Duration: time.Second},
+	} {
+		inv.Function = "hello"
+		inv.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		store.Record(ctx, inv)
+	}
+	store.Record(ctx, &llm.Invocation{Function: "bye", Version: "v1", CreatedAt: start})
+
+	stats, err := llm.CompareVersions(ctx, store, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("got %d versions, want 3", len(stats))
+	}
+	v1, v2, v3 := stats[0], stats[1], stats[2]
+	if v1.Version.Version != "v1" || v1.Invocations != 2 || v1.Errors != 1 {
+		t.Errorf("got %+v, want 2 invocations of v1 with 1 error", v1)
+	}
+	if v1.AverageTokens != 20 || v1.AverageCost != 0.2 || v1.AverageDuration != 2*time.Second {
+		t.Errorf("got averages %v tokens, %v cost, %v, want 20, 0.2 and 2s", v1.AverageTokens, v1.AverageCost, v1.AverageDuration)
+	}
+	if !v1.FirstSeen.Equal(start) || !v1.LastSeen.Equal(start.Add(time.Minute)) {
+		t.Errorf("got first %v and last %v, want the first two invocations", v1.FirstSeen, v1.LastSeen)
+	}
+	if v2.Invocations != 1 || v2.AverageTokens != 5 {
+		t.Errorf("got %+v, want 1 invocation of v2", v2)
+	}
+	if v3.Invocations != 0 || v3.AverageDuration != 0 {
+		t.Errorf("got %+v, want no invocation of v3", v3)
+	}
+}
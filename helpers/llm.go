@@ -1,16 +1,14 @@
 package llm
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 )
 
 // ChatRequest represents the request body for the API
@@ -84,8 +82,38 @@ func extractParamNames(fn interface{}) []string {
 
 // Options represents configuration options for the LLM function
 type Options struct {
-	Debug bool
-	Top   int
+	Debug    bool     // Logs to stderr at debug level when the client has no Logger
+	Model    string   // Overrides the model of the client
+	Top      int      // Number of candidates to request, see Selector
+	Selector Selector // Picks the answer among the Top candidates, defaults to the first one
+
+	// Sampling, left to the provider when not set
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int // Limit of the answer
+	Stop        []string
+
+	ToolChoice    ToolChoice     // Whether the model must call tools, "auto" when not set
+	RunTools      []string       // Tools created with CreateTool that may run, all of them when nil
+	MaxToolSteps  int            // Rounds of tool calls allowed before the model must answer, defaults to 5
+	ContextWindow *ContextWindow // Shortens requests that don't fit in the context of the model
+	Cache         *ResponseCache // Replaces the cache of the client, &ResponseCache{} disables it
+
+	// OpenRouter routing, passed through on the request
+	Models              []string             // Models to try server side if the first one fails
+	Route               string               // "fallback" to use Models
+	ProviderPreferences *ProviderPreferences // Provider routing preferences
+
+	// Reasoning models
+	IncludeReasoning bool               // Ask for the reasoning to be returned
+	Reasoning        *Reasoning         // Reasoning effort and token budget
+	OnContent        func(delta string) // Streams the answer as it is generated
+	OnReasoning      func(delta string) // Streams the reasoning as it is generated
+
+	// Run after the ones of the client, also accepted as parameters of LLM
+	Middleware       []Middleware
+	StreamMiddleware []StreamMiddleware
+	ToolMiddleware   []ToolMiddleware
 }
 
 // ToolFunction stores a function that can be called by the LLM
@@ -231,257 +259,416 @@ func ExecuteTool(name string, arguments string) (string, error) {
 	return tool.fn(args)
 }
 
-func LLM(fn func(string) string, opts ...interface{}) func(string) string {
-	// Parse optional parameters
-	var systemMessage string
-	var options Options
-	var tools []*Tool
+// config holds everything parsed from the optional parameters passed to LLM
+type config struct {
+	systemMessage string
+	options       Options
+	tools         []*Tool
+	client        *Client
+	budgets       []*budgetState
+	lineage       *Lineage
+}
+
+// newConfig parses the optional parameters of LLM, LLMWithResult and
+// NewConversation
+func newConfig(opts ...interface{}) config {
+	c := config{client: DefaultClient}
+	var middleware []Middleware
+	var streamMiddleware []StreamMiddleware
+	var toolMiddleware []ToolMiddleware
 	for _, opt := range opts {
 		switch v := opt.(type) {
 		case string:
-			systemMessage = v
+			c.systemMessage = v
 		case Options:
-			options = v
+			c.options = v
 		case *Tool:
-			tools = append(tools, v)
+			c.tools = append(c.tools, v)
 		case []*Tool:
-			tools = append(tools, v...)
+			c.tools = append(c.tools, v...)
+		case *Client:
+			c.client = v
+		case Budget:
+			c.budgets = append(c.budgets, newBudgetState(v, "function"))
+		case Lineage:
+			c.lineage = &v
+		case Middleware:
+			middleware = append(middleware, v)
+		case func(Handler) Handler:
+			middleware = append(middleware, v)
+		case StreamMiddleware:
+			streamMiddleware = append(streamMiddleware, v)
+		case func(StreamHandler) StreamHandler:
+			streamMiddleware = append(streamMiddleware, v)
+		case ToolMiddleware:
+			toolMiddleware = append(toolMiddleware, v)
+		case func(ToolHandler) ToolHandler:
+			toolMiddleware = append(toolMiddleware, v)
+		case ToolHooks:
+			toolMiddleware = append(toolMiddleware, v.Middleware())
 		}
 	}
+	// Added last so an Options parameter doesn't drop them
+	if len(middleware) > 0 {
+		c.options.Middleware = append(append([]Middleware(nil), c.options.Middleware...), middleware...)
+	}
+	if len(streamMiddleware) > 0 {
+		c.options.StreamMiddleware = append(append([]StreamMiddleware(nil), c.options.StreamMiddleware...), streamMiddleware...)
+	}
+	if len(toolMiddleware) > 0 {
+		c.options.ToolMiddleware = append(append([]ToolMiddleware(nil), c.options.ToolMiddleware...), toolMiddleware...)
+	}
+	return c
+}
+
+// context prepares the context of a call made with this configuration
+func (c *config) context(ctx context.Context) (context.Context, *usageTracker) {
+	ctx = WithClient(ctx, c.client)
+	ctx, usage := withUsageTracker(ctx)
+	return withBudgets(ctx, c.budgets), usage
+}
+
+// llmFunc is the function wrapped by LLM with its configuration
+type llmFunc struct {
+	config
+	fn      func(string) string
+	name    string // Names the spans of the calls
+	lineage *lineage
+}
+
+// newLLMFunc wraps fn, versioning it when given a Lineage
+func newLLMFunc(fn func(string) string, opts ...interface{}) *llmFunc {
+	f := &llmFunc{config: newConfig(opts...), fn: fn, name: funcName(fn)}
+	if f.config.lineage != nil {
+		f.lineage = newLineage(*f.config.lineage, fn, &f.config)
+		f.name = f.lineage.Name
+	}
+	return f
+}
+
+func LLM(fn func(string) string, opts ...interface{}) func(string) string {
+	f := newLLMFunc(fn, opts...)
 
 	return func(input string) string {
-		// Get the original function result
-		original := fn(input)
+		result, err := f.run(context.Background(), input)
+		if err != nil {
+			// Fall back to the original prompt like before
+			return result.Prompt
+		}
+		return result.Content
+	}
+}
 
-		// Create messages array
-		messages := []Message{}
+// LLMWithResult works like LLM but returns the full Result, including every
+// candidate requested through Options.Top and the transcript of the call
+func LLMWithResult(fn func(string) string, opts ...interface{}) func(context.Context, string) (*Result, error) {
+	return newLLMFunc(fn, opts...).run
+}
 
-		// Add system message if provided
-		if systemMessage != "" {
-			messages = append(messages, Message{
-				Role:    "system",
-				Content: systemMessage,
-			})
+// run calls the function in a span and records the call when it is versioned
+func (f *llmFunc) run(ctx context.Context, input string) (*Result, error) {
+	version := ""
+	if f.lineage != nil {
+		version = f.lineage.version.Version
+	}
+	ctx, span := f.client.startInvocation(ctx, f.name, version, f.options)
+
+	start := time.Now()
+	result, err := f.call(ctx, input)
+	endInvocation(span, result, err)
+	if f.lineage != nil {
+		if recordErr := f.lineage.record(ctx, &f.config, input, start, result, err); recordErr != nil {
+			f.client.logger(f.options).WarnContext(ctx, "invocation not recorded", "function", f.lineage.Name, "error", recordErr)
 		}
+	}
+	return result, err
+}
 
-		// Add user message
+// call sends the prompt produced by the wrapped function, executes any tool
+// calls and selects the final answer among the returned candidates
+func (f *llmFunc) call(ctx context.Context, input string) (*Result, error) {
+	ctx, usage := f.context(ctx)
+
+	// Get the original function result
+	original := f.fn(input)
+	result := &Result{Prompt: original, Client: f.client}
+	defer func() {
+		result.Usage = usage.get()
+	}()
+
+	// Create messages array
+	messages := []Message{}
+
+	// Add system message if provided
+	if f.systemMessage != "" {
 		messages = append(messages, Message{
-			Role:    "user",
-			Content: original,
+			Role:    "system",
+			Content: f.systemMessage,
 		})
+	}
+
+	// Add user message
+	messages = append(messages, Message{
+		Role:    "user",
+		Content: original,
+	})
+
+	messages, response, err := toolLoop(ctx, f.client, messages, f.options, f.tools)
+	if err != nil {
+		return result.failed(messages, err)
+	}
+
+	result.choose(ctx, f.options, messages, response)
+	return result, nil
+}
+
+// defaultMaxToolSteps is the number of tool rounds allowed when
+// Options.MaxToolSteps is not set
+const defaultMaxToolSteps = 5
+
+// toolLoop sends the messages and executes the tool calls the model asks for
+// until it answers without calling tools. It returns the transcript leading to
+// the final response.
+func toolLoop(ctx context.Context, client *Client, messages []Message, options Options, tools []*Tool) ([]Message, *ResponseData, error) {
+	options = streamOptions(ctx, options)
+	maxSteps := options.MaxToolSteps
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxToolSteps
+	}
+
+	for step := 0; ; step++ {
+		// Leave the tools out of the last request to force an answer
+		requestTools := tools
+		if step == maxSteps {
+			requestTools = nil
+		}
+
+		// Shorten the request if it outgrew the context
+		request, err := fitContext(ctx, client, messages, options, requestTools)
+		if err != nil {
+			return messages, nil, err
+		}
 
 		// Send the chat request with tools if provided
-		response, err := chat(API_KEY, MODEL, messages, options, tools...)
+		response, err := complete(ctx, client, request, options, requestTools...)
 		if err != nil {
-			if options.Debug {
-				fmt.Printf("Error in LLM request: %v\n", err)
-			}
-			return original
+			client.logger(options).ErrorContext(ctx, "request failed", "model", client.model(options), "error", err)
+			return messages, nil, err
 		}
 
 		// Handle the response
 		if len(response.Choices) == 0 {
-			if options.Debug {
-				fmt.Println("No response from LLM")
-			}
-			return original
+			client.logger(options).ErrorContext(ctx, "empty response", "model", client.model(options))
+			return messages, nil, fmt.Errorf("no response from LLM")
 		}
 
 		// Check for tool calls in the response
-		if len(response.Choices) > 0 && response.Choices[0].Message != nil && len(response.Choices[0].Message.ToolCalls) > 0 {
-			// Execute each tool call
-			for _, toolCall := range response.Choices[0].Message.ToolCalls {
-				if toolCall.Function != nil {
-					// Execute the tool and get result
-					result, err := ExecuteTool(toolCall.Function.Name, toolCall.Function.Arguments)
-					if err != nil {
-						if options.Debug {
-							fmt.Printf("Error executing tool %s: %v\n", toolCall.Function.Name, err)
-						}
-						continue
-					}
-
-					// Add the assistant message with tool calls
-					messages = append(messages, Message{
-						Role:      "assistant",
-						Content:   "",
-						ToolCalls: []*ToolCall{toolCall},
-					})
-
-					// Add the tool result message with tool_call_id
-					messages = append(messages, Message{
-						Role:       "tool",
-						Content:    result,
-						ToolCallID: toolCall.Id,
-					})
-				}
+		toolChoice := choiceWithToolCalls(response)
+		if toolChoice == nil {
+			return messages, response, nil
+		}
+
+		// Tools without a function are run by the caller, hand the calls back
+		if callsClientTool(toolChoice, tools, options) {
+			return messages, response, nil
+		}
+
+		// Add the assistant message with tool calls
+		messages = append(messages, Message{
+			Role:          "assistant",
+			Content:       toolChoice.Message.Content,
+			ToolCalls:     toolChoice.Message.ToolCalls,
+			ReasoningData: toolChoice.Message.ReasoningData,
+		})
+
+		// Execute each tool call
+		for _, toolCall := range toolChoice.Message.ToolCalls {
+			if toolCall.Function == nil {
+				continue
 			}
 
-			// Get final response with tool results
-			finalResponse, err := chat(API_KEY, MODEL, messages, options)
+			// Execute the tool through its middleware, errors are reported to the model
+			call := &ToolInvocation{
+				ID:        toolCall.Id,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			}
+			started := time.Now()
+			output, err := client.runTool(ctx, options, call)
 			if err != nil {
-				if options.Debug {
-					fmt.Printf("Error in final LLM request: %v\n", err)
-				}
-				return original
+				client.logger(options).WarnContext(ctx, "tool failed",
+					"tool", toolCall.Function.Name, "id", toolCall.Id, "error", err)
+				output = fmt.Sprintf("Error: %v", err)
+			} else {
+				client.logger(options).DebugContext(ctx, "tool called",
+					"tool", toolCall.Function.Name, "id", toolCall.Id,
+					"arguments", logText(client.logContent(), call.Arguments),
+					"result", logText(client.logContent(), output),
+					"duration", time.Since(started))
 			}
 
-			if len(finalResponse.Choices) > 0 {
-				return finalResponse.Choices[0].Message.Content
-			}
+			// Add the tool result message with tool_call_id
+			messages = append(messages, Message{
+				Role:       "tool",
+				Content:    output,
+				ToolCallID: toolCall.Id,
+			})
 		}
-
-		// Return the original response if no tool calls
-		return response.Choices[0].Message.Content
 	}
 }
 
-func chat(apiKey, model string, messages []Message, options Options, tools ...*Tool) (*ResponseData, error) {
-	// Prepare the request payload
-	requestBody := Request{
-		Model:    model,
-		Messages: messages,
+// runs reports whether the tool loop runs the calls to a tool, which must
+// be created with CreateTool and allowed by RunTools
+func (o Options) runs(name string) bool {
+	if _, exists := toolFunctions[name]; !exists {
+		return false
 	}
-
-	// Add tools if provided
-	if len(tools) > 0 {
-		toolSlice := make([]Tool, len(tools))
-		for i, t := range tools {
-			if t != nil {
-				toolSlice[i] = *t
-			}
-		}
-		requestBody.Tools = toolSlice
-		requestBody.ToolChoice = "auto"
+	if o.RunTools == nil {
+		return true
 	}
-
-	// Convert the struct to JSON
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling request body: %w", err)
+	for _, allowed := range o.RunTools {
+		if allowed == name {
+			return true
+		}
 	}
+	return false
+}
 
-	// Print the raw request in JSON
-	if options.Debug {
-		var prettyJSON bytes.Buffer
-		if err := json.Indent(&prettyJSON, jsonData, "", "  "); err != nil {
-			fmt.Printf("Error formatting JSON: %v\n", err)
-		} else {
-			fmt.Printf("Raw request: %s\n", prettyJSON.String())
+// callsClientTool reports whether the choice calls one of the tools that the
+// tool loop doesn't run
+func callsClientTool(choice *Choice, tools []*Tool, options Options) bool {
+	for _, toolCall := range choice.Message.ToolCalls {
+		if toolCall.Function == nil {
+			continue
+		}
+		name := toolCall.Function.Name
+		if options.runs(name) {
+			continue
+		}
+		for _, tool := range tools {
+			if tool != nil && (tool.Name == name || tool.Function != nil && tool.Function.Name == name) {
+				return true
+			}
 		}
 	}
+	return false
+}
 
-	// Create a new HTTP request
-	url := DEFAULT_URL
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+// Chat sends messages as they are, runs the tools created with CreateTool and
+// returns the final answer. Tools passed without a function or left out of
+// Options.RunTools, like the ones a client declares, are not run: the
+// response asking for them is returned so the caller can. The optional parameters are the ones of LLM.
+func Chat(ctx context.Context, messages []Message, opts ...interface{}) (*Result, error) {
+	c := newConfig(opts...)
+	ctx, usage := c.context(ctx)
+
+	if c.systemMessage != "" {
+		messages = append([]Message{{Role: "system", Content: c.systemMessage}}, messages...)
 	}
 
-	// Add headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	result := &Result{Client: c.client}
+	defer func() {
+		result.Usage = usage.get()
+	}()
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	messages, response, err := toolLoop(ctx, c.client, messages, c.options, c.tools)
 	if err != nil {
-		return nil, fmt.Errorf("error sending request: %w", err)
+		return result.failed(messages, err)
 	}
-	defer resp.Body.Close()
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
+	result.choose(ctx, c.options, messages, response)
+	return result, nil
+}
 
-	// Print the raw response in JSON
-	body = bytes.TrimSpace(body)
-	if options.Debug {
-		var prettyJSON bytes.Buffer
-		if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
-			fmt.Printf("Error formatting JSON: %v\n", err)
-		} else {
-			fmt.Printf("Raw response: %s\n", prettyJSON.String())
-		}
+// newRequest prepares the request payload for a chat call
+func newRequest(client *Client, messages []Message, options Options, tools ...*Tool) *Request {
+	requestBody := &Request{
+		Model:     client.model(options),
+		Messages:  messages,
+		Models:    options.Models,
+		Route:     options.Route,
+		Provider:  options.ProviderPreferences,
+		Reasoning: options.Reasoning,
+
+		Temperature: options.Temperature,
+		TopP:        options.TopP,
+		MaxTokens:   options.MaxTokens,
+		Stop:        options.Stop,
+	}
+	if options.IncludeReasoning {
+		requestBody.IncludeReasoning = &options.IncludeReasoning
 	}
 
-	// Declare chatResponse variable
-	var chatResponse ResponseData
-	if err := json.Unmarshal(body, &chatResponse); err != nil {
-		return nil, fmt.Errorf("error decoding response: %w", err)
+	// Ask for several completions at once when Top is set
+	if options.Top > 1 {
+		requestBody.N = options.Top
 	}
 
-	// Check for non-200 status codes
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	// Add tools if provided
+	if len(tools) > 0 {
+		requestBody.Tools = tools
+		requestBody.ToolChoice = "auto"
+		if options.ToolChoice != "" {
+			requestBody.ToolChoice = options.ToolChoice
+		}
 	}
 
-	return &chatResponse, nil
+	return requestBody
 }
 
-func chatStream(messages []Message) (<-chan string, <-chan error) {
-	// Create channels for chunks and errors
-	chunks := make(chan string)
-	errs := make(chan error, 1)
-
-	go func() {
-		defer close(chunks)
-		defer close(errs)
-
-		// Prepare the request payload
-		requestBody := Request{
-			Model:    MODEL,
-			Messages: messages,
-			Stream:   true,
-		}
-
-		// Convert the struct to JSON
-		jsonData, err := json.Marshal(requestBody)
-		if err != nil {
-			errs <- fmt.Errorf("error marshaling request body: %w", err)
-			return
+func chat(ctx context.Context, client *Client, messages []Message, options Options, tools ...*Tool) (response *ResponseData, err error) {
+	streaming := options.OnContent != nil || options.OnReasoning != nil
+	requestBody := newRequest(client, messages, options, tools...)
+	requestBody.Stream = streaming
+
+	ctx, span := client.startChat(ctx, requestBody)
+	start := time.Now()
+	var firstChunk time.Time
+	defer func() {
+		endChat(span, response, err)
+		var firstToken time.Duration
+		if !firstChunk.IsZero() {
+			firstToken = firstChunk.Sub(start)
 		}
+		client.observeRequest(ctx, requestBody, response, err, time.Since(start), firstToken)
+	}()
 
-		// Create a new HTTP request
-		url := DEFAULT_URL
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	// Stream the response when someone is listening to it
+	if streaming {
+		streamCtx, by := withAnsweredBy(ctx)
+		chunks, errs := chatStream(streamCtx, client, requestBody, options)
+		chatResponse, err := accumulate(timeFirst(chunks, &firstChunk), errs, options)
 		if err != nil {
-			errs <- fmt.Errorf("error creating request: %w", err)
-			return
+			return nil, err
 		}
-
-		// Add headers
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+API_KEY)
-
-		// Send the request
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			errs <- fmt.Errorf("error sending request: %w", err)
-			return
+		// Catch what the stream could not split
+		stripThink(chatResponse, headlessReasoning(options))
+		client.logResponse(ctx, options, chatResponse, time.Since(start))
+
+		// Price the usage at the model that answered, a fallback may have
+		model := by.model
+		if model == "" {
+			model = client.model(options)
 		}
-		defer resp.Body.Close()
+		client.recordUsage(ctx, model, chatResponse)
+		return chatResponse, nil
+	}
 
-		// Check for non-200 status codes
-		if resp.StatusCode != http.StatusOK {
-			errs <- fmt.Errorf("request failed with status code: %d", resp.StatusCode)
-			return
-		}
+	chatResponse, err := client.handler(options)(ctx, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	client.logResponse(ctx, options, chatResponse, time.Since(start))
 
-		// Stream the response line by line
-		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
-			chunks <- scanner.Text() // Send each line to the channel
-		}
+	// Move inline <think> blocks out of the answer
+	stripThink(chatResponse, headlessReasoning(options))
 
-		// Check for scanner errors
-		if err := scanner.Err(); err != nil {
-			errs <- fmt.Errorf("error reading streamed response: %w", err)
-		}
-	}()
+	return chatResponse, nil
+}
 
-	return chunks, errs
+// chatStream streams a chat request. Inline <think> blocks are moved from the
+// content of the deltas to their reasoning.
+func chatStream(ctx context.Context, client *Client, requestBody *Request, options Options) (<-chan *ResponseData, <-chan error) {
+	chunks, errs := client.streamHandler(options)(ctx, requestBody)
+	return splitThinkStream(chunks, headlessReasoning(options)), errs
 }
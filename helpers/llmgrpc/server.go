@@ -0,0 +1,240 @@
+// Package llmgrpc serves LLM functions and tools over gRPC so services
+// written in other languages can call them. The service is defined in
+// service.proto:
+//
+//	srv := llmgrpc.NewServer()
+//	srv.Register("summarize", "Summarizes a text", summarize, "Be brief", tools)
+//	gs := grpc.NewServer()
+//	llmgrpc.RegisterLLMServiceServer(gs, srv)
+//	gs.Serve(listener)
+package llmgrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements LLMServiceServer for a set of named functions and tools
+type Server struct {
+	UnimplementedLLMServiceServer
+
+	mu        sync.RWMutex
+	functions map[string]*function
+	tools     map[string]*tool
+}
+
+// function is a registered LLM function
+type function struct {
+	info *FunctionInfo
+	run  func(context.Context, string) (*llm.Result, error)
+}
+
+// tool is a registered tool with the parameters of llm.CallTool, which give
+// it the tool middleware of the function or client it came with
+type tool struct {
+	tool *llm.Tool
+	opts []interface{}
+}
+
+// NewServer returns a server without functions
+func NewServer() *Server {
+	return &Server{
+		functions: make(map[string]*function),
+		tools:     make(map[string]*tool),
+	}
+}
+
+// Register serves fn under name. The optional parameters are the ones of
+// llm.LLM; the tools among them can also be called with CallTool.
+func (s *Server) Register(name, description string, fn func(string) string, opts ...interface{}) {
+	info := &FunctionInfo{Name: name, Description: description}
+	for _, opt := range opts {
+		switch v := opt.(type) {
+		case llm.Options:
+			info.Model = v.Model
+		case *llm.Tool:
+			info.Tools = append(info.Tools, v)
+		case []*llm.Tool:
+			info.Tools = append(info.Tools, v...)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.functions[name] = &function{info: info, run: llm.LLMWithResult(fn, opts...)}
+	for _, t := range info.Tools {
+		s.tools[toolName(t)] = &tool{tool: t, opts: opts}
+	}
+}
+
+// RegisterTool makes a tool created with llm.CreateTool callable with
+// CallTool. The optional parameters are the ones of llm.LLM, their client
+// and tool middleware run around each call.
+func (s *Server) RegisterTool(t *llm.Tool, opts ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tools[toolName(t)] = &tool{tool: t, opts: opts}
+}
+
+// toolName returns the name a tool is executed by
+func toolName(tool *llm.Tool) string {
+	if tool.Function != nil && tool.Function.Name != "" {
+		return tool.Function.Name
+	}
+	return tool.Name
+}
+
+func (s *Server) function(name string) (*function, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	f, ok := s.functions[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "function not found: %s", name)
+	}
+	return f, nil
+}
+
+func (s *Server) Complete(ctx context.Context, req *CompleteRequest) (*CompleteResponse, error) {
+	f, err := s.function(req.Function)
+	if err != nil {
+		return nil, err
+	}
+	result, err := f.run(ctx, req.Input)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return completeResponse(result), nil
+}
+
+func (s *Server) StreamComplete(req *CompleteRequest, stream grpc.ServerStreamingServer[CompleteChunk]) error {
+	f, err := s.function(req.Function)
+	if err != nil {
+		return err
+	}
+
+	// Stop the call if the client goes away
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	var sendErr error
+	send := func(chunk *CompleteChunk) {
+		if sendErr != nil {
+			return
+		}
+		if sendErr = stream.Send(chunk); sendErr != nil {
+			cancel()
+		}
+	}
+
+	ctx = llm.StreamTo(ctx,
+		func(delta string) { send(&CompleteChunk{Content: delta}) },
+		func(delta string) { send(&CompleteChunk{Reasoning: delta}) },
+	)
+	result, err := f.run(ctx, req.Input)
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return statusFromError(err)
+	}
+	return stream.Send(&CompleteChunk{Response: completeResponse(result)})
+}
+
+func (s *Server) ListFunctions(ctx context.Context, req *ListFunctionsRequest) (*ListFunctionsResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	response := &ListFunctionsResponse{}
+	for _, f := range s.functions {
+		response.Functions = append(response.Functions, f.info)
+	}
+	for _, t := range s.tools {
+		response.Tools = append(response.Tools, t.tool)
+	}
+	sort.Slice(response.Functions, func(i, j int) bool {
+		return response.Functions[i].Name < response.Functions[j].Name
+	})
+	sort.Slice(response.Tools, func(i, j int) bool {
+		return toolName(response.Tools[i]) < toolName(response.Tools[j])
+	})
+	return response, nil
+}
+
+func (s *Server) CallTool(ctx context.Context, req *CallToolRequest) (*CallToolResponse, error) {
+	s.mu.RLock()
+	t, ok := s.tools[req.Name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "tool not found: %s", req.Name)
+	}
+
+	arguments := req.Arguments
+	if arguments == "" {
+		arguments = "{}"
+	}
+	call := &llm.ToolInvocation{Name: req.Name, Arguments: arguments}
+	output, err := llm.CallTool(ctx, call, t.opts...)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+	return &CallToolResponse{Output: output}, nil
+}
+
+// completeResponse converts the result of a call
+func completeResponse(result *llm.Result) *CompleteResponse {
+	response := &CompleteResponse{
+		Prompt:    result.Prompt,
+		Content:   result.Content,
+		Reasoning: result.Reasoning,
+		Usage: &UsageTotals{
+			Requests:         int32(result.Usage.Requests),
+			PromptTokens:     int32(result.Usage.PromptTokens),
+			CompletionTokens: int32(result.Usage.CompletionTokens),
+			TotalTokens:      int32(result.Usage.TotalTokens),
+			Cost:             result.Usage.Cost,
+		},
+	}
+	for i := range result.Messages {
+		response.Messages = append(response.Messages, &result.Messages[i])
+	}
+	return response
+}
+
+// statusFromError converts the error of a call to a gRPC status
+func statusFromError(err error) error {
+	if s, ok := status.FromError(err); ok {
+		return s.Err()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	var budgetErr *llm.BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	var apiErr *llm.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusBadRequest:
+			return status.Error(codes.InvalidArgument, err.Error())
+		case apiErr.StatusCode == http.StatusUnauthorized:
+			return status.Error(codes.Unauthenticated, err.Error())
+		case apiErr.StatusCode == http.StatusForbidden:
+			return status.Error(codes.PermissionDenied, err.Error())
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return status.Error(codes.ResourceExhausted, err.Error())
+		case apiErr.StatusCode >= 500:
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
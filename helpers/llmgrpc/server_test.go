@@ -0,0 +1,48 @@
+package llmgrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func capital(country string) string { return "The capital of " + country }
+
+// TestCallToolRunsMiddleware checks that CallTool goes through the tool
+// middleware of the registration and reports failures as internal errors
+func TestCallToolRunsMiddleware(t *testing.T) {
+	hooks := llm.ToolHooks{
+		Before: func(ctx context.Context, call *llm.ToolInvocation) error {
+			if call.Arguments == `{"country":"Atlantis"}` {
+				return errors.New("unknown country")
+			}
+			call.Arguments = `{"country":"France"}`
+			return nil
+		},
+	}
+	srv := NewServer()
+	srv.RegisterTool(llm.CreateTool("capital", "Capital of a country", capital), hooks)
+	ctx := context.Background()
+
+	response, err := srv.CallTool(ctx, &CallToolRequest{Name: "capital", Arguments: `{"country":"Spain"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Output != "The capital of France" {
+		t.Errorf("got %q, want the arguments changed by the hook", response.Output)
+	}
+
+	_, err = srv.CallTool(ctx, &CallToolRequest{Name: "capital", Arguments: `{"country":"Atlantis"}`})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("got %v, want an internal error", err)
+	}
+
+	_, err = srv.CallTool(ctx, &CallToolRequest{Name: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("got %v, want not found", err)
+	}
+}
@@ -0,0 +1,251 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// LogContent sets how much of the messages and tool arguments goes in the
+// logs. Prompts often hold personal data, so only their size is logged unless
+// asked otherwise.
+type LogContent int
+
+const (
+	LogContentNone      LogContent = iota // Roles and lengths only
+	LogContentTruncated                   // The first logContentLimit characters
+	LogContentFull                        // Everything
+)
+
+// logContentLimit is the length of the content kept by LogContentTruncated
+const logContentLimit = 200
+
+// debugLogger is used by calls with Options.Debug on a client without Logger
+var debugLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+// discardLogger drops everything when logging is not configured
+var discardLogger = slog.New(discardHandler{})
+
+// logger returns the logger of the client. API keys are redacted from
+// everything it writes.
+func (c *Client) logger(options Options) *slog.Logger {
+	switch {
+	case c != nil && c.Logger != nil:
+		return slog.New(redactHandler{c.Logger.Handler(), c.secrets()})
+	case options.Debug:
+		return slog.New(redactHandler{debugLogger.Handler(), c.secrets()})
+	}
+	return discardLogger
+}
+
+// secrets returns the API keys of the providers of the client and API_KEY
+func (c *Client) secrets() []string {
+	var secrets []string
+	add := func(key string) {
+		if key != "" {
+			secrets = append(secrets, key)
+		}
+	}
+	add(API_KEY)
+	if c == nil {
+		return secrets
+	}
+	providers := []Provider{c.Provider}
+	for _, fallback := range c.Fallbacks {
+		providers = append(providers, fallback.Provider)
+	}
+	for _, provider := range providers {
+		switch p := provider.(type) {
+		case *OpenAIProvider:
+			add(p.APIKey)
+		case *AnthropicProvider:
+			add(p.APIKey)
+		case *GeminiProvider:
+			add(p.APIKey)
+		}
+	}
+	return secrets
+}
+
+// logContent returns how much content the client logs
+func (c *Client) logContent() LogContent {
+	if c == nil {
+		return LogContentNone
+	}
+	return c.LogContent
+}
+
+// logText formats a piece of content according to mode
+func logText(mode LogContent, s string) slog.Value {
+	switch mode {
+	case LogContentFull:
+		return slog.StringValue(s)
+	case LogContentTruncated:
+		if len(s) > logContentLimit {
+			end := logContentLimit
+			for end > 0 && !utf8.RuneStart(s[end]) {
+				end--
+			}
+			return slog.StringValue(s[:end] + "…")
+		}
+		return slog.StringValue(s)
+	}
+	return slog.IntValue(len(s))
+}
+
+// logMessages lazily formats a transcript for the logs
+type logMessages struct {
+	messages []Message
+	mode     LogContent
+}
+
+func (m logMessages) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(m.messages))
+	for i := range m.messages {
+		message := &m.messages[i]
+		fields := []slog.Attr{slog.String("role", message.Role)}
+		if message.Content != "" {
+			fields = append(fields, slog.Any("content", logText(m.mode, message.Content)))
+		}
+		if len(message.ToolCalls) > 0 {
+			names := make([]string, 0, len(message.ToolCalls))
+			for _, toolCall := range message.ToolCalls {
+				if toolCall.Function != nil {
+					names = append(names, toolCall.Function.Name)
+				}
+			}
+			fields = append(fields, slog.String("tool_calls", strings.Join(names, ",")))
+		}
+		attrs = append(attrs, slog.Attr{Key: strconv.Itoa(i), Value: slog.GroupValue(fields...)})
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// logRequest logs a request about to be sent
+func (c *Client) logRequest(ctx context.Context, options Options, req *Request) {
+	c.logger(options).LogAttrs(ctx, slog.LevelDebug, "request sent",
+		slog.String("model", req.Model),
+		slog.Bool("stream", req.Stream),
+		slog.Int("tools", len(req.Tools)),
+		slog.Any("messages", logMessages{req.Messages, c.logContent()}),
+	)
+}
+
+// logResponse logs a response and how long it took
+func (c *Client) logResponse(ctx context.Context, options Options, response *ResponseData, elapsed time.Duration) {
+	attrs := []slog.Attr{
+		slog.String("model", response.Model),
+		slog.Duration("duration", elapsed),
+		slog.Int("choices", len(response.Choices)),
+	}
+	if response.Usage != nil {
+		attrs = append(attrs,
+			slog.Int("prompt_tokens", int(response.Usage.PromptTokens)),
+			slog.Int("completion_tokens", int(response.Usage.CompletionTokens)),
+		)
+	}
+	if len(response.Choices) > 0 && response.Choices[0] != nil {
+		choice := response.Choices[0]
+		attrs = append(attrs, slog.String("finish_reason", choice.FinishReason))
+		if choice.Message != nil {
+			attrs = append(attrs, slog.Any("content", logText(c.logContent(), choice.Message.Content)))
+		}
+	}
+	c.logger(options).LogAttrs(ctx, slog.LevelDebug, "response received", attrs...)
+}
+
+// secretPatterns match API keys in error messages and URLs
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`),
+	regexp.MustCompile(`(?i)([?&](?:key|api_key)=)[^&\s"']+`),
+	regexp.MustCompile(`(?i)((?:x-api-key|x-goog-api-key|api-key)["']?\s*[:=]\s*["']?)[^\s"',]+`),
+	regexp.MustCompile(`\b(sk-)[A-Za-z0-9_\-]{8,}`),
+}
+
+// redactSecrets replaces the given API keys, and the ones that look like
+// keys, in s
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, "REDACTED")
+	}
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, "${1}REDACTED")
+	}
+	return s
+}
+
+// redactHandler removes API keys from the message and attributes of records
+type redactHandler struct {
+	slog.Handler
+	secrets []string // Known keys, see Client.secrets
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, redactSecrets(r.Message, h.secrets), r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	// The slice belongs to the caller
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = h.redactAttr(attr)
+	}
+	return redactHandler{h.Handler.WithAttrs(redacted), h.secrets}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.Handler.WithGroup(name), h.secrets}
+}
+
+func (h redactHandler) redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(redactSecrets(value.String(), h.secrets))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, a := range group {
+			redacted[i] = h.redactAttr(a)
+		}
+		attr.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			attr.Value = slog.StringValue(redactSecrets(v.Error(), h.secrets))
+		case fmt.Stringer:
+			attr.Value = slog.StringValue(redactSecrets(v.String(), h.secrets))
+		default:
+			// Other values, like structs and maps, are only replaced by their
+			// text when it holds a key
+			text := fmt.Sprintf("%+v", v)
+			if redacted := redactSecrets(text, h.secrets); redacted != text {
+				attr.Value = slog.StringValue(redacted)
+			} else {
+				attr.Value = value
+			}
+		}
+	default:
+		attr.Value = value
+	}
+	return attr
+}
+
+// discardHandler is a slog.Handler that drops every record
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
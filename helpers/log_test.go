@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedactsKeys(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{
+		Provider:  &AnthropicProvider{APIKey: "anthropic-secret"},
+		Fallbacks: []Fallback{{Model: "gemini-2.0-flash", Provider: &GeminiProvider{APIKey: "gemini-secret"}}},
+		Logger:    slog.New(slog.NewJSONHandler(&buf, nil)),
+	}
+	logger := client.logger(Options{})
+
+	endpoint, _ := url.Parse("https://generativelanguage.googleapis.com/v1beta/models?key=unknown-secret")
+	header := http.Header{"X-Api-Key": {"anthropic-secret"}}
+	type settings struct{ Key string }
+	tests := []struct {
+		name string
+		attr slog.Attr
+	}{
+		{"error", slog.Any("error", fmt.Errorf("error calling %s: bad key gemini-secret", "gemini"))},
+		{"url", slog.Any("url", endpoint)},
+		{"header", slog.Any("header", header)},
+		{"struct", slog.Any("settings", settings{Key: "anthropic-secret"})},
+		{"group", slog.Group("request", slog.String("auth", "Bearer anthropic-secret"))},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		logger.Info("request failed", tt.attr)
+		out := buf.String()
+		if strings.Contains(out, "secret") || !strings.Contains(out, "REDACTED") {
+			t.Errorf("%s: got %s, want the key redacted", tt.name, out)
+		}
+	}
+
+	// Values without keys keep their structure
+	buf.Reset()
+	logger.Info("request", slog.Any("settings", settings{Key: "public"}))
+	if !strings.Contains(buf.String(), `"settings":{"Key":"public"}`) {
+		t.Errorf("got %s, want the struct as it was", buf.String())
+	}
+}
+
+func TestLoggerWithAttrsKeepsTheSlice(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{
+		Provider: &OpenAIProvider{APIKey: "openai-secret"},
+		Logger:   slog.New(slog.NewTextHandler(&buf, nil)),
+	}
+	attrs := []slog.Attr{slog.String("auth", "openai-secret")}
+	logger := slog.New(client.logger(Options{}).Handler().WithAttrs(attrs))
+
+	logger.Info("request")
+	if strings.Contains(buf.String(), "openai-secret") {
+		t.Errorf("got %s, want the key redacted", buf.String())
+	}
+	if attrs[0].Value.String() != "openai-secret" {
+		t.Errorf("got %v, want the attributes of the caller untouched", attrs[0])
+	}
+}
@@ -0,0 +1,328 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives a measurement for every request and tool call made through
+// a client. Set Client.Metrics to a PrometheusMetrics or to your own
+// implementation.
+type Metrics interface {
+	ObserveRequest(ctx context.Context, m RequestMetrics)
+	ObserveTool(ctx context.Context, m ToolMetrics)
+}
+
+// RequestMetrics describes a chat request once it completed
+type RequestMetrics struct {
+	Model            string
+	System           string // Provider, as in gen_ai.system
+	Status           string // "ok" or the kind of error, see errorStatus
+	Stream           bool
+	Duration         time.Duration
+	TimeToFirstToken time.Duration // Streams only
+	InputTokens      int
+	OutputTokens     int
+	Cost             float64 // In dollars, 0 when unknown
+}
+
+// ToolMetrics describes a tool execution
+type ToolMetrics struct {
+	Name     string
+	Status   string // "ok" or "error"
+	Duration time.Duration
+}
+
+// errorStatus is the Status of a request that failed with err
+func errorStatus(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	if kind := errorType(err); kind != "_OTHER" {
+		return kind
+	}
+	return "error"
+}
+
+// observeRequest reports a request to the metrics of the client
+func (c *Client) observeRequest(ctx context.Context, req *Request, response *ResponseData, err error, elapsed, firstToken time.Duration) {
+	if c == nil || c.Metrics == nil {
+		return
+	}
+	m := RequestMetrics{
+		Model:            req.Model,
+		System:           c.system(),
+		Status:           errorStatus(err),
+		Stream:           req.Stream,
+		Duration:         elapsed,
+		TimeToFirstToken: firstToken,
+	}
+	if err == nil && response != nil {
+		totals := c.usageOf(req.Model, response)
+		m.InputTokens = totals.PromptTokens
+		m.OutputTokens = totals.CompletionTokens
+		m.Cost = totals.Cost
+	}
+	c.Metrics.ObserveRequest(ctx, m)
+}
+
+// observeTool reports a tool execution to the metrics of the client
+func (c *Client) observeTool(ctx context.Context, name string, err error, elapsed time.Duration) {
+	if c == nil || c.Metrics == nil {
+		return
+	}
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	c.Metrics.ObserveTool(ctx, ToolMetrics{Name: name, Status: status, Duration: elapsed})
+}
+
+// timeFirst forwards the chunks of a stream and records when the first one
+// arrived. first is set before the chunk is forwarded, so it can be read once
+// the first chunk has been received.
+func timeFirst(chunks <-chan *ResponseData, first *time.Time) <-chan *ResponseData {
+	out := make(chan *ResponseData)
+	go func() {
+		defer close(out)
+		for chunk := range chunks {
+			if first.IsZero() {
+				*first = time.Now()
+			}
+			out <- chunk
+		}
+	}()
+	return out
+}
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms
+var DefaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120}
+
+// PrometheusMetrics keeps the metrics of a client in memory and serves them
+// in the Prometheus text format:
+//
+//	metrics := llm.NewPrometheusMetrics()
+//	client := &llm.Client{Metrics: metrics}
+//	http.Handle("/metrics", metrics)
+//
+// It exposes, with Namespace as prefix:
+//
+//	requests_total{model,status}             counter
+//	request_duration_seconds{model}          histogram
+//	time_to_first_token_seconds{model}       histogram, streams only
+//	tokens_total{model,type="input|output"}  counter
+//	cost_dollars_total{model}                counter
+//	tool_calls_total{tool,status}            counter
+//	tool_duration_seconds{tool}              histogram
+type PrometheusMetrics struct {
+	Namespace string    // Prefix of the metric names, defaults to "llm"
+	Buckets   []float64 // Defaults to DefaultBuckets, read once at the first use
+
+	mu         sync.Mutex
+	bounds     []float64 // Buckets when first used
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+// NewPrometheusMetrics returns empty metrics with the default namespace
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{}
+}
+
+// histogram counts observations per bucket
+type histogram struct {
+	counts []uint64 // Not cumulative, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+// metricHelp describes each metric, its keys are the names without namespace
+var metricHelp = map[string][2]string{
+	"requests_total":              {"counter", "Chat requests by model and status."},
+	"request_duration_seconds":    {"histogram", "Latency of chat requests."},
+	"time_to_first_token_seconds": {"histogram", "Time until the first chunk of streamed chat requests."},
+	"tokens_total":                {"counter", "Tokens sent and received."},
+	"cost_dollars_total":          {"counter", "Cost of the chat requests in dollars."},
+	"tool_calls_total":            {"counter", "Tool executions by tool and status."},
+	"tool_duration_seconds":       {"histogram", "Duration of tool executions."},
+}
+
+func (p *PrometheusMetrics) ObserveRequest(ctx context.Context, m RequestMetrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	model := labels("model", m.Model)
+	p.add("requests_total", labels("model", m.Model, "status", m.Status), 1)
+	p.observe("request_duration_seconds", model, m.Duration.Seconds())
+	if m.Stream && m.TimeToFirstToken > 0 {
+		p.observe("time_to_first_token_seconds", model, m.TimeToFirstToken.Seconds())
+	}
+	if m.InputTokens > 0 {
+		p.add("tokens_total", labels("model", m.Model, "type", "input"), float64(m.InputTokens))
+	}
+	if m.OutputTokens > 0 {
+		p.add("tokens_total", labels("model", m.Model, "type", "output"), float64(m.OutputTokens))
+	}
+	if m.Cost > 0 {
+		p.add("cost_dollars_total", model, m.Cost)
+	}
+}
+
+func (p *PrometheusMetrics) ObserveTool(ctx context.Context, m ToolMetrics) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.add("tool_calls_total", labels("tool", m.Name, "status", m.Status), 1)
+	p.observe("tool_duration_seconds", labels("tool", m.Name), m.Duration.Seconds())
+}
+
+// add increases a counter, p.mu must be held
+func (p *PrometheusMetrics) add(name, labels string, value float64) {
+	if p.counters == nil {
+		p.counters = make(map[string]map[string]float64)
+	}
+	if p.counters[name] == nil {
+		p.counters[name] = make(map[string]float64)
+	}
+	p.counters[name][labels] += value
+}
+
+// observe adds a value to a histogram, p.mu must be held
+func (p *PrometheusMetrics) observe(name, labels string, value float64) {
+	if p.histograms == nil {
+		p.histograms = make(map[string]map[string]*histogram)
+	}
+	if p.histograms[name] == nil {
+		p.histograms[name] = make(map[string]*histogram)
+	}
+	buckets := p.buckets()
+	h, ok := p.histograms[name][labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(buckets)+1)}
+		p.histograms[name][labels] = h
+	}
+	h.counts[sort.SearchFloat64s(buckets, value)]++
+	h.sum += value
+	h.count++
+}
+
+// buckets returns the bucket bounds, copied at the first call so histograms
+// keep the size they were created with, p.mu must be held
+func (p *PrometheusMetrics) buckets() []float64 {
+	if p.bounds == nil {
+		bounds := DefaultBuckets
+		if len(p.Buckets) > 0 {
+			bounds = p.Buckets
+		}
+		p.bounds = append([]float64(nil), bounds...)
+		sort.Float64s(p.bounds)
+	}
+	return p.bounds
+}
+
+func (p *PrometheusMetrics) namespace() string {
+	if p.Namespace != "" {
+		return p.Namespace
+	}
+	return "llm"
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	p.write(out)
+	out.Flush()
+}
+
+// write writes the metrics in the Prometheus text format
+func (p *PrometheusMetrics) write(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(metricHelp))
+	for name := range metricHelp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buckets := p.buckets()
+	for _, name := range names {
+		full := p.namespace() + "_" + name
+		help := metricHelp[name]
+		series := sortedKeys(p.counters[name])
+		if help[0] == "histogram" {
+			series = sortedKeys(p.histograms[name])
+		}
+		if len(series) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", full, help[1], full, help[0])
+
+		for _, labels := range series {
+			if help[0] == "counter" {
+				fmt.Fprintf(w, "%s%s %s\n", full, braces(labels), formatFloat(p.counters[name][labels]))
+				continue
+			}
+			h := p.histograms[name][labels]
+			var cumulative uint64
+			for i, bound := range buckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", full, braces(joinLabels(labels, `le="`+formatFloat(bound)+`"`)), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", full, braces(joinLabels(labels, `le="+Inf"`)), h.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", full, braces(labels), formatFloat(h.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", full, braces(labels), h.count)
+		}
+	}
+}
+
+// labels formats name and value pairs as Prometheus labels, without braces
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+escapeLabel(pairs[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,34 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPrometheusBucketsChanged checks that changing Buckets after the first
+// observation keeps the bounds the histograms were created with
+func TestPrometheusBucketsChanged(t *testing.T) {
+	metrics := &PrometheusMetrics{Buckets: []float64{1}}
+	ctx := context.Background()
+	metrics.ObserveTool(ctx, ToolMetrics{Name: "lookup", Status: "ok", Duration: 500 * time.Millisecond})
+
+	metrics.Buckets = []float64{0.5, 1, 2, 5}
+	metrics.ObserveTool(ctx, ToolMetrics{Name: "lookup", Status: "ok", Duration: 3 * time.Second})
+
+	var out strings.Builder
+	metrics.write(&out)
+	for _, want := range []string{
+		`llm_tool_duration_seconds_bucket{tool="lookup",le="1"} 1`,
+		`llm_tool_duration_seconds_bucket{tool="lookup",le="+Inf"} 2`,
+		`llm_tool_duration_seconds_count{tool="lookup"} 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %s in:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `le="5"`) {
+		t.Errorf("got the new buckets in:\n%s", out.String())
+	}
+}
@@ -0,0 +1,194 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+// Handler sends a chat request and returns the complete response
+type Handler func(ctx context.Context, req *Request) (*ResponseData, error)
+
+// StreamHandler sends a chat request and returns the response as chunks, like
+// Provider.ChatStream
+type StreamHandler func(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error)
+
+// Middleware wraps the requests sent by chat. It can change the request,
+// answer without calling next, or inspect and retry what next returns:
+//
+//	func Redact(next llm.Handler) llm.Handler {
+//		return func(ctx context.Context, req *llm.Request) (*llm.ResponseData, error) {
+//			...
+//			return next(ctx, req)
+//		}
+//	}
+//
+// Middleware only sees the requests that are not streamed, streamed ones go
+// through StreamMiddleware. Set them on Client.Middleware for every request of
+// a client or pass them to LLM for the requests of one function. The first
+// one is the outermost, and the ones of the client run before the ones of the
+// function.
+type Middleware func(next Handler) Handler
+
+// StreamMiddleware wraps the requests that are streamed, see Middleware
+type StreamMiddleware func(next StreamHandler) StreamHandler
+
+// handler returns the chain of middleware around the provider of the client
+func (c *Client) handler(options Options) Handler {
+	h := Handler(func(ctx context.Context, req *Request) (*ResponseData, error) {
+		c.logRequest(ctx, options, req)
+		return c.chatWithFallbacks(ctx, req, options)
+	})
+
+	middleware := append(c.middleware(), options.Middleware...)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// streamHandler returns the chain of stream middleware around the provider
+// of the client
+func (c *Client) streamHandler(options Options) StreamHandler {
+	h := StreamHandler(func(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error) {
+		c.logRequest(ctx, options, req)
+		return c.chatStreamWithFallbacks(ctx, req, options)
+	})
+
+	middleware := append(c.streamMiddleware(), options.StreamMiddleware...)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// middleware returns a copy of the middleware of the client
+func (c *Client) middleware() []Middleware {
+	if c == nil {
+		return nil
+	}
+	return append([]Middleware(nil), c.Middleware...)
+}
+
+// streamMiddleware returns a copy of the stream middleware of the client
+func (c *Client) streamMiddleware() []StreamMiddleware {
+	if c == nil {
+		return nil
+	}
+	return append([]StreamMiddleware(nil), c.StreamMiddleware...)
+}
+
+// ToolInvocation is a tool call the model asked for, about to be executed
+type ToolInvocation struct {
+	ID        string
+	Name      string
+	Arguments string // JSON object, tool middleware can change it before the call
+}
+
+// ToolHandler executes a tool call and returns its result
+type ToolHandler func(ctx context.Context, call *ToolInvocation) (string, error)
+
+// ToolMiddleware wraps the execution of the tools created with CreateTool.
+// Like Middleware it is set on Client.ToolMiddleware or passed to LLM, the
+// first one is the outermost. Errors are reported to the model as the result
+// of the call.
+type ToolMiddleware func(next ToolHandler) ToolHandler
+
+// ToolHooks are callbacks around tool calls, passed to LLM or turned into a
+// ToolMiddleware with Middleware
+type ToolHooks struct {
+	// Before runs before the call and can change its arguments. An error
+	// vetoes the call.
+	Before func(ctx context.Context, call *ToolInvocation) error
+	// After runs after a successful call and returns the result to use
+	After func(ctx context.Context, call *ToolInvocation, result string) string
+	// OnError runs when the call failed or was vetoed. It can recover by
+	// returning a result and no error.
+	OnError func(ctx context.Context, call *ToolInvocation, err error) (string, error)
+}
+
+// Middleware returns the hooks as a ToolMiddleware
+func (h ToolHooks) Middleware() ToolMiddleware {
+	return func(next ToolHandler) ToolHandler {
+		return func(ctx context.Context, call *ToolInvocation) (string, error) {
+			result, err := h.call(ctx, call, next)
+			if err != nil {
+				if h.OnError != nil {
+					return h.OnError(ctx, call, err)
+				}
+				return "", err
+			}
+			if h.After != nil {
+				result = h.After(ctx, call, result)
+			}
+			return result, nil
+		}
+	}
+}
+
+// call runs Before then the tool
+func (h ToolHooks) call(ctx context.Context, call *ToolInvocation, next ToolHandler) (string, error) {
+	if h.Before != nil {
+		if err := h.Before(ctx, call); err != nil {
+			return "", fmt.Errorf("tool call vetoed: %w", err)
+		}
+	}
+	return next(ctx, call)
+}
+
+// TruncateToolOutput cuts the results of tools to limit bytes so a huge output
+// doesn't fill the context. A limit of 0 or less leaves them untouched.
+func TruncateToolOutput(limit int) ToolMiddleware {
+	return ToolHooks{
+		After: func(ctx context.Context, call *ToolInvocation, result string) string {
+			if limit <= 0 || len(result) <= limit {
+				return result
+			}
+			end := limit
+			for end > 0 && !utf8.RuneStart(result[end]) {
+				end--
+			}
+			return result[:end] + fmt.Sprintf("\n[truncated %d of %d bytes]", len(result)-end, len(result))
+		},
+	}.Middleware()
+}
+
+// toolHandler returns the chain of tool middleware around ExecuteTool
+func (c *Client) toolHandler(options Options) ToolHandler {
+	h := ToolHandler(func(ctx context.Context, call *ToolInvocation) (string, error) {
+		if !options.runs(call.Name) {
+			return "", fmt.Errorf("tool not found: %s", call.Name)
+		}
+		return ExecuteTool(call.Name, call.Arguments)
+	})
+
+	var middleware []ToolMiddleware
+	if c != nil {
+		middleware = append(middleware, c.ToolMiddleware...)
+	}
+	middleware = append(middleware, options.ToolMiddleware...)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// runTool executes a tool call through the tool middleware, in its own span
+func (c *Client) runTool(ctx context.Context, options Options, call *ToolInvocation) (string, error) {
+	started := time.Now()
+	toolCtx, span := c.startTool(ctx, call)
+	output, err := c.toolHandler(options)(toolCtx, call)
+	endSpan(span, err)
+	c.observeTool(ctx, call.Name, err, time.Since(started))
+	return output, err
+}
+
+// CallTool executes a tool created with CreateTool outside of a chat, with
+// the same tool middleware, spans and metrics as the calls of a model. The
+// optional parameters are the ones of LLM.
+func CallTool(ctx context.Context, call *ToolInvocation, opts ...interface{}) (string, error) {
+	c := newConfig(opts...)
+	ctx, _ = c.context(ctx)
+	return c.client.runTool(ctx, c.options, call)
+}
@@ -0,0 +1,113 @@
+package llm_test
+
+import (
+	"context"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+func echo(input string) string { return input }
+
+// redact is written like the example of Middleware, its type is unnamed
+func redact(next llm.Handler) llm.Handler {
+	return func(ctx context.Context, req *llm.Request) (*llm.ResponseData, error) {
+		req.Messages[len(req.Messages)-1].Content = "[redacted]"
+		return next(ctx, req)
+	}
+}
+
+func TestMiddlewarePlainFunction(t *testing.T) {
+	provider := fake.New()
+	provider.On("[redacted]").Respond("redacted")
+	provider.On("secret").Respond("leaked")
+
+	f := llm.LLM(echo, &llm.Client{Provider: provider}, redact)
+	if got := f("my secret"); got != "redacted" {
+		t.Fatalf("got %q, the middleware did not run", got)
+	}
+}
+
+func TestStreamMiddlewarePlainFunction(t *testing.T) {
+	provider := fake.New()
+	provider.On("hi").Respond("hello")
+
+	ran := false
+	stream := func(next llm.StreamHandler) llm.StreamHandler {
+		return func(ctx context.Context, req *llm.Request) (<-chan *llm.ResponseData, <-chan error) {
+			ran = true
+			return next(ctx, req)
+		}
+	}
+	f := llm.LLM(echo, &llm.Client{Provider: provider}, stream, llm.Options{OnContent: func(string) {}})
+	if got := f("hi"); got != "hello" {
+		t.Fatalf("got %q", got)
+	}
+	if !ran {
+		t.Fatal("the stream middleware did not run")
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	provider := fake.New()
+	provider.On("hi").Respond("hello")
+
+	var order []string
+	trace := func(name string) llm.Middleware {
+		return func(next llm.Handler) llm.Handler {
+			return func(ctx context.Context, req *llm.Request) (*llm.ResponseData, error) {
+				order = append(order, name)
+				return next(ctx, req)
+			}
+		}
+	}
+	client := &llm.Client{Provider: provider, Middleware: []llm.Middleware{trace("client")}}
+	llm.LLM(echo, client, trace("first"), llm.Options{Top: 1}, trace("second"))("hi")
+
+	want := []string{"client", "first", "second"}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+}
+
+func lookup(city string) string { return "sunny in " + city }
+
+func TestToolMiddlewarePlainFunction(t *testing.T) {
+	tool := llm.CreateTool("lookup", "Weather of a city", lookup)
+	provider := fake.New()
+	provider.On("weather").CallTool("lookup", map[string]string{"city": "Paris"}).Respond("done")
+
+	var called string
+	audit := func(next llm.ToolHandler) llm.ToolHandler {
+		return func(ctx context.Context, call *llm.ToolInvocation) (string, error) {
+			called = call.Name
+			return next(ctx, call)
+		}
+	}
+	llm.LLM(echo, &llm.Client{Provider: provider}, []*llm.Tool{tool}, audit)("weather")
+	if called != "lookup" {
+		t.Fatalf("the tool middleware did not run, got %q", called)
+	}
+}
+
+func TestTruncateToolOutput(t *testing.T) {
+	next := func(ctx context.Context, call *llm.ToolInvocation) (string, error) {
+		return "0123456789", nil
+	}
+	for _, limit := range []int{-1, 0, 10, 20} {
+		got, err := llm.TruncateToolOutput(limit)(next)(context.Background(), &llm.ToolInvocation{})
+		if err != nil || got != "0123456789" {
+			t.Errorf("limit %d: got %q, %v", limit, got, err)
+		}
+	}
+	got, _ := llm.TruncateToolOutput(4)(next)(context.Background(), &llm.ToolInvocation{})
+	if want := "0123\n[truncated 6 of 10 bytes]"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,262 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OllamaProvider talks to a local Ollama server through its native API
+type OllamaProvider struct {
+	BaseURL    string       // Defaults to http://localhost:11434
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Think    bool            `json:"think,omitempty"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description,omitempty"`
+		Parameters  *Parameters `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaResponse struct {
+	Model      string        `json:"model"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	// Token counts, only on the last chunk
+	PromptEvalCount int32  `json:"prompt_eval_count"`
+	EvalCount       int32  `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+func (p *OllamaProvider) url() string {
+	base := p.BaseURL
+	if base == "" {
+		base = "http://localhost:11434"
+	}
+	return strings.TrimRight(base, "/") + "/api/chat"
+}
+
+// toOllama translates a request to the /api/chat format. Ollama uses the
+// OpenAI message layout but passes tool arguments as objects.
+func toOllama(req *Request, stream bool) *ollamaRequest {
+	out := &ollamaRequest{
+		Model:  req.Model,
+		Stream: stream,
+		Think:  (req.IncludeReasoning != nil && *req.IncludeReasoning) || req.Reasoning != nil,
+	}
+	if req.Temperature != nil || req.TopP != nil || req.MaxTokens > 0 || len(req.Stop) > 0 {
+		out.Options = &ollamaOptions{
+			Temperature: req.Temperature,
+			TopP:        req.TopP,
+			NumPredict:  req.MaxTokens,
+			Stop:        req.Stop,
+		}
+	}
+	names := toolNames(req.Messages)
+
+	for _, t := range req.Tools {
+		if t == nil || t.Function == nil {
+			continue
+		}
+		tool := ollamaTool{Type: "function"}
+		tool.Function.Name = t.Function.Name
+		tool.Function.Description = t.Description
+		tool.Function.Parameters = t.Function.Parameters
+		out.Tools = append(out.Tools, tool)
+	}
+
+	for i := range req.Messages {
+		m := &req.Messages[i]
+		msg := ollamaMessage{
+			Role:    m.Role,
+			Content: m.Content,
+		}
+		if m.Role == "tool" {
+			msg.ToolName = names[m.ToolCallID]
+		}
+		for _, tc := range m.ToolCalls {
+			if tc == nil || tc.Function == nil {
+				continue
+			}
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = toolArguments(tc.Function.Arguments)
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+
+	return out
+}
+
+// fromOllama translates a response or stream chunk. Ollama doesn't give tool
+// calls an ID, so one is made up from the offset of the call.
+func fromOllama(resp *ollamaResponse, offset int) *ResponseData {
+	message := &Message{
+		Role:      resp.Message.Role,
+		Content:   resp.Message.Content,
+		Reasoning: resp.Message.Thinking,
+	}
+	for i, call := range resp.Message.ToolCalls {
+		index := offset + i
+		message.ToolCalls = append(message.ToolCalls, &ToolCall{
+			Id:   fmt.Sprintf("call_%d", index),
+			Type: "function",
+			Function: &Function{
+				Name:      call.Function.Name,
+				Arguments: string(toolArguments(string(call.Function.Arguments))),
+			},
+			Index: int32(index),
+		})
+	}
+
+	choice := &Choice{Message: message}
+	if resp.Done {
+		choice.FinishReason = resp.DoneReason
+		if choice.FinishReason == "" {
+			choice.FinishReason = "stop"
+		}
+	}
+	if len(message.ToolCalls) > 0 {
+		choice.FinishReason = "tool_calls"
+	}
+
+	out := &ResponseData{
+		Object:  "chat.completion",
+		Created: resp.CreatedAt.Unix(),
+		Model:   resp.Model,
+		Choices: []*Choice{choice},
+	}
+	if resp.Done {
+		out.Usage = &Usage{
+			PromptTokens:     resp.PromptEvalCount,
+			CompletionTokens: resp.EvalCount,
+			TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+		}
+	}
+	return out
+}
+
+func (p *OllamaProvider) Chat(ctx context.Context, req *Request) (*ResponseData, error) {
+	resp, err := postJSON(ctx, p.HTTPClient, p.url(), nil, toOllama(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ollamaResp ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	if ollamaResp.Error != "" {
+		return nil, fmt.Errorf("ollama error: %s", ollamaResp.Error)
+	}
+	return fromOllama(&ollamaResp, 0), nil
+}
+
+func (p *OllamaProvider) ChatStream(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error) {
+	chunks := make(chan *ResponseData)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		defer close(errs)
+
+		resp, err := postJSON(ctx, p.HTTPClient, p.url(), nil, toOllama(req, true))
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		// The stream is newline delimited JSON
+		toolCalls := 0
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			var ollamaResp ollamaResponse
+			if err := json.Unmarshal([]byte(line), &ollamaResp); err != nil {
+				errs <- fmt.Errorf("error decoding stream chunk: %w", err)
+				return
+			}
+			if ollamaResp.Error != "" {
+				errs <- fmt.Errorf("ollama error: %s", ollamaResp.Error)
+				return
+			}
+
+			chunk := fromOllama(&ollamaResp, toolCalls)
+			chunk.Object = "chat.completion.chunk"
+			choice := chunk.Choices[0]
+			toolCalls += len(choice.Message.ToolCalls)
+			choice.Delta = &Delta{
+				Content:   choice.Message.Content,
+				Reasoning: choice.Message.Reasoning,
+				ToolCalls: choice.Message.ToolCalls,
+			}
+			choice.Message = nil
+			if toolCalls > 0 && choice.FinishReason == "stop" {
+				choice.FinishReason = "tool_calls"
+			}
+
+			if err := send(ctx, chunks, chunk); err != nil {
+				errs <- err
+				return
+			}
+			if ollamaResp.Done {
+				return
+			}
+		}
+
+		// Check for scanner errors
+		if err := scanner.Err(); err != nil {
+			errs <- fmt.Errorf("error reading streamed response: %w", err)
+		}
+	}()
+
+	return chunks, errs
+}
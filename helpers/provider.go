@@ -0,0 +1,321 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Provider sends chat requests to a model backend. Requests and responses use
+// the OpenAI-compatible types, adapters translate them to their own format.
+type Provider interface {
+	// Chat sends a request and returns the complete response
+	Chat(ctx context.Context, req *Request) (*ResponseData, error)
+	// ChatStream sends a request and returns the response as chunks whose
+	// choices carry a Delta. Both channels are closed when the stream ends.
+	ChatStream(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error)
+}
+
+// Client groups the provider and default model used by LLM functions.
+// Pass a *Client to LLM to use it instead of DefaultClient.
+type Client struct {
+	Provider       Provider             // Defaults to an OpenAIProvider using DEFAULT_URL and API_KEY
+	Model          string               // Defaults to MODEL
+	Fallbacks      []Fallback           // Tried in order when a request fails
+	FallbackPolicy *FallbackPolicy      // When to use the fallbacks, defaults to DefaultFallbackPolicy
+	Prices         Pricer               // Prices models, otherwise the cost reported by the provider is used
+	Budgets        []Budget             // Limits for everything sent through the client
+	Cache          *ResponseCache       // Answers repeated requests without sending them
+	Logger         *slog.Logger         // Receives requests, responses, tool calls and fallbacks
+	LogContent     LogContent           // How much of the messages the Logger gets, sizes only by default
+	TracerProvider trace.TracerProvider // Creates the spans of calls, requests and tools, defaults to the global one
+	Metrics        Metrics              // Receives a measurement of every request and tool call
+
+	// Wrap every request sent through the client, see Middleware
+	Middleware       []Middleware
+	StreamMiddleware []StreamMiddleware
+	ToolMiddleware   []ToolMiddleware // Wraps the tools run for the client
+
+	usageMu   sync.Mutex
+	usage     UsageTotals
+	reserveMu sync.Mutex // Makes checking the budgets and counting a request atomic
+	budgetsMu sync.Mutex
+	budgets   []*budgetState
+	budgetsOf []Budget // The Budgets the states were made for
+}
+
+// DefaultClient is used by LLM functions that were not given a *Client
+var DefaultClient = &Client{}
+
+// provider returns the configured provider or the OpenAI-compatible default
+func (c *Client) provider() Provider {
+	if c != nil && c.Provider != nil {
+		return c.Provider
+	}
+	return &OpenAIProvider{}
+}
+
+// model resolves the model to use for a request
+func (c *Client) model(options Options) string {
+	if options.Model != "" {
+		return options.Model
+	}
+	if c != nil && c.Model != "" {
+		return c.Model
+	}
+	return MODEL
+}
+
+type clientKey struct{}
+
+// WithClient stores the client on the context so helpers such as selectors
+// and judges talk to the same backend as the function using them
+func WithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFrom returns the client stored on the context by WithClient or
+// DefaultClient
+func ClientFrom(ctx context.Context) *Client {
+	if c, ok := ctx.Value(clientKey{}).(*Client); ok && c != nil {
+		return c
+	}
+	return DefaultClient
+}
+
+// OpenAIProvider talks to any OpenAI-compatible chat completions endpoint
+// such as OpenRouter, Groq or OpenAI itself
+type OpenAIProvider struct {
+	URL        string       // Defaults to DEFAULT_URL
+	APIKey     string       // Defaults to API_KEY
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+func (p *OpenAIProvider) url() string {
+	if p.URL != "" {
+		return p.URL
+	}
+	return DEFAULT_URL
+}
+
+func (p *OpenAIProvider) headers() map[string]string {
+	apiKey := p.APIKey
+	if apiKey == "" {
+		apiKey = API_KEY
+	}
+	return map[string]string{"Authorization": "Bearer " + apiKey}
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, req *Request) (*ResponseData, error) {
+	body := *req
+	body.Stream = false
+	body.Messages = withoutReasoningData(req.Messages)
+
+	resp, err := postJSON(ctx, p.HTTPClient, p.url(), p.headers(), &body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResponse ResponseData
+	if err := json.NewDecoder(resp.Body).Decode(&chatResponse); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	return &chatResponse, nil
+}
+
+func (p *OpenAIProvider) ChatStream(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error) {
+	chunks := make(chan *ResponseData)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(chunks)
+		defer close(errs)
+
+		body := *req
+		body.Stream = true
+		body.Messages = withoutReasoningData(req.Messages)
+		body.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+		resp, err := postJSON(ctx, p.HTTPClient, p.url(), p.headers(), &body)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		err = readSSE(resp.Body, func(event, data string) error {
+			if data == "[DONE]" {
+				return io.EOF
+			}
+			var chunk ResponseData
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				return fmt.Errorf("error decoding stream chunk: %w", err)
+			}
+			return send(ctx, chunks, &chunk)
+		})
+		if err != nil {
+			errs <- err
+		}
+	}()
+
+	return chunks, errs
+}
+
+// withoutReasoningData drops the data other providers keep on messages,
+// which the API doesn't know
+func withoutReasoningData(messages []Message) []Message {
+	for i := range messages {
+		if messages[i].ReasoningData != "" {
+			out := make([]Message, len(messages))
+			for j := range messages {
+				out[j] = cloneMessage(&messages[j])
+				out[j].ReasoningData = ""
+			}
+			return out
+		}
+	}
+	return messages
+}
+
+// postJSON sends body as JSON and returns the response if the status is 200
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) (*http.Response, error) {
+	// Convert the struct to JSON
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %w", err)
+	}
+
+	// Create a new HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Add headers
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	// Send the request
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	// Check for non-200 status codes
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(body))}
+	}
+
+	return resp, nil
+}
+
+// APIError is returned when a provider answers with a non-200 status code
+type APIError struct {
+	StatusCode int
+	Body       string // The raw error body, useful to tell errors apart
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("request failed with status code: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("request failed with status code: %d: %s", e.StatusCode, e.Body)
+}
+
+// readSSE reads a server-sent events stream and calls fn for every event.
+// Returning io.EOF from fn stops reading without an error.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		defer func() {
+			event, data = "", nil
+		}()
+		if len(data) == 0 {
+			return nil
+		}
+		return fn(event, strings.Join(data, "\n"))
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment, used by OpenRouter as a keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading streamed response: %w", err)
+	}
+
+	// Flush a final event without a trailing blank line
+	if err := dispatch(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// send delivers a chunk unless the context is done
+func send(ctx context.Context, chunks chan<- *ResponseData, chunk *ResponseData) error {
+	select {
+	case chunks <- chunk:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// toolArguments converts the JSON string arguments of a tool call to a raw
+// object, as expected by providers that don't use strings
+func toolArguments(arguments string) json.RawMessage {
+	if strings.TrimSpace(arguments) == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+// toolNames maps tool call IDs to the name of the called function, for
+// providers that reference tool results by name
+func toolNames(messages []Message) map[string]string {
+	names := make(map[string]string)
+	for i := range messages {
+		m := &messages[i]
+		for _, tc := range m.ToolCalls {
+			if tc != nil && tc.Function != nil {
+				names[tc.Id] = tc.Function.Name
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,461 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// collect reads a stream to the end and returns its reasoning and content
+func collect(t *testing.T, chunks <-chan *ResponseData, errs <-chan error) (reasoning, content string) {
+	t.Helper()
+	for chunk := range chunks {
+		for _, choice := range chunk.Choices {
+			if choice.Delta != nil {
+				reasoning += choice.Delta.Reasoning
+				content += choice.Delta.Content
+			}
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return reasoning, content
+}
+
+// sseServer answers every request with the given server-sent events
+func sseServer(t *testing.T, events ...string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// captured is the last request received by a test server
+type captured struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// decode decodes the body of the request into v
+func (c *captured) decode(t *testing.T, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(c.body, v); err != nil {
+		t.Fatalf("error decoding request %s: %v", c.body, err)
+	}
+}
+
+// replyServer answers every request with body, JSON or server-sent events
+// when body starts with "data:", and keeps the last request
+func replyServer(t *testing.T, body string) (*httptest.Server, *captured) {
+	last := &captured{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last.path = r.URL.RequestURI()
+		last.header = r.Header.Clone()
+		last.body, _ = io.ReadAll(r.Body)
+		if strings.HasPrefix(body, "data:") {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server, last
+}
+
+// events formats server-sent events
+func events(data ...string) string {
+	var b strings.Builder
+	for _, d := range data {
+		fmt.Fprintf(&b, "data: %s\n\n", d)
+	}
+	return b.String()
+}
+
+// weatherRequest is a request in the middle of a tool loop: the model asked
+// for the weather and gets the result
+func weatherRequest(model string) *Request {
+	temperature := 0.5
+	return &Request{
+		Model: model,
+		Messages: []Message{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Weather in Paris?"},
+			{Role: "assistant", ToolCalls: []*ToolCall{{
+				Id:       "call_1",
+				Type:     "function",
+				Function: &Function{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+			}}},
+			{Role: "tool", ToolCallID: "call_1", Content: "Sunny"},
+		},
+		Tools: []*Tool{{
+			Name:        "get_weather",
+			Type:        "function",
+			Description: "Gets the weather",
+			Function: &Function{Name: "get_weather", Parameters: &Parameters{
+				Type:       "object",
+				Properties: map[string]*Field{"city": {Type: "string"}},
+				Required:   []string{"city"},
+			}},
+		}},
+		ToolChoice:  "auto",
+		Temperature: &temperature,
+		MaxTokens:   2000,
+		Stop:        []string{"END"},
+	}
+}
+
+// toolCallOf returns the name and arguments of the only tool call of choice
+func toolCallOf(t *testing.T, toolCalls []*ToolCall) (name, arguments string) {
+	t.Helper()
+	if len(toolCalls) != 1 || toolCalls[0].Function == nil {
+		t.Fatalf("got tool calls %v, want one", toolCalls)
+	}
+	return toolCalls[0].Function.Name, toolCalls[0].Function.Arguments
+}
+
+func TestOpenAIChat(t *testing.T) {
+	server, last := replyServer(t, `{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","tool_calls":[{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Rome\"}"}}]}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	p := &OpenAIProvider{URL: server.URL + "/v1/chat/completions", APIKey: "sk-test"}
+
+	response, err := p.Chat(context.Background(), weatherRequest("gpt-4o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := last.header.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("got Authorization %q", got)
+	}
+	var sent map[string]interface{}
+	last.decode(t, &sent)
+	if sent["model"] != "gpt-4o" || sent["stream"] != false || sent["tool_choice"] != "auto" || sent["max_tokens"] != 2000.0 {
+		t.Errorf("got request %s", last.body)
+	}
+	if messages := sent["messages"].([]interface{}); len(messages) != 4 {
+		t.Errorf("got %d messages, want them as they are", len(messages))
+	}
+
+	name, arguments := toolCallOf(t, response.Choices[0].Message.ToolCalls)
+	if name != "get_weather" || arguments != `{"city":"Rome"}` || response.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("got %s(%s), finish reason %q", name, arguments, response.Choices[0].FinishReason)
+	}
+	if response.Usage.TotalTokens != 15 {
+		t.Errorf("got usage %v", response.Usage)
+	}
+}
+
+func TestOpenAIDropsReasoningData(t *testing.T) {
+	server, last := replyServer(t, `{"id":"1","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"}}]}`)
+	p := &OpenAIProvider{URL: server.URL}
+	req := weatherRequest("gpt-4o")
+	req.Messages[2].ReasoningData = `[{"type":"thinking","signature":"sig"}]`
+
+	if _, err := p.Chat(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(last.body), "reasoning_data") {
+		t.Errorf("got request %s, want no reasoning data", last.body)
+	}
+	if req.Messages[2].ReasoningData == "" {
+		t.Error("the messages of the request were changed")
+	}
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	server, last := replyServer(t, events(
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","reasoning":"Hmm."}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"Sunny"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}`,
+		`[DONE]`,
+	))
+	p := &OpenAIProvider{URL: server.URL}
+
+	chunks, errs := p.ChatStream(context.Background(), weatherRequest("gpt-4o"))
+	reasoning, content := collect(t, chunks, errs)
+	if reasoning != "Hmm." || content != "Sunny" {
+		t.Errorf("got (%q, %q)", reasoning, content)
+	}
+
+	var sent Request
+	last.decode(t, &sent)
+	if !sent.Stream || sent.StreamOptions == nil || !sent.StreamOptions.IncludeUsage {
+		t.Errorf("got request %s, want a stream with its usage", last.body)
+	}
+}
+
+func TestAnthropicChat(t *testing.T) {
+	server, last := replyServer(t, `{"id":"msg_1","model":"claude","stop_reason":"tool_use","usage":{"input_tokens":10,"output_tokens":5},"content":[
+		{"type":"thinking","thinking":"Rome next.","signature":"sig"},
+		{"type":"text","text":"Checking."},
+		{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Rome"}}]}`)
+	p := &AnthropicProvider{APIKey: "key", BaseURL: server.URL}
+	req := weatherRequest("claude")
+	req.Reasoning = &Reasoning{Effort: "high"}
+
+	response, err := p.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last.path != "/v1/messages" || last.header.Get("x-api-key") != "key" || last.header.Get("anthropic-version") == "" {
+		t.Errorf("got %s with headers %v", last.path, last.header)
+	}
+	var sent anthropicRequest
+	last.decode(t, &sent)
+	if sent.System != "Be brief." {
+		t.Errorf("got system %q", sent.System)
+	}
+	if len(sent.Messages) != 3 || sent.Messages[1].Content[0].Type != "tool_use" || sent.Messages[2].Role != "user" ||
+		sent.Messages[2].Content[0].Type != "tool_result" || sent.Messages[2].Content[0].ToolUseId != "call_1" {
+		t.Errorf("got messages %+v", sent.Messages)
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Description != "Gets the weather" || sent.ToolChoice["type"] != "auto" {
+		t.Errorf("got tools %+v, choice %v", sent.Tools, sent.ToolChoice)
+	}
+	if sent.Thinking == nil || sent.Thinking.Type != "enabled" || sent.Thinking.BudgetTokens != 1600 || sent.MaxTokens != 2000 {
+		t.Errorf("got thinking %+v with max_tokens %d, want a budget of 1600", sent.Thinking, sent.MaxTokens)
+	}
+	if sent.Temperature != nil {
+		t.Errorf("got temperature %v, the API rejects it with thinking", *sent.Temperature)
+	}
+
+	message := response.Choices[0].Message
+	name, arguments := toolCallOf(t, message.ToolCalls)
+	if message.Reasoning != "Rome next." || message.Content != "Checking." || name != "get_weather" || arguments != `{"city":"Rome"}` {
+		t.Errorf("got message %v", message)
+	}
+	if response.Choices[0].FinishReason != "tool_calls" || response.Usage.TotalTokens != 15 {
+		t.Errorf("got finish reason %q, usage %v", response.Choices[0].FinishReason, response.Usage)
+	}
+
+	// The thinking goes back with the result of the call, from the message
+	// alone: another provider sends it
+	req.Messages = append(req.Messages,
+		Message{Role: "assistant", Content: message.Content, ToolCalls: message.ToolCalls, ReasoningData: message.ReasoningData},
+		Message{Role: "tool", ToolCallID: "toolu_1", Content: "Cloudy"},
+	)
+	other := *p
+	if _, err := other.Chat(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	last.decode(t, &sent)
+	turn := sent.Messages[len(sent.Messages)-2]
+	if turn.Role != "assistant" || turn.Content[0].Type != "thinking" || turn.Content[0].Signature != "sig" {
+		t.Errorf("got last turn %+v, want it to start with the signed thinking", turn)
+	}
+}
+
+func TestAnthropicThinkingBudget(t *testing.T) {
+	p := &AnthropicProvider{}
+	tests := []struct {
+		reasoning         *Reasoning
+		maxTokens         int
+		budget, wantLimit int
+	}{
+		{&Reasoning{Effort: "low"}, 10000, 2000, 10000},
+		{&Reasoning{}, 10000, 5000, 10000},
+		{&Reasoning{MaxTokens: 8000}, 4000, 8000, 12000}, // Room is left for the answer
+		{&Reasoning{Effort: "low"}, 1000, 1024, 2024},    // The smallest budget allowed
+	}
+	for _, test := range tests {
+		out := p.toAnthropic(&Request{Model: "claude", MaxTokens: test.maxTokens, Reasoning: test.reasoning})
+		if out.Thinking.BudgetTokens != test.budget || out.MaxTokens != test.wantLimit {
+			t.Errorf("%+v with %d: got budget %d, max_tokens %d, want %d, %d",
+				test.reasoning, test.maxTokens, out.Thinking.BudgetTokens, out.MaxTokens, test.budget, test.wantLimit)
+		}
+	}
+	temperature := 0.5
+	if out := p.toAnthropic(&Request{Model: "claude", Temperature: &temperature}); out.Thinking != nil || out.Temperature == nil {
+		t.Errorf("got thinking %+v and temperature %v without reasoning", out.Thinking, out.Temperature)
+	}
+
+	// Data another provider left is not sent
+	out := p.toAnthropic(&Request{Model: "claude", Messages: []Message{{Role: "assistant", Content: "Hi", ReasoningData: `{"id":"rs_1"}`}}})
+	if len(out.Messages) != 1 || len(out.Messages[0].Content) != 1 || out.Messages[0].Content[0].Type != "text" {
+		t.Errorf("got messages %+v, want the text alone", out.Messages)
+	}
+}
+
+func TestAnthropicChatStream(t *testing.T) {
+	server, last := replyServer(t, events(
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude","usage":{"input_tokens":10}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Hmm."}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Checking."}}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"Rome\"}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":5}}`,
+		`{"type":"message_stop"}`,
+	))
+	p := &AnthropicProvider{BaseURL: server.URL}
+
+	chunks, errs := p.ChatStream(context.Background(), weatherRequest("claude"))
+	response, err := accumulate(chunks, errs, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent anthropicRequest
+	last.decode(t, &sent)
+	if !sent.Stream {
+		t.Errorf("got request %s, want a stream", last.body)
+	}
+	message := response.Choices[0].Message
+	name, arguments := toolCallOf(t, message.ToolCalls)
+	if message.Reasoning != "Hmm." || message.Content != "Checking." || name != "get_weather" || arguments != `{"city":"Rome"}` {
+		t.Errorf("got message %v", message)
+	}
+	if response.Choices[0].FinishReason != "tool_calls" || response.Usage.TotalTokens != 15 {
+		t.Errorf("got finish reason %q, usage %v", response.Choices[0].FinishReason, response.Usage)
+	}
+	if blocks := thinkingBlocks(message.ReasoningData); len(blocks) != 1 || blocks[0].Thinking != "Hmm." || blocks[0].Signature != "sig" {
+		t.Errorf("got reasoning data %q, want the signed thinking", message.ReasoningData)
+	}
+}
+
+func TestGeminiChat(t *testing.T) {
+	server, last := replyServer(t, `{"responseId":"r1","modelVersion":"gemini-2.5-flash","candidates":[{"index":0,"finishReason":"STOP","content":{"role":"model","parts":[
+		{"text":"Rome next.","thought":true},
+		{"functionCall":{"name":"get_weather","args":{"city":"Rome"}}}]}}],
+		"usageMetadata":{"promptTokenCount":10,"candidatesTokenCount":5,"thoughtsTokenCount":3,"totalTokenCount":18}}`)
+	p := &GeminiProvider{APIKey: "key", BaseURL: server.URL}
+	req := weatherRequest("gemini-2.5-flash")
+	req.Reasoning = &Reasoning{MaxTokens: 512}
+
+	response, err := p.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last.path != "/models/gemini-2.5-flash:generateContent" || last.header.Get("x-goog-api-key") != "key" {
+		t.Errorf("got %s with headers %v", last.path, last.header)
+	}
+	var sent geminiRequest
+	last.decode(t, &sent)
+	if sent.SystemInstruction == nil || sent.SystemInstruction.Parts[0].Text != "Be brief." {
+		t.Errorf("got system %+v", sent.SystemInstruction)
+	}
+	if len(sent.Contents) != 3 || sent.Contents[1].Role != "model" || sent.Contents[1].Parts[0].FunctionCall == nil ||
+		sent.Contents[2].Parts[0].FunctionResponse == nil || sent.Contents[2].Parts[0].FunctionResponse.Name != "get_weather" {
+		t.Errorf("got contents %s", last.body)
+	}
+	declaration := sent.Tools[0].FunctionDeclarations[0]
+	if declaration.Description != "Gets the weather" || declaration.Parameters.Type != "OBJECT" || declaration.Parameters.Properties["city"].Type != "STRING" {
+		t.Errorf("got declaration %+v", declaration)
+	}
+	config := sent.GenerationConfig
+	if config == nil || *config.Temperature != 0.5 || config.MaxOutputTokens != 2000 || config.ThinkingConfig == nil ||
+		!config.ThinkingConfig.IncludeThoughts || *config.ThinkingConfig.ThinkingBudget != 512 {
+		t.Errorf("got generation config %s", last.body)
+	}
+
+	message := response.Choices[0].Message
+	name, arguments := toolCallOf(t, message.ToolCalls)
+	if message.Reasoning != "Rome next." || name != "get_weather" || arguments != `{"city":"Rome"}` {
+		t.Errorf("got message %v", message)
+	}
+	if response.Choices[0].FinishReason != "tool_calls" || response.Usage.CompletionTokens != 8 {
+		t.Errorf("got finish reason %q, usage %v", response.Choices[0].FinishReason, response.Usage)
+	}
+}
+
+func TestGeminiChatStreamToolCalls(t *testing.T) {
+	server, last := replyServer(t, events(
+		`{"candidates":[{"content":{"parts":[{"functionCall":{"name":"get_weather","args":{"city":"Rome"}}}]}}]}`,
+		`{"candidates":[{"content":{"parts":[{"text":""}]},"finishReason":"STOP"}]}`,
+	))
+	p := &GeminiProvider{BaseURL: server.URL}
+
+	chunks, errs := p.ChatStream(context.Background(), weatherRequest("gemini-2.5-flash"))
+	response, err := accumulate(chunks, errs, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last.path != "/models/gemini-2.5-flash:streamGenerateContent?alt=sse" {
+		t.Errorf("got %s", last.path)
+	}
+	name, arguments := toolCallOf(t, response.Choices[0].Message.ToolCalls)
+	if name != "get_weather" || arguments != `{"city":"Rome"}` || response.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("got %s(%s), finish reason %q", name, arguments, response.Choices[0].FinishReason)
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	server, last := replyServer(t, `{"model":"qwen3","message":{"role":"assistant","content":"","thinking":"Rome next.",
+		"tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Rome"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":10,"eval_count":5}`)
+	p := &OllamaProvider{BaseURL: server.URL}
+	req := weatherRequest("qwen3")
+	req.Reasoning = &Reasoning{Effort: "low"}
+
+	response, err := p.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last.path != "/api/chat" {
+		t.Errorf("got %s", last.path)
+	}
+	var sent ollamaRequest
+	last.decode(t, &sent)
+	if sent.Stream || !sent.Think || sent.Options == nil || sent.Options.NumPredict != 2000 || len(sent.Options.Stop) != 1 {
+		t.Errorf("got request %s", last.body)
+	}
+	if len(sent.Messages) != 4 || string(sent.Messages[2].ToolCalls[0].Function.Arguments) != `{"city":"Paris"}` || sent.Messages[3].ToolName != "get_weather" {
+		t.Errorf("got messages %s", last.body)
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Function.Description != "Gets the weather" {
+		t.Errorf("got tools %+v", sent.Tools)
+	}
+
+	message := response.Choices[0].Message
+	name, arguments := toolCallOf(t, message.ToolCalls)
+	if message.Reasoning != "Rome next." || name != "get_weather" || arguments != `{"city":"Rome"}` {
+		t.Errorf("got message %v", message)
+	}
+	if response.Choices[0].FinishReason != "tool_calls" || response.Usage.TotalTokens != 15 {
+		t.Errorf("got finish reason %q, usage %v", response.Choices[0].FinishReason, response.Usage)
+	}
+}
+
+func TestGeminiStreamReasoning(t *testing.T) {
+	server := sseServer(t,
+		`{"candidates":[{"content":{"parts":[{"text":"Hmm.","thought":true}]}}]}`,
+		`{"candidates":[{"content":{"parts":[{"text":"Hello"}]},"finishReason":"STOP"}]}`,
+	)
+	p := &GeminiProvider{BaseURL: server.URL}
+	chunks, errs := p.ChatStream(context.Background(), &Request{Model: "gemini", Messages: []Message{{Role: "user", Content: "hi"}}})
+	reasoning, content := collect(t, chunks, errs)
+	if reasoning != "Hmm." || content != "Hello" {
+		t.Fatalf("got (%q, %q)", reasoning, content)
+	}
+}
+
+func TestOllamaStreamReasoning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model":"qwen3","message":{"role":"assistant","content":"","thinking":"Hmm."},"done":false}`)
+		fmt.Fprintln(w, `{"model":"qwen3","message":{"role":"assistant","content":"Hello"},"done":true,"done_reason":"stop"}`)
+	}))
+	defer server.Close()
+
+	p := &OllamaProvider{BaseURL: server.URL}
+	chunks, errs := p.ChatStream(context.Background(), &Request{Model: "qwen3", Messages: []Message{{Role: "user", Content: "hi"}}})
+	reasoning, content := collect(t, chunks, errs)
+	if reasoning != "Hmm." || content != "Hello" {
+		t.Fatalf("got (%q, %q)", reasoning, content)
+	}
+}
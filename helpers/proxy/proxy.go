@@ -0,0 +1,589 @@
+// Package proxy serves LLM functions and providers behind an OpenAI compatible
+// /v1/chat/completions endpoint, so existing OpenAI clients can use them:
+//
+//	srv := proxy.NewServer()
+//	srv.Register("summarize", summarize, "Be brief")
+//	srv.RegisterTool(llm.CreateTool("get_weather", "Gets the weather", getWeather))
+//	http.ListenAndServe(":8080", srv)
+//
+// A request whose model names a registered function runs that function on the
+// last user message. Any other model is passed through to the client's
+// provider, with the server tools added to the ones of the request. Only the
+// server tools are executed on the server; the calls to the client's own
+// tools are returned to the client as usual, even when a tool created with
+// llm.CreateTool has the same name. A client tool hides the server tool it
+// shares its name with.
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+// Server is an http.Handler serving the OpenAI chat completions API
+type Server struct {
+	Client       *llm.Client // Used for pass-through requests, llm.DefaultClient when nil
+	APIKey       string      // When set, requests must send it as a bearer token
+	MaxBodyBytes int64       // Limit of the request body, DefaultMaxBodyBytes when 0
+
+	mu        sync.RWMutex
+	functions map[string]*function
+	tools     []*llm.Tool
+}
+
+// DefaultMaxBodyBytes is the default limit of the request body
+const DefaultMaxBodyBytes = 10 << 20
+
+// function is a registered LLM function
+type function struct {
+	fn   func(string) string
+	opts []interface{}
+}
+
+// NewServer returns a server without functions or tools
+func NewServer() *Server {
+	return &Server{functions: make(map[string]*function)}
+}
+
+// Register serves fn as the model name. The optional parameters are the ones
+// of llm.LLM.
+func (s *Server) Register(name string, fn func(string) string, opts ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.functions[name] = &function{fn: fn, opts: opts}
+}
+
+// RegisterTool adds a tool created with llm.CreateTool to every pass-through
+// request
+func (s *Server) RegisterTool(tool *llm.Tool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tools = append(s.tools, tool)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.APIKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.APIKey)) != 1 {
+		writeError(w, http.StatusUnauthorized, "invalid_api_key", "Invalid API key")
+		return
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/v1/chat/completions", "/chat/completions":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+			return
+		}
+		s.completions(w, r)
+	case "/v1/models", "/models":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed")
+			return
+		}
+		s.models(w)
+	default:
+		writeError(w, http.StatusNotFound, "invalid_request_error", "Unknown endpoint: "+r.URL.Path)
+	}
+}
+
+// chatRequest is a request of the OpenAI API, whose tools have their
+// description inside the function
+type chatRequest struct {
+	llm.Request
+	Tools []openAITool `json:"tools,omitempty"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  *llm.Parameters `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+// completions handles /v1/chat/completions
+func (s *Server) completions(w http.ResponseWriter, r *http.Request) {
+	limit := s.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	var body chatRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", fmt.Sprintf("request body larger than %d bytes", limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("error decoding request: %v", err))
+		return
+	}
+	req := body.Request
+	for _, tool := range body.Tools {
+		req.Tools = append(req.Tools, &llm.Tool{
+			Name:        tool.Function.Name,
+			Type:        "function",
+			Description: tool.Function.Description,
+			Function:    &llm.Function{Name: tool.Function.Name, Parameters: tool.Function.Parameters},
+		})
+	}
+	if len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "messages must not be empty")
+		return
+	}
+
+	s.mu.RLock()
+	f := s.functions[req.Model]
+	serverTools := shadowed(s.tools, req.Tools)
+	s.mu.RUnlock()
+
+	ctx := r.Context()
+	var stream *eventStream
+	if req.Stream {
+		stream = newEventStream(w, req.Model)
+		ctx = llm.StreamTo(ctx,
+			func(delta string) { stream.send(&llm.Delta{Content: delta}) },
+			func(delta string) { stream.send(&llm.Delta{Reasoning: delta}) },
+		)
+	}
+
+	var result *llm.Result
+	var err error
+	if f != nil {
+		result, err = s.runFunction(ctx, f, &req)
+	} else {
+		result, err = s.passThrough(ctx, &req, serverTools)
+	}
+
+	if stream != nil {
+		if err != nil {
+			stream.fail(err)
+			return
+		}
+		stream.finish(response(&req, result, serverTools))
+		return
+	}
+	if err != nil {
+		writeCallError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, withIndexes(response(&req, result, serverTools)))
+}
+
+// runFunction runs a registered function on the last user message, with the
+// messages before it as the history
+func (s *Server) runFunction(ctx context.Context, f *function, req *llm.Request) (*llm.Result, error) {
+	last := len(req.Messages) - 1
+	if req.Messages[last].Role != "user" {
+		return nil, badRequest("the last message must be from the user")
+	}
+
+	// The function builds the prompt from the input, the history comes before it
+	messages := append([]llm.Message(nil), req.Messages[:last]...)
+	messages = append(messages, llm.Message{Role: "user", Content: f.fn(req.Messages[last].Content)})
+
+	opts := append([]interface{}(nil), f.opts...)
+	if s.Client != nil && !hasClient(opts) {
+		opts = append(opts, s.Client)
+	}
+	return llm.Chat(ctx, messages, opts...)
+}
+
+// passThrough sends the request to the provider with the server tools
+func (s *Server) passThrough(ctx context.Context, req *llm.Request, serverTools []*llm.Tool) (*llm.Result, error) {
+	options := llm.Options{
+		Model:       req.Model,
+		Top:         req.N,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+		Stop:        req.Stop,
+		ToolChoice:  req.ToolChoice,
+		RunTools:    []string{},
+
+		Models:              req.Models,
+		Route:               req.Route,
+		ProviderPreferences: req.Provider,
+		Reasoning:           req.Reasoning,
+	}
+	if req.IncludeReasoning != nil {
+		options.IncludeReasoning = *req.IncludeReasoning
+	}
+
+	// Only the server tools run here, whatever the client declared
+	tools := append([]*llm.Tool(nil), req.Tools...)
+	for _, tool := range serverTools {
+		tools = append(tools, tool)
+		options.RunTools = append(options.RunTools, toolName(tool))
+	}
+
+	opts := []interface{}{options, tools}
+	if s.Client != nil {
+		opts = append(opts, s.Client)
+	}
+	return llm.Chat(ctx, req.Messages, opts...)
+}
+
+// models handles /v1/models, listing the registered functions
+func (s *Server) models(w http.ResponseWriter) {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.functions))
+	for name := range s.functions {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+	list := struct {
+		Object string  `json:"object"`
+		Data   []model `json:"data"`
+	}{Object: "list", Data: []model{}}
+	for _, name := range names {
+		list.Data = append(list.Data, model{ID: name, Object: "model", OwnedBy: "llm"})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// response builds the completion returned to the client from the result of a
+// call, leaving out the calls to the server tools
+func response(req *llm.Request, result *llm.Result, serverTools []*llm.Tool) *llm.ResponseData {
+	out := &llm.ResponseData{
+		Id:      newID(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Usage: &llm.Usage{
+			PromptTokens:     int32(result.Usage.PromptTokens),
+			CompletionTokens: int32(result.Usage.CompletionTokens),
+			TotalTokens:      int32(result.Usage.TotalTokens),
+			Cost:             result.Usage.Cost,
+		},
+	}
+	if result.Response != nil {
+		if result.Response.Id != "" {
+			out.Id = result.Response.Id
+		}
+		out.SystemFingerprint = result.Response.SystemFingerprint
+		for _, choice := range result.Response.Choices {
+			if choice != nil && choice.Message != nil {
+				out.Choices = append(out.Choices, clientChoice(choice, serverTools))
+			}
+		}
+	}
+
+	// Functions return a single answer, selected among the candidates
+	if len(out.Choices) == 0 {
+		out.Choices = []*llm.Choice{{
+			Message:      &llm.Message{Role: "assistant", Content: result.Content, Reasoning: result.Reasoning},
+			FinishReason: "stop",
+		}}
+	}
+	for i, choice := range out.Choices {
+		choice.Index = int32(i)
+	}
+	return out
+}
+
+// indexedChoice is a choice that keeps its index in JSON when it is 0, as
+// OpenAI clients expect
+type indexedChoice struct {
+	Index int32 `json:"index"`
+	*llm.Choice
+}
+
+// withIndexes returns the response with the index of every choice
+func withIndexes(response *llm.ResponseData) interface{} {
+	choices := make([]indexedChoice, len(response.Choices))
+	for i, choice := range response.Choices {
+		choices[i] = indexedChoice{Index: choice.Index, Choice: choice}
+	}
+	return struct {
+		*llm.ResponseData
+		Choices []indexedChoice `json:"choices"`
+	}{response, choices}
+}
+
+// clientChoice copies a choice without the calls to the server tools
+func clientChoice(choice *llm.Choice, serverTools []*llm.Tool) *llm.Choice {
+	message := &llm.Message{
+		Role:      choice.Message.Role,
+		Content:   choice.Message.Content,
+		Reasoning: choice.Message.Reasoning,
+	}
+	if message.Role == "" {
+		message.Role = "assistant"
+	}
+	for _, toolCall := range choice.Message.ToolCalls {
+		if toolCall.Function != nil && hasTool(toolCall.Function.Name, serverTools) {
+			continue
+		}
+		message.ToolCalls = append(message.ToolCalls, toolCall)
+	}
+
+	finishReason := choice.FinishReason
+	if len(message.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	} else if finishReason == "" || finishReason == "tool_calls" {
+		finishReason = "stop"
+	}
+	return &llm.Choice{Message: message, FinishReason: finishReason}
+}
+
+// shadowed returns the server tools without the ones a client tool has the
+// name of
+func shadowed(serverTools, clientTools []*llm.Tool) []*llm.Tool {
+	var out []*llm.Tool
+	for _, tool := range serverTools {
+		if !hasTool(toolName(tool), clientTools) {
+			out = append(out, tool)
+		}
+	}
+	return out
+}
+
+// toolName returns the name a tool is called by
+func toolName(tool *llm.Tool) string {
+	if tool.Function != nil && tool.Function.Name != "" {
+		return tool.Function.Name
+	}
+	return tool.Name
+}
+
+// hasTool reports whether one of the tools is called name
+func hasTool(name string, tools []*llm.Tool) bool {
+	for _, tool := range tools {
+		if tool.Name == name || tool.Function != nil && tool.Function.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasClient(opts []interface{}) bool {
+	for _, opt := range opts {
+		if _, ok := opt.(*llm.Client); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// eventStream writes a streamed completion as server-sent events
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	id      string
+	model   string
+	created int64
+
+	mu      sync.Mutex
+	started bool
+}
+
+func newEventStream(w http.ResponseWriter, model string) *eventStream {
+	flusher, _ := w.(http.Flusher)
+	return &eventStream{w: w, flusher: flusher, id: newID(), model: model, created: time.Now().Unix()}
+}
+
+// start sends the headers before the first event
+func (e *eventStream) start() {
+	if e.started {
+		return
+	}
+	e.started = true
+	header := e.w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	e.w.WriteHeader(http.StatusOK)
+}
+
+// chunk wraps choices in a chat.completion.chunk
+func (e *eventStream) chunk(choices ...*llm.Choice) *llm.ResponseData {
+	return &llm.ResponseData{
+		Id:      e.id,
+		Object:  "chat.completion.chunk",
+		Created: e.created,
+		Model:   e.model,
+		Choices: choices,
+	}
+}
+
+// send streams a delta of the answer
+func (e *eventStream) send(delta *llm.Delta) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.start()
+	e.write(withIndexes(e.chunk(&llm.Choice{Delta: delta})))
+}
+
+// finish sends what was not streamed yet: the tool calls for the client, the
+// answer of a call that was not streamed, then the finish reason and usage
+func (e *eventStream) finish(response *llm.ResponseData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	streamed := e.started
+	e.start()
+
+	choice := response.Choices[0]
+	if !streamed && (choice.Message.Content != "" || choice.Message.Reasoning != "") {
+		e.write(withIndexes(e.chunk(&llm.Choice{Delta: &llm.Delta{
+			Content:   choice.Message.Content,
+			Reasoning: choice.Message.Reasoning,
+		}})))
+	}
+	if len(choice.Message.ToolCalls) > 0 {
+		e.writeToolCalls(choice.Message.ToolCalls)
+	}
+
+	last := e.chunk(&llm.Choice{Delta: &llm.Delta{}, FinishReason: choice.FinishReason})
+	last.Usage = response.Usage
+	e.write(withIndexes(last))
+	fmt.Fprint(e.w, "data: [DONE]\n\n")
+	e.flush()
+}
+
+// fail reports an error, as a JSON error before the stream started or as an
+// error event after
+func (e *eventStream) fail(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.started {
+		writeCallError(e.w, err)
+		return
+	}
+	_, body := errorBody(err)
+	e.write(body)
+	e.flush()
+}
+
+// writeToolCalls streams the tool calls for the client. Clients put them
+// together by index, which llm.ToolCall leaves out when it is 0.
+func (e *eventStream) writeToolCalls(toolCalls []*llm.ToolCall) {
+	type toolCallDelta struct {
+		Index    int           `json:"index"`
+		Id       string        `json:"id"`
+		Type     string        `json:"type"`
+		Function *llm.Function `json:"function"`
+	}
+	type choice struct {
+		Index int `json:"index"`
+		Delta struct {
+			ToolCalls []toolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+	}
+
+	var c choice
+	for i, toolCall := range toolCalls {
+		c.Delta.ToolCalls = append(c.Delta.ToolCalls, toolCallDelta{
+			Index:    i,
+			Id:       toolCall.Id,
+			Type:     "function",
+			Function: toolCall.Function,
+		})
+	}
+	e.write(struct {
+		*llm.ResponseData
+		Choices []choice `json:"choices"`
+	}{e.chunk(), []choice{c}})
+}
+
+func (e *eventStream) write(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(e.w, "data: %s\n\n", data)
+	e.flush()
+}
+
+func (e *eventStream) flush() {
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+}
+
+// badRequestError is an error caused by the request
+type badRequestError struct {
+	message string
+}
+
+func (e *badRequestError) Error() string {
+	return e.message
+}
+
+func badRequest(message string) error {
+	return &badRequestError{message: message}
+}
+
+// apiError is the error body of the OpenAI API
+type apiError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// errorBody converts the error of a call to a status code and an error body
+func errorBody(err error) (int, *apiError) {
+	status, kind := http.StatusInternalServerError, "server_error"
+
+	var badRequestErr *badRequestError
+	var budgetErr *llm.BudgetExceededError
+	var apiErr *llm.APIError
+	switch {
+	case errors.As(err, &badRequestErr):
+		status, kind = http.StatusBadRequest, "invalid_request_error"
+	case errors.As(err, &budgetErr):
+		status, kind = http.StatusTooManyRequests, "insufficient_quota"
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 400:
+		status, kind = apiErr.StatusCode, "upstream_error"
+	case errors.Is(err, context.DeadlineExceeded):
+		status, kind = http.StatusGatewayTimeout, "timeout"
+	}
+
+	body := &apiError{}
+	body.Error.Message = err.Error()
+	body.Error.Type = kind
+	return status, body
+}
+
+func writeCallError(w http.ResponseWriter, err error) {
+	status, body := errorBody(err)
+	writeJSON(w, status, body)
+}
+
+func writeError(w http.ResponseWriter, status int, kind, message string) {
+	body := &apiError{}
+	body.Error.Message = message
+	body.Error.Type = kind
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newID returns an id in the format of OpenAI completions
+func newID() string {
+	return fmt.Sprintf("chatcmpl-%x", time.Now().UnixNano())
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+func post(t *testing.T, srv http.Handler, apiKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	if apiKey != "" {
+		r.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestPassThroughKeepsToolsAndToolChoice(t *testing.T) {
+	provider := fake.New()
+	provider.On("").CallTool("get_weather", map[string]string{"city": "Paris"})
+	srv := NewServer()
+	srv.Client = &llm.Client{Provider: provider}
+
+	w := post(t, srv, "", `{
+		"model": "gpt-4o",
+		"messages": [{"role": "user", "content": "Weather in Paris?"}],
+		"tools": [{"type": "function", "function": {
+			"name": "get_weather",
+			"description": "Gets the weather of a city",
+			"parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}
+		}}],
+		"tool_choice": {"type": "function", "function": {"name": "get_weather"}}
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	requests := provider.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	if len(req.Tools) != 1 || req.Tools[0].Description != "Gets the weather of a city" {
+		t.Errorf("got tools %v, want get_weather with its description", req.Tools)
+	}
+	if req.ToolChoice != "get_weather" {
+		t.Errorf("got tool choice %q, want get_weather", req.ToolChoice)
+	}
+
+	var response struct {
+		Choices []map[string]json.RawMessage `json:"choices"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Choices) != 1 || string(response.Choices[0]["index"]) != "0" {
+		t.Errorf("got choices %s, want one with index 0", w.Body)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	provider := fake.New()
+	provider.On("").Respond("Hello")
+	srv := NewServer()
+	srv.Client = &llm.Client{Provider: provider}
+	srv.APIKey = "secret"
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Hi"}]}`
+	if w := post(t, srv, "wrong", body); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d with a wrong key, want 401", w.Code)
+	}
+	if w := post(t, srv, "secret", body); w.Code != http.StatusOK {
+		t.Errorf("got status %d with the key, want 200: %s", w.Code, w.Body)
+	}
+}
+
+var shutdowns atomic.Int32
+
+func shutdown(reason string) string {
+	shutdowns.Add(1)
+	return "shutting down: " + reason
+}
+
+func temperature(city string) string { return "20 degrees in " + city }
+
+// TestClientToolsAreNotRun checks that a tool declared by the client is handed
+// back even when a tool created with CreateTool has its name, while the
+// server tools still run
+func TestClientToolsAreNotRun(t *testing.T) {
+	llm.CreateTool("shutdown", "Shuts the server down", shutdown)
+	provider := fake.New()
+	provider.On("Shut down").CallTool("shutdown", map[string]string{"reason": "test"})
+	provider.On("Temperature").CallTool("temperature", map[string]string{"city": "Paris"}).Respond("It is 20 degrees.")
+	srv := NewServer()
+	srv.Client = &llm.Client{Provider: provider}
+	srv.RegisterTool(llm.CreateTool("temperature", "Temperature of a city", temperature))
+
+	w := post(t, srv, "", `{
+		"model": "gpt-4o",
+		"messages": [{"role": "user", "content": "Shut down"}],
+		"tools": [{"type": "function", "function": {"name": "shutdown", "parameters": {"type": "object"}}}]
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if shutdowns.Load() != 0 {
+		t.Error("the client tool ran on the server")
+	}
+	var response llm.ResponseData
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Choices) != 1 || len(response.Choices[0].Message.ToolCalls) != 1 ||
+		response.Choices[0].Message.ToolCalls[0].Function.Name != "shutdown" {
+		t.Errorf("got %s, want the call handed back to the client", w.Body)
+	}
+
+	w = post(t, srv, "", `{"model": "gpt-4o", "messages": [{"role": "user", "content": "Temperature"}]}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "It is 20 degrees.") {
+		t.Errorf("got status %d: %s, want the answer after the server tool", w.Code, w.Body)
+	}
+	if calls := provider.CallsTo("temperature"); len(calls) != 1 {
+		t.Errorf("got %d calls to the server tool, want 1", len(calls))
+	}
+}
+
+func TestPassThroughKeepsRouting(t *testing.T) {
+	provider := fake.New()
+	provider.On("").Respond("Hello")
+	srv := NewServer()
+	srv.Client = &llm.Client{Provider: provider}
+
+	w := post(t, srv, "", `{
+		"model": "gpt-4o",
+		"messages": [{"role": "user", "content": "Hi"}],
+		"models": ["gpt-4o-mini"],
+		"route": "fallback",
+		"provider": {"sort": "price"},
+		"include_reasoning": true,
+		"reasoning": {"effort": "low"}
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	req := provider.Requests()[0]
+	if len(req.Models) != 1 || req.Route != "fallback" || req.Provider == nil || req.Provider.Sort != "price" {
+		t.Errorf("got models %v, route %q, provider %v, want them passed through", req.Models, req.Route, req.Provider)
+	}
+	if req.IncludeReasoning == nil || !*req.IncludeReasoning || req.Reasoning == nil || req.Reasoning.Effort != "low" {
+		t.Errorf("got include reasoning %v, reasoning %v, want them passed through", req.IncludeReasoning, req.Reasoning)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	srv := NewServer()
+	srv.Client = &llm.Client{Provider: fake.New()}
+	srv.MaxBodyBytes = 64
+
+	body := `{"model": "gpt-4o", "messages": [{"role": "user", "content": "` + strings.Repeat("a", 100) + `"}]}`
+	if w := post(t, srv, "", body); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want 413", w.Code)
+	}
+}
@@ -0,0 +1,193 @@
+package llm
+
+import (
+	"strings"
+)
+
+// Reasoning configures reasoning models, sent as OpenRouter's `reasoning`
+// object. Set either Effort or MaxTokens.
+type Reasoning struct {
+	Effort    string `json:"effort,omitempty"`     // "low", "medium" or "high"
+	MaxTokens int    `json:"max_tokens,omitempty"` // Token budget for the reasoning
+	Exclude   bool   `json:"exclude,omitempty"`    // Reason without returning the reasoning
+}
+
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// thinkSplitter separates inline <think> blocks from the content as it
+// arrives, holding back text that could be the start of a tag.
+//
+// With headless set the content may also start with reasoning that only has
+// a closing tag, which some distilled models do. The start of the content is
+// then held back until a tag tells which one it is, or until the end.
+type thinkSplitter struct {
+	buf      string
+	inThink  bool
+	trimNext bool // Drop the whitespace following a think block
+	headless bool
+	decided  bool // A tag was seen, the start is known to be content or reasoning
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a
+// prefix of tag
+func partialSuffix(s, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// feed adds text and returns the content and reasoning that are known so far
+func (s *thinkSplitter) feed(text string) (content, reasoning string) {
+	s.buf += text
+	var c, r strings.Builder
+
+	if s.headless && !s.decided {
+		opening, closing := strings.Index(s.buf, thinkOpen), strings.Index(s.buf, thinkClose)
+		switch {
+		case closing >= 0 && (opening < 0 || closing < opening):
+			// Everything up to the closing tag was reasoning
+			r.WriteString(s.buf[:closing])
+			s.buf = s.buf[closing+len(thinkClose):]
+			s.trimNext = true
+		case opening < 0:
+			return "", ""
+		}
+		s.decided = true
+	}
+
+	for {
+		tag := thinkOpen
+		if s.inThink {
+			tag = thinkClose
+		}
+
+		// Emit up to the tag, or up to a possible partial tag at the end
+		i := strings.Index(s.buf, tag)
+		n := i
+		if i < 0 {
+			n = len(s.buf) - partialSuffix(s.buf, tag)
+		}
+		out := s.buf[:n]
+
+		if s.inThink {
+			r.WriteString(out)
+		} else {
+			if s.trimNext {
+				out = strings.TrimLeft(out, " \t\r\n")
+				s.trimNext = out == ""
+			}
+			c.WriteString(out)
+		}
+
+		if i < 0 {
+			s.buf = s.buf[n:]
+			return c.String(), r.String()
+		}
+		s.buf = s.buf[i+len(tag):]
+		s.trimNext = s.inThink
+		s.inThink = !s.inThink
+	}
+}
+
+// flush returns whatever was held back
+func (s *thinkSplitter) flush() (content, reasoning string) {
+	out := s.buf
+	s.buf = ""
+	if s.inThink {
+		return "", out
+	}
+	return out, ""
+}
+
+// headlessReasoning tells whether the content may start with reasoning that
+// only has a closing tag. It can't be told apart from an answer mentioning
+// the tag, so it is only looked for when reasoning was asked for.
+func headlessReasoning(options Options) bool {
+	return options.OnReasoning != nil || options.IncludeReasoning || options.Reasoning != nil
+}
+
+// splitThink separates the reasoning of a complete answer. With headless set
+// a closing tag without an opening one means the reasoning started the
+// answer, which some distilled models do.
+func splitThink(content string, headless bool) (reasoning, answer string) {
+	s := thinkSplitter{headless: headless}
+	answer, reasoning = s.feed(content)
+	c, r := s.flush()
+	return strings.TrimSpace(reasoning + r), answer + c
+}
+
+// stripThink moves inline think blocks of every choice to the reasoning
+func stripThink(response *ResponseData, headless bool) {
+	for _, choice := range response.Choices {
+		if choice == nil || choice.Message == nil {
+			continue
+		}
+		reasoning, answer := splitThink(choice.Message.Content, headless)
+		if reasoning == "" {
+			continue
+		}
+		choice.Message.Content = answer
+		if choice.Message.Reasoning != "" {
+			reasoning = choice.Message.Reasoning + "\n" + reasoning
+		}
+		choice.Message.Reasoning = reasoning
+	}
+}
+
+// splitThinkStream moves inline think blocks from the content of the deltas
+// to their reasoning, keeping one splitter per choice. With headless set a
+// closing tag without an opening one ends reasoning that started the content.
+func splitThinkStream(in <-chan *ResponseData, headless bool) <-chan *ResponseData {
+	out := make(chan *ResponseData)
+
+	go func() {
+		defer close(out)
+
+		splitters := make(map[int32]*thinkSplitter)
+		var last *ResponseData
+		for chunk := range in {
+			for _, choice := range chunk.Choices {
+				if choice == nil || choice.Delta == nil {
+					continue
+				}
+				s, ok := splitters[choice.Index]
+				if !ok {
+					s = &thinkSplitter{headless: headless}
+					splitters[choice.Index] = s
+				}
+				content, reasoning := s.feed(choice.Delta.Content)
+				choice.Delta.Content = content
+				choice.Delta.Reasoning += reasoning
+			}
+			last = chunk
+			out <- chunk
+		}
+
+		// Send anything held back as a last chunk
+		final := &ResponseData{}
+		if last != nil {
+			final.Id, final.Object, final.Model = last.Id, last.Object, last.Model
+		}
+		for index, s := range splitters {
+			content, reasoning := s.flush()
+			if content == "" && reasoning == "" {
+				continue
+			}
+			final.Choices = append(final.Choices, &Choice{
+				Index: index,
+				Delta: &Delta{Content: content, Reasoning: reasoning},
+			})
+		}
+		if len(final.Choices) > 0 {
+			out <- final
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// feedAll splits text in chunks of size bytes and feeds them one at a time
+func feedAll(s *thinkSplitter, text string, size int) (content, reasoning string) {
+	var c, r strings.Builder
+	for len(text) > 0 {
+		n := min(size, len(text))
+		content, reasoning := s.feed(text[:n])
+		c.WriteString(content)
+		r.WriteString(reasoning)
+		text = text[n:]
+	}
+	content, reasoning = s.flush()
+	return c.String() + content, r.String() + reasoning
+}
+
+func TestThinkSplitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		headless  bool
+		text      string
+		content   string
+		reasoning string
+	}{
+		{"plain", false, "just an answer", "just an answer", ""},
+		{"block", false, "<think>hmm</think>\n\nanswer", "answer", "hmm"},
+		{"block headless", true, "<think>hmm</think>\n\nanswer", "answer", "hmm"},
+		{"text before block", true, "a <think>hmm</think> b", "a b", "hmm"},
+		{"headless", true, "reasoning…</think>\n\nanswer", "answer", "reasoning…"},
+		{"headless without tag", true, "just an answer", "just an answer", ""},
+	}
+	for _, tt := range tests {
+		for _, size := range []int{1, 3, 7, 1000} {
+			s := &thinkSplitter{headless: tt.headless}
+			content, reasoning := feedAll(s, tt.text, size)
+			if content != tt.content || reasoning != tt.reasoning {
+				t.Errorf("%s, chunks of %d: got (%q, %q), want (%q, %q)",
+					tt.name, size, content, reasoning, tt.content, tt.reasoning)
+			}
+		}
+	}
+}
+
+func TestSplitThinkHeadless(t *testing.T) {
+	tests := []struct {
+		text      string
+		headless  bool
+		reasoning string
+		answer    string
+	}{
+		{"step one\nstep two</think>\n\nThe answer", true, "step one\nstep two", "The answer"},
+		{"Close it with </think> like this", false, "", "Close it with </think> like this"},
+		{"<think>step one</think>\n\nThe answer", false, "step one", "The answer"},
+	}
+	for _, tt := range tests {
+		reasoning, answer := splitThink(tt.text, tt.headless)
+		if reasoning != tt.reasoning || answer != tt.answer {
+			t.Errorf("%q: got (%q, %q), want (%q, %q)", tt.text, reasoning, answer, tt.reasoning, tt.answer)
+		}
+	}
+}
+
+func TestSplitThinkStreamHeadless(t *testing.T) {
+	in := make(chan *ResponseData)
+	go func() {
+		defer close(in)
+		for _, delta := range []string{"thinking ", "hard</th", "ink>\n", "answer"} {
+			in <- &ResponseData{Choices: []*Choice{{Delta: &Delta{Content: delta}}}}
+		}
+	}()
+
+	var content, reasoning strings.Builder
+	for chunk := range splitThinkStream(in, true) {
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			reasoning.WriteString(choice.Delta.Reasoning)
+		}
+	}
+	if content.String() != "answer" || reasoning.String() != "thinking hard" {
+		t.Fatalf("got (%q, %q)", content.String(), reasoning.String())
+	}
+}
+
+// streamProvider streams its deltas as the content of one choice, followed
+// by its usage when set
+type streamProvider struct {
+	deltas []string
+	usage  *Usage
+}
+
+func (p *streamProvider) Chat(ctx context.Context, req *Request) (*ResponseData, error) {
+	return nil, errors.New("not streamed")
+}
+
+func (p *streamProvider) ChatStream(ctx context.Context, req *Request) (<-chan *ResponseData, <-chan error) {
+	chunks := make(chan *ResponseData)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		defer close(errs)
+		for _, delta := range p.deltas {
+			chunks <- &ResponseData{Choices: []*Choice{{Delta: &Delta{Content: delta}}}}
+		}
+		if p.usage != nil {
+			chunks <- &ResponseData{Usage: p.usage}
+		}
+	}()
+	return chunks, errs
+}
+
+func TestStreamedHeadlessReasoning(t *testing.T) {
+	client := &Client{Provider: &streamProvider{deltas: []string{"let me ", "think</think>", "\n\nanswer"}}}
+
+	// Content only: no reasoning was asked for, the closing tag is content
+	var streamed strings.Builder
+	f := LLMWithResult(func(s string) string { return s }, client, Options{
+		OnContent: func(delta string) { streamed.WriteString(delta) },
+	})
+	result, err := f(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "let me think</think>\n\nanswer" || result.Reasoning != "" {
+		t.Fatalf("got (%q, %q)", result.Content, result.Reasoning)
+	}
+
+	// Listening to the reasoning: the deltas are split too
+	streamed.Reset()
+	var reasoning strings.Builder
+	f = LLMWithResult(func(s string) string { return s }, client, Options{
+		OnContent:   func(delta string) { streamed.WriteString(delta) },
+		OnReasoning: func(delta string) { reasoning.WriteString(delta) },
+	})
+	result, err = f(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "answer" || streamed.String() != "answer" || reasoning.String() != "let me think" {
+		t.Fatalf("got (%q, %q, %q)", result.Content, streamed.String(), reasoning.String())
+	}
+}
@@ -0,0 +1,299 @@
+// Package sqlitestore keeps conversations in a SQLite database
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/proto"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS conversations (
+	id         TEXT PRIMARY KEY,
+	system     TEXT NOT NULL,
+	model      TEXT NOT NULL,
+	tools      TEXT NOT NULL,
+	usage      TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+	position        INTEGER NOT NULL,
+	role            TEXT NOT NULL,
+	content         TEXT NOT NULL,
+	message         BLOB,
+	model           TEXT NOT NULL,
+	usage           TEXT,
+	created_at      INTEGER NOT NULL,
+	PRIMARY KEY (conversation_id, position)
+);
+`
+
+// Store is a llm.ConversationStore backed by SQLite. Messages are kept one per
+// row in their protobuf encoding, with their role and content in their own
+// columns for searching.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens or creates the database at path, which can be a file name or a
+// DSN with its own parameters like "file:chats.db?cache=shared"
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", dsn(path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	store, err := New(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// New uses an open database, creating the tables if needed. SQLite enables
+// foreign keys per connection: New enables them on the connection it uses,
+// open the database with _foreign_keys=on to check them on the other
+// connections of a pool. Deleting a conversation doesn't need them.
+func New(db *sql.DB) (*Store, error) {
+	if _, err := db.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+		return nil, fmt.Errorf("error enabling foreign keys: %w", err)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("error creating tables: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Save(ctx context.Context, record *llm.ConversationRecord) error {
+	tools, err := json.Marshal(record.Tools)
+	if err != nil {
+		return fmt.Errorf("error encoding tools: %w", err)
+	}
+	usage, err := json.Marshal(record.Usage)
+	if err != nil {
+		return fmt.Errorf("error encoding usage: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO conversations (id, system, model, tools, usage, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			system = excluded.system,
+			model = excluded.model,
+			tools = excluded.tools,
+			usage = excluded.usage,
+			created_at = excluded.created_at,
+			updated_at = excluded.updated_at`,
+		record.ID, record.System, record.Model, string(tools), string(usage),
+		record.CreatedAt.UnixNano(), record.UpdatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("error saving conversation: %w", err)
+	}
+
+	// Histories can be rewound, so the messages are replaced rather than appended
+	if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE conversation_id = ?`, record.ID); err != nil {
+		return fmt.Errorf("error saving messages: %w", err)
+	}
+	insert, err := tx.PrepareContext(ctx, `
+		INSERT INTO messages (conversation_id, position, role, content, message, model, usage, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("error saving messages: %w", err)
+	}
+	defer insert.Close()
+
+	for i := range record.Messages {
+		m := &record.Messages[i]
+		if m.Message == nil {
+			continue
+		}
+		message, err := proto.Marshal(m.Message)
+		if err != nil {
+			return fmt.Errorf("error encoding message: %w", err)
+		}
+		var usage sql.NullString
+		if m.Usage != nil {
+			data, err := json.Marshal(m.Usage)
+			if err != nil {
+				return fmt.Errorf("error encoding usage: %w", err)
+			}
+			usage = sql.NullString{String: string(data), Valid: true}
+		}
+		_, err = insert.ExecContext(ctx, record.ID, i, m.Message.Role, m.Message.Content,
+			message, m.Model, usage, m.CreatedAt.UnixNano())
+		if err != nil {
+			return fmt.Errorf("error saving messages: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error saving conversation: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Load(ctx context.Context, id string) (*llm.ConversationRecord, error) {
+	record := &llm.ConversationRecord{ID: id, Messages: []llm.StoredMessage{}}
+	var tools, usage string
+	var createdAt, updatedAt int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT system, model, tools, usage, created_at, updated_at
+		FROM conversations WHERE id = ?`, id).
+		Scan(&record.System, &record.Model, &tools, &usage, &createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, llm.ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading conversation: %w", err)
+	}
+	if err := json.Unmarshal([]byte(tools), &record.Tools); err != nil {
+		return nil, fmt.Errorf("error decoding tools: %w", err)
+	}
+	if err := json.Unmarshal([]byte(usage), &record.Usage); err != nil {
+		return nil, fmt.Errorf("error decoding usage: %w", err)
+	}
+	record.CreatedAt = time.Unix(0, createdAt)
+	record.UpdatedAt = time.Unix(0, updatedAt)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT message, model, usage, created_at
+		FROM messages WHERE conversation_id = ? ORDER BY position`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error loading messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message []byte
+		var usage sql.NullString
+		var createdAt int64
+		m := llm.StoredMessage{Message: &llm.Message{}}
+		if err := rows.Scan(&message, &m.Model, &usage, &createdAt); err != nil {
+			return nil, fmt.Errorf("error loading messages: %w", err)
+		}
+		if err := proto.Unmarshal(message, m.Message); err != nil {
+			return nil, fmt.Errorf("error decoding message: %w", err)
+		}
+		if usage.Valid {
+			m.Usage = &llm.UsageTotals{}
+			if err := json.Unmarshal([]byte(usage.String), m.Usage); err != nil {
+				return nil, fmt.Errorf("error decoding usage: %w", err)
+			}
+		}
+		m.CreatedAt = time.Unix(0, createdAt)
+		record.Messages = append(record.Messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error loading messages: %w", err)
+	}
+	return record, nil
+}
+
+func (s *Store) List(ctx context.Context) ([]llm.ConversationSummary, error) {
+	return s.summaries(ctx, "", nil)
+}
+
+// Delete removes a conversation and its messages in one transaction, without
+// relying on the cascade of the foreign key
+func (s *Store) Delete(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE conversation_id = ?`, id); err != nil {
+		return fmt.Errorf("error deleting messages: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM conversations WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting conversation: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return llm.ErrConversationNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error deleting conversation: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Search(ctx context.Context, query string) ([]llm.ConversationSummary, error) {
+	if query == "" {
+		return s.List(ctx)
+	}
+	pattern := "%" + escapeLike(query) + "%"
+	return s.summaries(ctx, `
+		WHERE c.id LIKE ? ESCAPE '\' OR c.system LIKE ? ESCAPE '\' OR EXISTS (
+			SELECT 1 FROM messages m
+			WHERE m.conversation_id = c.id AND m.content LIKE ? ESCAPE '\'
+		)`, []interface{}{pattern, pattern, pattern})
+}
+
+// summaries lists the conversations matching where, most recent first
+func (s *Store) summaries(ctx context.Context, where string, args []interface{}) ([]llm.ConversationSummary, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT c.id, c.created_at, c.updated_at,
+			(SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id),
+			COALESCE((SELECT m.content FROM messages m
+				WHERE m.conversation_id = c.id AND m.role = 'user'
+				ORDER BY m.position LIMIT 1), '')
+		FROM conversations c`+where+`
+		ORDER BY c.updated_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []llm.ConversationSummary
+	for rows.Next() {
+		var summary llm.ConversationSummary
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&summary.ID, &createdAt, &updatedAt, &summary.Messages, &summary.Preview); err != nil {
+			return nil, fmt.Errorf("error listing conversations: %w", err)
+		}
+		summary.CreatedAt = time.Unix(0, createdAt)
+		summary.UpdatedAt = time.Unix(0, updatedAt)
+		summaries = append(summaries, summary)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing conversations: %w", err)
+	}
+	return summaries, nil
+}
+
+// dsn adds the parameters the store needs to the ones already in path
+func dsn(path string) string {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "_foreign_keys=on&_busy_timeout=5000"
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
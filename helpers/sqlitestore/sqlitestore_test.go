@@ -0,0 +1,67 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+func TestDSN(t *testing.T) {
+	tests := map[string]string{
+		"chats.db":                   "chats.db?_foreign_keys=on&_busy_timeout=5000",
+		"file:chats.db?cache=shared": "file:chats.db?cache=shared&_foreign_keys=on&_busy_timeout=5000",
+	}
+	for path, want := range tests {
+		if got := dsn(path); got != want {
+			t.Errorf("dsn(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestDeleteRemovesMessages(t *testing.T) {
+	// A database opened without _foreign_keys
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "chats.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	store, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	record := &llm.ConversationRecord{
+		ID:        "c1",
+		Messages:  []llm.StoredMessage{{Message: &llm.Message{Role: "user", Content: "Hello"}}},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := store.Save(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	// Like another connection of a pool, without the cascade
+	if _, err := db.Exec(`PRAGMA foreign_keys = OFF`); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&messages); err != nil {
+		t.Fatal(err)
+	}
+	if messages != 0 {
+		t.Errorf("got %d messages left, want 0", messages)
+	}
+	if err := store.Delete(ctx, "c1"); err != llm.ErrConversationNotFound {
+		t.Errorf("got %v deleting it again, want ErrConversationNotFound", err)
+	}
+}
@@ -0,0 +1,344 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrConversationNotFound is returned when a store has no conversation with
+// the requested ID
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// MessageMeta holds what is known about a message besides its content
+type MessageMeta struct {
+	CreatedAt time.Time    `json:"created_at"`
+	Model     string       `json:"model,omitempty"` // The model that wrote the message
+	Usage     *UsageTotals `json:"usage,omitempty"` // Usage of the turn, on final answers
+}
+
+// StoredMessage is a message of a conversation with its metadata
+type StoredMessage struct {
+	Message *Message `json:"message"`
+	MessageMeta
+}
+
+// ConversationRecord is the stored form of a conversation. Tools are kept by
+// their schema and run through the functions registered by CreateTool when
+// the conversation is loaded again.
+type ConversationRecord struct {
+	ID        string          `json:"id"`
+	System    string          `json:"system,omitempty"`
+	Model     string          `json:"model,omitempty"`
+	Tools     []*Tool         `json:"tools,omitempty"`
+	Messages  []StoredMessage `json:"messages"`
+	Usage     UsageTotals     `json:"usage"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// ConversationSummary describes a stored conversation without its messages
+type ConversationSummary struct {
+	ID        string    `json:"id"`
+	Preview   string    `json:"preview"` // The first user message
+	Messages  int       `json:"messages"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConversationStore persists conversations. List and Search return the most
+// recently updated conversations first.
+type ConversationStore interface {
+	Save(ctx context.Context, record *ConversationRecord) error
+	Load(ctx context.Context, id string) (*ConversationRecord, error)
+	List(ctx context.Context) ([]ConversationSummary, error)
+	Delete(ctx context.Context, id string) error
+	// Search returns the conversations whose ID, system or message content
+	// contains query, ignoring case
+	Search(ctx context.Context, query string) ([]ConversationSummary, error)
+}
+
+// Summary describes the record
+func (r *ConversationRecord) Summary() ConversationSummary {
+	summary := ConversationSummary{
+		ID:        r.ID,
+		Messages:  len(r.Messages),
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+	for _, m := range r.Messages {
+		if m.Message != nil && m.Message.Role == "user" {
+			summary.Preview = m.Message.Content
+			break
+		}
+	}
+	return summary
+}
+
+// Matches reports whether the ID, the system or a message contains query,
+// ignoring case
+func (r *ConversationRecord) Matches(query string) bool {
+	query = strings.ToLower(query)
+	if strings.Contains(strings.ToLower(r.ID), query) || strings.Contains(strings.ToLower(r.System), query) {
+		return true
+	}
+	for _, m := range r.Messages {
+		if m.Message != nil && strings.Contains(strings.ToLower(m.Message.Content), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortSummaries orders summaries by most recent update
+func sortSummaries(summaries []ConversationSummary) {
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+}
+
+// MemoryStore keeps conversations in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string][]byte
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string][]byte)}
+}
+
+// Records are kept serialized so callers can't change them behind the store's back
+func (s *MemoryStore) Save(ctx context.Context, record *ConversationRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding conversation: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.ID] = data
+	return nil
+}
+
+func (s *MemoryStore) Load(ctx context.Context, id string) (*ConversationRecord, error) {
+	s.mu.Lock()
+	data, ok := s.records[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+
+	var record ConversationRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("error decoding conversation: %w", err)
+	}
+	return &record, nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]ConversationSummary, error) {
+	return s.Search(ctx, "")
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[id]; !ok {
+		return ErrConversationNotFound
+	}
+	delete(s.records, id)
+	return nil
+}
+
+func (s *MemoryStore) Search(ctx context.Context, query string) ([]ConversationSummary, error) {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.records))
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	var summaries []ConversationSummary
+	for _, id := range ids {
+		record, err := s.Load(ctx, id)
+		if errors.Is(err, ErrConversationNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if query == "" || record.Matches(query) {
+			summaries = append(summaries, record.Summary())
+		}
+	}
+	sortSummaries(summaries)
+	return summaries, nil
+}
+
+// FileStore keeps each conversation in its own JSONL file in Dir. The first
+// line holds the conversation and every following line one message.
+type FileStore struct {
+	Dir string
+}
+
+// fileHeader is the first line of a conversation file
+type fileHeader struct {
+	ID        string      `json:"id"`
+	System    string      `json:"system,omitempty"`
+	Model     string      `json:"model,omitempty"`
+	Tools     []*Tool     `json:"tools,omitempty"`
+	Usage     UsageTotals `json:"usage"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// path returns the file of a conversation, refusing IDs that would escape Dir
+func (s *FileStore) path(id string) (string, error) {
+	if !validID.MatchString(id) || strings.Trim(id, ".") == "" {
+		return "", fmt.Errorf("invalid conversation id: %q", id)
+	}
+	return filepath.Join(s.Dir, id+".jsonl"), nil
+}
+
+func (s *FileStore) Save(ctx context.Context, record *ConversationRecord) error {
+	path, err := s.path(record.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return fmt.Errorf("error creating store directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves half a conversation
+	tmp, err := os.CreateTemp(s.Dir, record.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating conversation file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	err = enc.Encode(&fileHeader{
+		ID:        record.ID,
+		System:    record.System,
+		Model:     record.Model,
+		Tools:     record.Tools,
+		Usage:     record.Usage,
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	})
+	for i := 0; err == nil && i < len(record.Messages); i++ {
+		err = enc.Encode(&record.Messages[i])
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing conversation file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error writing conversation file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Load(ctx context.Context, id string) (*ConversationRecord, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.load(path)
+}
+
+func (s *FileStore) load(path string) (*ConversationRecord, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening conversation file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var header fileHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("error decoding conversation file %s: %w", path, err)
+	}
+
+	record := &ConversationRecord{
+		ID:        header.ID,
+		System:    header.System,
+		Model:     header.Model,
+		Tools:     header.Tools,
+		Usage:     header.Usage,
+		CreatedAt: header.CreatedAt,
+		UpdatedAt: header.UpdatedAt,
+		Messages:  []StoredMessage{},
+	}
+	for dec.More() {
+		var m StoredMessage
+		if err := dec.Decode(&m); err != nil {
+			return nil, fmt.Errorf("error decoding conversation file %s: %w", path, err)
+		}
+		record.Messages = append(record.Messages, m)
+	}
+	return record, nil
+}
+
+func (s *FileStore) List(ctx context.Context) ([]ConversationSummary, error) {
+	return s.Search(ctx, "")
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrConversationNotFound
+	}
+	return err
+}
+
+func (s *FileStore) Search(ctx context.Context, query string) ([]ConversationSummary, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []ConversationSummary
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		record, err := s.load(path)
+		if errors.Is(err, ErrConversationNotFound) {
+			// Deleted while listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if query == "" || record.Matches(query) {
+			summaries = append(summaries, record.Summary())
+		}
+	}
+	sortSummaries(summaries)
+	return summaries, nil
+}
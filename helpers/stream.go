@@ -0,0 +1,146 @@
+package llm
+
+import (
+	"context"
+	"strings"
+)
+
+type streamKey struct{}
+
+// streamCallbacks holds the callbacks set by StreamTo
+type streamCallbacks struct {
+	onContent   func(string)
+	onReasoning func(string)
+}
+
+// StreamTo streams the answers of the LLM functions and conversations called
+// with the returned context to onContent and onReasoning, like
+// Options.OnContent and Options.OnReasoning but for a single call. Either
+// may be nil.
+func StreamTo(ctx context.Context, onContent, onReasoning func(delta string)) context.Context {
+	return context.WithValue(ctx, streamKey{}, &streamCallbacks{onContent: onContent, onReasoning: onReasoning})
+}
+
+// streamOptions adds the callbacks of StreamTo to the options
+func streamOptions(ctx context.Context, options Options) Options {
+	callbacks, ok := ctx.Value(streamKey{}).(*streamCallbacks)
+	if !ok {
+		return options
+	}
+	if callbacks.onContent != nil {
+		options.OnContent = callbacks.onContent
+	}
+	if callbacks.onReasoning != nil {
+		options.OnReasoning = callbacks.onReasoning
+	}
+	return options
+}
+
+// accumulate collects a stream into a complete response, calling the
+// streaming callbacks of the options with the deltas of the first choice
+func accumulate(chunks <-chan *ResponseData, errs <-chan error, options Options) (*ResponseData, error) {
+	response := &ResponseData{Object: "chat.completion"}
+	choices := make(map[int32]*Choice)
+	content := make(map[int32]*strings.Builder)
+	reasoning := make(map[int32]*strings.Builder)
+
+	for chunk := range chunks {
+		if chunk.Id != "" {
+			response.Id = chunk.Id
+		}
+		if chunk.Model != "" {
+			response.Model = chunk.Model
+		}
+		if chunk.Created != 0 {
+			response.Created = chunk.Created
+		}
+		if chunk.SystemFingerprint != "" {
+			response.SystemFingerprint = chunk.SystemFingerprint
+		}
+		if usage := responseUsage(chunk); usage != nil {
+			response.Usage = usage
+		}
+
+		for _, c := range chunk.Choices {
+			if c == nil {
+				continue
+			}
+			choice, ok := choices[c.Index]
+			if !ok {
+				choice = &Choice{Index: c.Index, Message: &Message{Role: "assistant"}}
+				choices[c.Index] = choice
+				response.Choices = append(response.Choices, choice)
+				content[c.Index] = &strings.Builder{}
+				reasoning[c.Index] = &strings.Builder{}
+			}
+			if c.FinishReason != "" {
+				choice.FinishReason = c.FinishReason
+			}
+
+			delta := c.Delta
+			if delta == nil {
+				continue
+			}
+			content[c.Index].WriteString(delta.Content)
+			reasoning[c.Index].WriteString(delta.Reasoning)
+			choice.Message.ReasoningData += delta.ReasoningData
+			mergeToolCalls(choice.Message, delta.ToolCalls)
+
+			// Only the first choice is streamed to the callbacks
+			if c.Index != 0 {
+				continue
+			}
+			if delta.Reasoning != "" && options.OnReasoning != nil {
+				options.OnReasoning(delta.Reasoning)
+			}
+			if delta.Content != "" && options.OnContent != nil {
+				options.OnContent(delta.Content)
+			}
+		}
+	}
+
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	for index, choice := range choices {
+		choice.Message.Content = content[index].String()
+		choice.Message.Reasoning = reasoning[index].String()
+	}
+	return response, nil
+}
+
+// mergeToolCalls adds streamed tool call fragments to a message. The first
+// fragment of a call carries its ID and name, the next ones more arguments.
+func mergeToolCalls(message *Message, fragments []*ToolCall) {
+	for _, fragment := range fragments {
+		if fragment == nil {
+			continue
+		}
+
+		var call *ToolCall
+		for _, tc := range message.ToolCalls {
+			if tc.Index == fragment.Index {
+				call = tc
+				break
+			}
+		}
+		if call == nil {
+			call = &ToolCall{Index: fragment.Index, Type: "function", Function: &Function{}}
+			message.ToolCalls = append(message.ToolCalls, call)
+		}
+
+		if fragment.Id != "" {
+			call.Id = fragment.Id
+		}
+		if fragment.Type != "" {
+			call.Type = fragment.Type
+		}
+		if fragment.Function != nil {
+			if fragment.Function.Name != "" {
+				call.Function.Name = fragment.Function.Name
+			}
+			call.Function.Arguments += fragment.Function.Arguments
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Command studio serves the studio for the calls recorded in a directory by
+// llm.FileInvocationStore
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/studio"
+)
+
+func main() {
+	dir := flag.String("dir", ".lineage", "directory of the recorded calls")
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	flag.Parse()
+
+	store := &llm.FileInvocationStore{Dir: *dir}
+	fmt.Printf("Serving %s on http://%s\n", *dir, *addr)
+	log.Fatal(http.ListenAndServe(*addr, studio.New(store)))
+}
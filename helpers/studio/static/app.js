@@ -0,0 +1,257 @@
+// Single page UI of the studio, routed on the location hash:
+//   #/                         functions
+//   #/f/<name>                 versions, usage and invocations of a function
+//   #/f/<name>/diff/<a>/<b>    diff between two versions
+//   #/i/<function>/<id>        an invocation with its transcript
+"use strict";
+
+const app = document.getElementById("app");
+const crumbs = document.getElementById("crumbs");
+const colors = ["#3458d4", "#2f9e59", "#c77d12", "#b83280", "#0f8b8d", "#7a5af8", "#d9480f"];
+
+async function api(path) {
+  const response = await fetch(path);
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+function esc(value) {
+  return String(value ?? "").replace(/[&<>"']/g, (c) => ({ "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;" })[c]);
+}
+
+const enc = encodeURIComponent;
+const num = (n) => (n || 0).toLocaleString();
+const cost = (c) => "$" + (c || 0).toFixed(4);
+const ms = (ns) => ((ns || 0) / 1e6).toFixed(0) + " ms";
+const when = (t) => (t && !t.startsWith("0001") ? new Date(t).toLocaleString() : "—");
+const short = (v) => (v || "").slice(0, 8);
+
+function setCrumbs(...parts) {
+  crumbs.innerHTML = parts.map(([label, href]) => (href ? `<a href="${href}">${esc(label)}</a>` : esc(label))).join("<span>/</span>");
+}
+
+async function route() {
+  const parts = location.hash.replace(/^#\/?/, "").split("/").map(decodeURIComponent);
+  try {
+    if (parts[0] === "f" && parts[2] === "diff") {
+      await showDiff(parts[1], parts[3], parts[4]);
+    } else if (parts[0] === "f") {
+      await showFunction(parts[1]);
+    } else if (parts[0] === "i") {
+      await showInvocation(parts[1], parts[2]);
+    } else {
+      await showFunctions();
+    }
+  } catch (err) {
+    app.innerHTML = `<p class="muted">Error: ${esc(err.message)}</p>`;
+  }
+}
+
+async function showFunctions() {
+  setCrumbs(["Functions"]);
+  const functions = await api("api/functions");
+  if (functions.length === 0) {
+    app.innerHTML = `<p class="muted">No function recorded yet. Pass an llm.Lineage to LLM to record its calls.</p>`;
+    return;
+  }
+  app.innerHTML = `
+    <table>
+      <tr><th>Function</th><th class="num">Versions</th><th class="num">Calls</th><th class="num">Errors</th>
+        <th class="num">Tokens</th><th class="num">Cost</th><th>Last call</th></tr>
+      ${functions.map((f) => `
+        <tr>
+          <td><a href="#/f/${enc(f.name)}">${esc(f.name)}</a></td>
+          <td class="num">${f.versions}</td><td class="num">${num(f.invocations)}</td><td class="num">${num(f.errors)}</td>
+          <td class="num">${num(f.usage.TotalTokens)}</td><td class="num">${cost(f.usage.Cost)}</td><td>${when(f.last_seen)}</td>
+        </tr>`).join("")}
+    </table>`;
+}
+
+async function showFunction(name) {
+  setCrumbs(["Functions", "#/"], [name]);
+  const [versions, usage, invocations] = await Promise.all([
+    api(`api/functions/${enc(name)}/versions`),
+    api(`api/functions/${enc(name)}/usage?bucket=${usageBucket}`),
+    api(`api/invocations?function=${enc(name)}&limit=50`),
+  ]);
+
+  const totals = versions.reduce((t, v) => ({
+    calls: t.calls + v.invocations, errors: t.errors + v.errors,
+    tokens: t.tokens + v.usage.TotalTokens, cost: t.cost + v.usage.Cost,
+  }), { calls: 0, errors: 0, tokens: 0, cost: 0 });
+  const color = Object.fromEntries(versions.map((v, i) => [v.version, colors[i % colors.length]]));
+  const latest = versions.length > 1 ? versions[versions.length - 1].version : "";
+
+  app.innerHTML = `
+    <div class="cards">
+      ${card(versions.length, "versions")}${card(num(totals.calls), "calls")}${card(num(totals.errors), "errors")}
+      ${card(num(totals.tokens), "tokens")}${card(cost(totals.cost), "cost")}
+    </div>
+
+    <h2>Versions</h2>
+    <table>
+      <tr><th>Version</th><th>Model</th><th>System message</th><th class="num">Calls</th><th class="num">Errors</th>
+        <th class="num">Avg tokens</th><th class="num">Avg cost</th><th class="num">Avg time</th><th>First call</th><th></th></tr>
+      ${versions.map((v) => `
+        <tr>
+          <td><i style="color:${color[v.version]}">●</i> <code>${short(v.version)}</code></td>
+          <td>${esc((v.options || {}).model)}</td>
+          <td class="truncate" title="${esc(v.system)}">${esc(v.system)}</td>
+          <td class="num">${num(v.invocations)}</td><td class="num">${num(v.errors)}</td>
+          <td class="num">${v.average_tokens.toFixed(0)}</td><td class="num">${cost(v.average_cost)}</td>
+          <td class="num">${ms(v.average_duration)}</td><td>${when(v.first_seen || v.created_at)}</td>
+          <td>${latest && v.version !== latest ? `<a href="#/f/${enc(name)}/diff/${v.version}/${latest}">diff with latest</a>` : ""}</td>
+        </tr>`).join("")}
+    </table>
+
+    <h2>Usage</h2>
+    <div class="controls">
+      <select id="metric">
+        <option value="tokens">Tokens</option><option value="cost">Cost</option><option value="calls">Calls</option>
+      </select>
+      <select id="bucket">
+        <option value="hour" ${usageBucket === "hour" ? "selected" : ""}>Per hour</option>
+        <option value="day" ${usageBucket === "day" ? "selected" : ""}>Per day</option>
+      </select>
+    </div>
+    <div class="chart" id="chart"></div>
+
+    <h2>Recent calls</h2>
+    <table>
+      <tr><th>Time</th><th>Version</th><th>Input</th><th>Output</th><th class="num">Tokens</th><th class="num">Cost</th><th class="num">Time</th></tr>
+      ${invocations.map((inv) => `
+        <tr class="${inv.error ? "error" : ""}">
+          <td><a href="#/i/${enc(name)}/${enc(inv.id)}">${when(inv.created_at)}</a></td>
+          <td><code>${short(inv.version)}</code></td>
+          <td class="truncate">${esc(inv.input)}</td>
+          <td class="truncate">${esc(inv.error || inv.output)}</td>
+          <td class="num">${num(inv.usage.TotalTokens)}</td><td class="num">${cost(inv.usage.Cost)}</td><td class="num">${ms(inv.duration)}</td>
+        </tr>`).join("")}
+    </table>`;
+
+  const metric = document.getElementById("metric");
+  const draw = () => drawChart(document.getElementById("chart"), usage, metric.value, color);
+  metric.addEventListener("change", draw);
+  document.getElementById("bucket").addEventListener("change", (e) => {
+    usageBucket = e.target.value;
+    route();
+  });
+  draw();
+}
+
+let usageBucket = "hour";
+
+function card(value, label) {
+  return `<div class="card"><div class="value">${value}</div><div class="label">${label}</div></div>`;
+}
+
+// drawChart draws the usage of every version as stacked bars per bucket
+function drawChart(el, points, metric, color) {
+  if (points.length === 0) {
+    el.innerHTML = `<p class="muted">No calls yet.</p>`;
+    return;
+  }
+  const value = (p) => (metric === "cost" ? p.usage.Cost : metric === "calls" ? p.calls : p.usage.TotalTokens);
+  const times = [...new Set(points.map((p) => p.time))];
+  const stacks = times.map((t) => points.filter((p) => p.time === t));
+  const highest = Math.max(...stacks.map((s) => s.reduce((sum, p) => sum + value(p), 0))) || 1;
+
+  const width = 1000, height = 200, left = 60, bottom = 20;
+  const slot = (width - left) / times.length;
+  const bar = Math.max(2, Math.min(40, slot * 0.7));
+  const y = (v) => (height - bottom) * (1 - v / highest);
+  const label = (v) => (metric === "cost" ? cost(v) : num(Math.round(v)));
+
+  let svg = `<svg viewBox="0 0 ${width} ${height}" preserveAspectRatio="none">`;
+  for (const f of [0, 0.5, 1]) {
+    svg += `<line x1="${left}" x2="${width}" y1="${y(highest * f)}" y2="${y(highest * f)}" stroke="#e3e3e6"/>`;
+    svg += `<text x="${left - 6}" y="${y(highest * f) + 4}" text-anchor="end" font-size="11" fill="#6e6e73">${label(highest * f)}</text>`;
+  }
+  stacks.forEach((stack, i) => {
+    const x = left + i * slot + (slot - bar) / 2;
+    let base = 0;
+    for (const p of stack) {
+      const v = value(p);
+      svg += `<rect x="${x}" y="${y(base + v)}" width="${bar}" height="${y(base) - y(base + v)}" fill="${color[p.version] || "#999"}">` +
+        `<title>${esc(new Date(p.time).toLocaleString())} ${short(p.version)}: ${label(v)}</title></rect>`;
+      base += v;
+    }
+  });
+  const step = Math.ceil(times.length / 8);
+  times.forEach((t, i) => {
+    if (i % step === 0) {
+      svg += `<text x="${left + i * slot + slot / 2}" y="${height - 4}" text-anchor="middle" font-size="11" fill="#6e6e73">${esc(new Date(t).toLocaleDateString(undefined, { month: "short", day: "numeric", hour: usageBucket === "hour" ? "2-digit" : undefined }))}</text>`;
+    }
+  });
+  svg += "</svg>";
+
+  const versions = [...new Set(points.map((p) => p.version))];
+  el.innerHTML = svg + `<div class="legend">${versions.map((v) => `<span><i style="background:${color[v] || "#999"}"></i>${short(v)}</span>`).join("")}</div>`;
+}
+
+async function showDiff(name, from, to) {
+  setCrumbs(["Functions", "#/"], [name, `#/f/${enc(name)}`], [`${short(from)} → ${short(to)}`]);
+  const [diff, versions] = await Promise.all([
+    api(`api/functions/${enc(name)}/diff?from=${enc(from)}&to=${enc(to)}`),
+    api(`api/functions/${enc(name)}/versions`),
+  ]);
+  const options = versions.map((v) => v.version);
+  const select = (id, selected) => `<select id="${id}">${options.map((v) =>
+    `<option value="${v}" ${v === selected ? "selected" : ""}>${short(v)}</option>`).join("")}</select>`;
+
+  app.innerHTML = `
+    <div class="controls">From ${select("from", from)} to ${select("to", to)}</div>
+    ${diffSection("System message", diff.system)}
+    ${diffSection("Options", diff.options)}
+    ${diffSection("Tools", diff.tools)}
+    ${diffSection("Prompt function", diff.source)}`;
+
+  const change = () => {
+    location.hash = `#/f/${enc(name)}/diff/${document.getElementById("from").value}/${document.getElementById("to").value}`;
+  };
+  document.getElementById("from").addEventListener("change", change);
+  document.getElementById("to").addEventListener("change", change);
+}
+
+function diffSection(title, lines) {
+  if (!lines || lines.length === 0) {
+    return `<h2>${title}</h2><p class="muted">Empty in both versions.</p>`;
+  }
+  const changed = lines.some((l) => l.op !== " ");
+  return `<h2>${title}${changed ? "" : ' <span class="muted">(unchanged)</span>'}</h2>
+    <pre class="diff">${lines.map((l) =>
+      `<div class="${l.op === "+" ? "add" : l.op === "-" ? "del" : ""}">${esc(l.op + " " + l.text)}</div>`).join("")}</pre>`;
+}
+
+async function showInvocation(name, id) {
+  setCrumbs(["Functions", "#/"], [name, `#/f/${enc(name)}`], [id]);
+  const inv = await api(`api/invocations/${enc(id)}`);
+  app.innerHTML = `
+    <div class="cards">
+      ${card(`<code>${short(inv.version)}</code>`, "version")}${card(esc(inv.model), "model")}
+      ${card(num(inv.usage.TotalTokens), "tokens")}${card(cost(inv.usage.Cost), "cost")}${card(ms(inv.duration), "time")}
+    </div>
+    <h2>Input</h2><div class="message"><pre>${esc(inv.input)}</pre></div>
+    ${inv.error ? `<h2>Error</h2><div class="message tool"><pre>${esc(inv.error)}</pre></div>` : ""}
+    <h2>Transcript</h2>
+    ${(inv.messages || []).map(message).join("")}
+    ${inv.output || inv.reasoning ? message({ role: "assistant", content: inv.output, reasoning: inv.reasoning }) : ""}`;
+}
+
+function message(m) {
+  const calls = (m.tool_calls || []).map((tc) =>
+    `<div>→ <code>${esc(tc.function && tc.function.name)}(${esc(tc.function && tc.function.arguments)})</code> <span class="muted">${esc(tc.id)}</span></div>`).join("");
+  return `
+    <div class="message ${esc(m.role)}">
+      <div class="role">${esc(m.role)}${m.tool_call_id ? ` <span class="muted">${esc(m.tool_call_id)}</span>` : ""}</div>
+      ${m.reasoning ? `<pre class="reasoning">${esc(m.reasoning)}</pre>` : ""}
+      ${m.content ? `<pre>${esc(m.content)}</pre>` : ""}
+      ${calls}
+    </div>`;
+}
+
+window.addEventListener("hashchange", route);
+route();
@@ -0,0 +1,362 @@
+// Package studio serves a local web UI to browse the LLM functions recorded
+// with llm.Lineage: their versions with diffs, every invocation with its
+// transcript, and usage over time. Everything is embedded, nothing is
+// fetched from other services.
+//
+//	store := &llm.FileInvocationStore{Dir: ".lineage"}
+//	http.ListenAndServe("localhost:8080", studio.New(store))
+//
+// or from the command line:
+//
+//	go run github.com/desarso/go_llm_functions/helpers/studio/cmd/studio -dir .lineage
+package studio
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+//go:embed static
+var static embed.FS
+
+// Server is an http.Handler serving the studio
+type Server struct {
+	Store llm.InvocationStore
+	mux   *http.ServeMux
+}
+
+// New returns a studio for the functions recorded in store
+func New(store llm.InvocationStore) *Server {
+	s := &Server{Store: store, mux: http.NewServeMux()}
+
+	assets, _ := fs.Sub(static, "static")
+	s.mux.Handle("GET /", http.FileServer(http.FS(assets)))
+	s.mux.HandleFunc("GET /api/functions", s.functions)
+	s.mux.HandleFunc("GET /api/functions/{name}/versions", s.versions)
+	s.mux.HandleFunc("GET /api/functions/{name}/diff", s.diff)
+	s.mux.HandleFunc("GET /api/functions/{name}/usage", s.usage)
+	s.mux.HandleFunc("GET /api/invocations", s.invocations)
+	s.mux.HandleFunc("GET /api/invocations/{id}", s.invocation)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// functionSummary is a row of the list of functions
+type functionSummary struct {
+	Name        string          `json:"name"`
+	Versions    int             `json:"versions"`
+	Invocations int             `json:"invocations"`
+	Errors      int             `json:"errors"`
+	Usage       llm.UsageTotals `json:"usage"`
+	LastSeen    time.Time       `json:"last_seen"`
+}
+
+func (s *Server) functions(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.Store.Versions(r.Context(), "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	invocations, err := s.Store.Invocations(r.Context(), llm.InvocationQuery{})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	summaries := make(map[string]*functionSummary)
+	get := func(name string) *functionSummary {
+		summary, ok := summaries[name]
+		if !ok {
+			summary = &functionSummary{Name: name}
+			summaries[name] = summary
+		}
+		return summary
+	}
+	for _, version := range versions {
+		get(version.Function).Versions++
+	}
+	for _, inv := range invocations {
+		summary := get(inv.Function)
+		summary.Invocations++
+		if inv.Error != "" {
+			summary.Errors++
+		}
+		summary.Usage.Add(inv.Usage)
+		if inv.CreatedAt.After(summary.LastSeen) {
+			summary.LastSeen = inv.CreatedAt
+		}
+	}
+
+	list := make([]*functionSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		list = append(list, summary)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	writeJSON(w, list)
+}
+
+// versionInfo is a version with the stats of its invocations
+type versionInfo struct {
+	*llm.FunctionVersion
+	Invocations     int             `json:"invocations"`
+	Errors          int             `json:"errors"`
+	Usage           llm.UsageTotals `json:"usage"`
+	AverageTokens   float64         `json:"average_tokens"`
+	AverageCost     float64         `json:"average_cost"`
+	AverageDuration time.Duration   `json:"average_duration"`
+	FirstSeen       time.Time       `json:"first_seen"`
+	LastSeen        time.Time       `json:"last_seen"`
+}
+
+func (s *Server) versions(w http.ResponseWriter, r *http.Request) {
+	stats, err := llm.CompareVersions(r.Context(), s.Store, r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	list := make([]versionInfo, len(stats))
+	for i, stat := range stats {
+		list[i] = versionInfo{
+			FunctionVersion: stat.Version,
+			Invocations:     stat.Invocations,
+			Errors:          stat.Errors,
+			Usage:           stat.Usage,
+			AverageTokens:   stat.AverageTokens,
+			AverageCost:     stat.AverageCost,
+			AverageDuration: stat.AverageDuration,
+			FirstSeen:       stat.FirstSeen,
+			LastSeen:        stat.LastSeen,
+		}
+	}
+	writeJSON(w, list)
+}
+
+// versionDiff holds the line diffs between two versions
+type versionDiff struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	System  []DiffLine `json:"system"`
+	Options []DiffLine `json:"options"`
+	Tools   []DiffLine `json:"tools"`
+	Source  []DiffLine `json:"source"`
+}
+
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.Store.Versions(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	find := func(id string) *llm.FunctionVersion {
+		for _, v := range versions {
+			if v.Version == id {
+				return v
+			}
+		}
+		return nil
+	}
+	from, to := find(r.URL.Query().Get("from")), find(r.URL.Query().Get("to"))
+	if from == nil || to == nil {
+		writeError(w, http.StatusNotFound, errors.New("version not found"))
+		return
+	}
+
+	writeJSON(w, versionDiff{
+		From:    from.Version,
+		To:      to.Version,
+		System:  Diff(from.System, to.System),
+		Options: Diff(indentJSON(from.Options), indentJSON(to.Options)),
+		Tools:   Diff(indentJSON(from.Tools), indentJSON(to.Tools)),
+		Source:  Diff(from.Source, to.Source),
+	})
+}
+
+// usagePoint is the usage of a version during a bucket of time
+type usagePoint struct {
+	Time     time.Time       `json:"time"`
+	Version  string          `json:"version"`
+	Usage    llm.UsageTotals `json:"usage"`
+	Calls    int             `json:"calls"`
+	Errors   int             `json:"errors"`
+	Duration time.Duration   `json:"duration"` // Total of the calls
+}
+
+func (s *Server) usage(w http.ResponseWriter, r *http.Request) {
+	bucket := time.Hour
+	if r.URL.Query().Get("bucket") == "day" {
+		bucket = 24 * time.Hour
+	}
+	invocations, err := s.Store.Invocations(r.Context(), llm.InvocationQuery{Function: r.PathValue("name")})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	type key struct {
+		time    time.Time
+		version string
+	}
+	points := make(map[key]*usagePoint)
+	for _, inv := range invocations {
+		k := key{inv.CreatedAt.UTC().Truncate(bucket), inv.Version}
+		point, ok := points[k]
+		if !ok {
+			point = &usagePoint{Time: k.time, Version: k.version}
+			points[k] = point
+		}
+		point.Calls++
+		if inv.Error != "" {
+			point.Errors++
+		}
+		point.Usage.Add(inv.Usage)
+		point.Duration += inv.Duration
+	}
+
+	list := make([]*usagePoint, 0, len(points))
+	for _, point := range points {
+		list = append(list, point)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].Time.Equal(list[j].Time) {
+			return list[i].Time.Before(list[j].Time)
+		}
+		return list[i].Version < list[j].Version
+	})
+	writeJSON(w, list)
+}
+
+func (s *Server) invocations(w http.ResponseWriter, r *http.Request) {
+	query := llm.InvocationQuery{
+		Function: r.URL.Query().Get("function"),
+		Version:  r.URL.Query().Get("version"),
+		Limit:    100,
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		query.Limit = limit
+	}
+	invocations, err := s.Store.Invocations(r.Context(), query)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// The list leaves the transcripts out, they are loaded one at a time
+	list := make([]llm.Invocation, len(invocations))
+	for i, inv := range invocations {
+		list[i] = *inv
+		list[i].Messages = nil
+	}
+	writeJSON(w, list)
+}
+
+func (s *Server) invocation(w http.ResponseWriter, r *http.Request) {
+	inv, err := s.Store.Invocation(r.Context(), r.PathValue("id"))
+	if errors.Is(err, llm.ErrInvocationNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, inv)
+}
+
+// indentJSON formats a value as indented JSON, one line per field so diffs
+// point at the fields that changed
+func indentJSON(v interface{}) string {
+	if raw, ok := v.(json.RawMessage); ok {
+		var decoded interface{}
+		if err := json.Unmarshal(raw, &decoded); err == nil {
+			v = decoded
+		}
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil || string(data) == "null" {
+		return ""
+	}
+	return string(data)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// DiffLine is a line of a diff: Op is " " when both sides have it, "-" when
+// it was removed and "+" when it was added
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Diff compares two texts line by line
+func Diff(a, b string) []DiffLine {
+	from, to := splitLines(a), splitLines(b)
+
+	// Longest common subsequence of the lines, from the end
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := []DiffLine{}
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			lines = append(lines, DiffLine{" ", from[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{"-", from[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{"+", to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		lines = append(lines, DiffLine{"-", from[i]})
+	}
+	for ; j < len(to); j++ {
+		lines = append(lines, DiffLine{"+", to[j]})
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,63 @@
+package studio
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []DiffLine
+	}{
+		{"both empty", "", "", []DiffLine{}},
+		{"added", "", "one\ntwo\n", []DiffLine{{"+", "one"}, {"+", "two"}}},
+		{"removed", "one\ntwo", "", []DiffLine{{"-", "one"}, {"-", "two"}}},
+		{"same", "one\ntwo\n", "one\ntwo", []DiffLine{{" ", "one"}, {" ", "two"}}},
+		{"changed", "one\ntwo\nthree", "one\n2\nthree", []DiffLine{{" ", "one"}, {"-", "two"}, {"+", "2"}, {" ", "three"}}},
+		{"inserted", "one\nthree", "one\ntwo\nthree", []DiffLine{{" ", "one"}, {"+", "two"}, {" ", "three"}}},
+		{"moved", "a\nb\nc", "b\nc\na", []DiffLine{{"-", "a"}, {" ", "b"}, {" ", "c"}, {"+", "a"}}},
+		{"repeated", "x\ny\nx", "x\nx", []DiffLine{{" ", "x"}, {"-", "y"}, {" ", "x"}}},
+	}
+	for _, tt := range tests {
+		if got := Diff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvocation(t *testing.T) {
+	store := llm.NewMemoryInvocationStore()
+	store.Record(context.Background(), &llm.Invocation{ID: "a", Function: "hello", Output: "Hello."})
+	store.Record(context.Background(), &llm.Invocation{ID: "b", Function: "bye", Output: "Bye."})
+	server := New(store)
+
+	tests := []struct {
+		path   string
+		status int
+		output string
+	}{
+		{"/api/invocations/b", http.StatusOK, "Bye."},
+		{"/api/invocations/c", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		var inv llm.Invocation
+		json.NewDecoder(w.Body).Decode(&inv)
+		if inv.Output != tt.output {
+			t.Errorf("%s: got output %q, want %q", tt.path, inv.Output, tt.output)
+		}
+	}
+}
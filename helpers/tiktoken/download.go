@@ -0,0 +1,44 @@
+//go:build ignore
+
+// download fetches the vocabs embedded by the package into vocab/
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const baseURL = "https://openaipublic.blob.core.windows.net/encodings/"
+
+func main() {
+	for _, name := range []string{"cl100k_base", "o200k_base"} {
+		if err := download(name + ".tiktoken"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func download(file string) error {
+	resp, err := http.Get(baseURL + file)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %w", file, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading %s: %s", file, resp.Status)
+	}
+
+	out, err := os.Create(filepath.Join("vocab", file))
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", file, err)
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing %s: %w", file, err)
+	}
+	return out.Close()
+}
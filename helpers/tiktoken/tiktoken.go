@@ -0,0 +1,33 @@
+// Package tiktoken embeds the vocabs of the cl100k_base and o200k_base
+// encodings, so that CountTokens counts the exact tokens of OpenAI models
+// without LoadEncoding:
+//
+//	import _ "github.com/desarso/go_llm_functions/helpers/tiktoken"
+//
+// The vocabs are read from vocab/<encoding>.tiktoken, as published by
+// OpenAI. They are parsed on first use.
+package tiktoken
+
+//go:generate go run download.go
+
+import (
+	"embed"
+	"io"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+// Encodings are the names of the embedded encodings
+var Encodings = []string{"cl100k_base", "o200k_base"}
+
+//go:embed vocab
+var vocab embed.FS
+
+func init() {
+	for _, name := range Encodings {
+		path := "vocab/" + name + ".tiktoken"
+		llm.RegisterVocab(name, func() (io.ReadCloser, error) {
+			return vocab.Open(path)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package tiktoken
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+)
+
+// TestEmbeddedEncodings encodes a known string with the embedded vocabs,
+// without LoadEncoding
+func TestEmbeddedEncodings(t *testing.T) {
+	tests := []struct {
+		model string
+		want  []int
+	}{
+		{"gpt-4", []int{15339, 1917}},
+		{"gpt-4o", []int{24912, 2375}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			enc, err := llm.EncodingForModel(tt.model)
+			if errors.Is(err, llm.ErrVocabNotFound) {
+				t.Skip("vocab not embedded, run go generate")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := enc.Encode("hello world"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if got := enc.Decode(tt.want); got != "hello world" {
+				t.Errorf("got %q, want hello world", got)
+			}
+
+			// Three tokens to prime the reply, three and one for the role per message
+			messages := []llm.Message{{Role: "user", Content: "hello world"}}
+			if got := llm.CountTokens(tt.model, messages, nil); got != 3+3+1+len(tt.want) {
+				t.Errorf("got %d tokens, want %d", got, 3+3+1+len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Overhead of the chat format in tokens, as counted by OpenAI for its models
+const (
+	tokensPerMessage  = 3  // Start and end of every message
+	tokensPerReply    = 3  // The reply is primed with the assistant role
+	tokensPerToolCall = 3  // Framing of a tool call in an assistant message
+	tokensPerFunction = 7  // Start of every tool definition
+	tokensPerProps    = 3  // Start of the parameters of a tool
+	tokensPerProp     = 3  // Every parameter of a tool
+	tokensForTools    = 12 // End of the tool definitions
+)
+
+// CountTokens counts the tokens a request takes in the context of a model,
+// including the framing of every message and the tool definitions. Models
+// without a known encoding, or whose vocab is not available, are counted with
+// EstimateTokens. The vocabs come with the tiktoken subpackage.
+func CountTokens(model string, messages []Message, tools []*Tool) int {
+	enc, err := EncodingForModel(model)
+	if err != nil {
+		return EstimateTokens(model, messages, tools)
+	}
+	return countTokens(enc.Count, messages, tools)
+}
+
+// EstimateTokens guesses the tokens of a request from its length, about four
+// characters per token, with the same overhead as CountTokens
+func EstimateTokens(model string, messages []Message, tools []*Tool) int {
+	return countTokens(estimate, messages, tools)
+}
+
+func estimate(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+// countTokens adds up the tokens of a request with count
+func countTokens(count func(string) int, messages []Message, tools []*Tool) int {
+	tokens := tokensPerReply
+	for i := range messages {
+		m := &messages[i]
+		tokens += tokensPerMessage + count(m.Role) + count(m.Content)
+		for _, tc := range m.ToolCalls {
+			if tc != nil && tc.Function != nil {
+				tokens += tokensPerToolCall + count(tc.Function.Name) + count(tc.Function.Arguments)
+			}
+		}
+	}
+	if len(tools) > 0 {
+		tokens += countTools(count, tools)
+	}
+	return tokens
+}
+
+// countTools counts the tool definitions the way the model sees them, one
+// line per tool and per parameter
+func countTools(count func(string) int, tools []*Tool) int {
+	tokens := tokensForTools
+	for _, tool := range tools {
+		if tool == nil {
+			continue
+		}
+		name, parameters := tool.Name, (*Parameters)(nil)
+		if tool.Function != nil {
+			if tool.Function.Name != "" {
+				name = tool.Function.Name
+			}
+			parameters = tool.Function.Parameters
+		}
+		tokens += tokensPerFunction + count(name+":"+tool.Description)
+
+		if parameters == nil || len(parameters.Properties) == 0 {
+			continue
+		}
+		tokens += tokensPerProps
+		keys := make([]string, 0, len(parameters.Properties))
+		for key := range parameters.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := parameters.Properties[key]
+			if field == nil {
+				field = &Field{}
+			}
+			tokens += tokensPerProp + count(fmt.Sprintf("%s:%s:%s", key, field.Type, field.Description))
+		}
+	}
+	return tokens
+}
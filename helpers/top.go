@@ -0,0 +1,236 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Candidate is one of the completions returned for a call
+type Candidate struct {
+	Index        int
+	Content      string
+	Reasoning    string // The thinking of reasoning models, kept out of Content
+	FinishReason string
+	Message      *Message
+}
+
+// Result holds the outcome of a call to an LLM function
+type Result struct {
+	Prompt     string        // The prompt returned by the wrapped function
+	Content    string        // The content of the selected candidate
+	Reasoning  string        // The reasoning of the selected candidate
+	Selected   int           // Index of the selected candidate
+	Candidates []Candidate   // Every candidate returned for the final request
+	Messages   []Message     // The messages sent for the final request, including tool calls
+	Usage      UsageTotals   // Usage and cost of every request made for the call
+	Response   *ResponseData // The final response, as returned by the provider
+	Client     *Client       // The client that made the call
+}
+
+// failed records the transcript of a call that stopped with err
+func (r *Result) failed(messages []Message, err error) (*Result, error) {
+	r.Messages = messages
+	var budgetErr *BudgetExceededError
+	if errors.As(err, &budgetErr) {
+		budgetErr.Messages = messages
+	}
+	return r, err
+}
+
+// choose fills the result with the candidates of the final response and
+// selects the answer
+func (r *Result) choose(ctx context.Context, options Options, messages []Message, response *ResponseData) {
+	r.Messages = messages
+	r.Response = response
+	r.Candidates = candidatesFrom(response)
+
+	// Pick the answer among the candidates
+	selected, err := selectCandidate(ctx, options.Selector, r.Prompt, r.Candidates)
+	if err != nil {
+		ClientFrom(ctx).logger(options).WarnContext(ctx, "candidate selection failed", "error", err)
+		selected = 0
+	}
+	r.Selected = selected
+	if selected < len(r.Candidates) {
+		r.Content = r.Candidates[selected].Content
+		r.Reasoning = r.Candidates[selected].Reasoning
+	}
+}
+
+// Selector picks the best candidate when Options.Top requests several. The
+// context holds the client of the call, see ClientFrom; eval.Judge asks a
+// model to pick.
+type Selector interface {
+	Select(ctx context.Context, prompt string, candidates []Candidate) (int, error)
+}
+
+// SelectorFunc adapts a plain function to the Selector interface
+type SelectorFunc func(ctx context.Context, prompt string, candidates []Candidate) (int, error)
+
+func (f SelectorFunc) Select(ctx context.Context, prompt string, candidates []Candidate) (int, error) {
+	return f(ctx, prompt, candidates)
+}
+
+// complete sends a chat request and makes sure Options.Top candidates come back.
+// Providers that ignore `n` only return one choice, so the missing ones are
+// requested in parallel. The candidates are cached together, so a cache hit
+// needs no request at all.
+func complete(ctx context.Context, client *Client, messages []Message, options Options, tools ...*Tool) (*ResponseData, error) {
+	return cachedChat(ctx, client, messages, options, tools, func(ctx context.Context) (*ResponseData, error) {
+		return candidates(ctx, client, messages, options, tools...)
+	})
+}
+
+// candidates requests Options.Top candidates, one request per missing one
+// when the provider ignores `n`
+func candidates(ctx context.Context, client *Client, messages []Message, options Options, tools ...*Tool) (*ResponseData, error) {
+	response, err := chat(ctx, client, messages, options, tools...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tool calls are followed from a single choice, no need for more
+	missing := options.Top - len(response.Choices)
+	if missing <= 0 || choiceWithToolCalls(response) != nil {
+		return response, nil
+	}
+
+	// Only the first request is streamed to the callbacks
+	single := options
+	single.Top = 0
+	single.OnContent = nil
+	single.OnReasoning = nil
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := 0; i < missing; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// The first request decides the model of the cache key
+			extra, err := chat(context.WithValue(ctx, answeredKey{}, &answeredBy{}), client, messages, single, tools...)
+			if err != nil {
+				client.logger(options).WarnContext(ctx, "extra candidate failed", "error", err)
+				return
+			}
+			mu.Lock()
+			response.Choices = append(response.Choices, extra.Choices...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Re-index so candidates are numbered in the order they were collected
+	for i, choice := range response.Choices {
+		if choice != nil {
+			choice.Index = int32(i)
+		}
+	}
+
+	return response, nil
+}
+
+// choiceWithToolCalls returns the first choice asking for tool calls, if any
+func choiceWithToolCalls(response *ResponseData) *Choice {
+	for _, choice := range response.Choices {
+		if choice != nil && choice.Message != nil && len(choice.Message.ToolCalls) > 0 {
+			return choice
+		}
+	}
+	return nil
+}
+
+// candidatesFrom converts the choices of a response into candidates
+func candidatesFrom(response *ResponseData) []Candidate {
+	var candidates []Candidate
+	for _, choice := range response.Choices {
+		if choice == nil || choice.Message == nil {
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			Index:        len(candidates),
+			Content:      choice.Message.Content,
+			Reasoning:    choice.Message.Reasoning,
+			FinishReason: choice.FinishReason,
+			Message:      choice.Message,
+		})
+	}
+	return candidates
+}
+
+// selectCandidate runs the selector, defaulting to the first candidate
+func selectCandidate(ctx context.Context, selector Selector, prompt string, candidates []Candidate) (int, error) {
+	if selector == nil || len(candidates) <= 1 {
+		return 0, nil
+	}
+
+	selected, err := selector.Select(ctx, prompt, candidates)
+	if err != nil {
+		return 0, err
+	}
+	if selected < 0 || selected >= len(candidates) {
+		return 0, fmt.Errorf("selector returned out of range candidate %d", selected)
+	}
+	return selected, nil
+}
+
+// normalizeAnswer makes answers comparable for majority voting
+func normalizeAnswer(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.TrimRight(s, ".!")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// MajorityVote picks the most common answer (self-consistency).
+// Ties go to the earliest candidate.
+func MajorityVote() Selector {
+	return SelectorFunc(func(ctx context.Context, prompt string, candidates []Candidate) (int, error) {
+		counts := make(map[string]int)
+		for _, c := range candidates {
+			counts[normalizeAnswer(c.Content)]++
+		}
+
+		best, bestCount := 0, 0
+		for i, c := range candidates {
+			if n := counts[normalizeAnswer(c.Content)]; n > bestCount {
+				best, bestCount = i, n
+			}
+		}
+		return best, nil
+	})
+}
+
+// Longest picks the longest answer
+func Longest() Selector {
+	return ScoreFunc(func(content string) float64 {
+		return float64(len(content))
+	})
+}
+
+// Shortest picks the shortest non-empty answer
+func Shortest() Selector {
+	return ScoreFunc(func(content string) float64 {
+		if strings.TrimSpace(content) == "" {
+			return math.Inf(-1)
+		}
+		return -float64(len(content))
+	})
+}
+
+// ScoreFunc picks the candidate with the highest score
+func ScoreFunc(score func(content string) float64) Selector {
+	return SelectorFunc(func(ctx context.Context, prompt string, candidates []Candidate) (int, error) {
+		best := 0
+		bestScore := score(candidates[0].Content)
+		for i := 1; i < len(candidates); i++ {
+			if s := score(candidates[i].Content); s > bestScore {
+				best, bestScore = i, s
+			}
+		}
+		return best, nil
+	})
+}
@@ -0,0 +1,102 @@
+package llm_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+// answers returns candidates with the given contents
+func answers(contents ...string) []llm.Candidate {
+	candidates := make([]llm.Candidate, len(contents))
+	for i, content := range contents {
+		candidates[i] = llm.Candidate{Index: i, Content: content}
+	}
+	return candidates
+}
+
+func TestSelectors(t *testing.T) {
+	sunny := llm.ScoreFunc(func(content string) float64 {
+		return float64(strings.Count(content, "sun"))
+	})
+	tests := []struct {
+		name       string
+		selector   llm.Selector
+		candidates []llm.Candidate
+		want       int
+	}{
+		{"majority", llm.MajorityVote(), answers("Lyon", "Paris.", " paris! ", "Nice"), 1},
+		{"majority tie", llm.MajorityVote(), answers("Lyon", "Paris", "paris", "LYON."), 0},
+		{"majority all different", llm.MajorityVote(), answers("Lyon", "Paris", "Nice"), 0},
+		{"longest", llm.Longest(), answers("Paris", "It is Paris", "Lyon"), 1},
+		{"shortest", llm.Shortest(), answers("It is Paris", "", "Paris"), 2},
+		{"score", sunny, answers("rain", "sun and sun", "sun"), 1},
+		{"score tie", sunny, answers("sun", "rain", "sun"), 0},
+	}
+	for _, tt := range tests {
+		got, err := tt.selector.Select(context.Background(), "Capital of France?", tt.candidates)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got candidate %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// ignoringN is a provider returning a single choice whatever the n of the
+// request, like many do
+type ignoringN struct {
+	*fake.Provider
+}
+
+func (p ignoringN) Chat(ctx context.Context, req *llm.Request) (*llm.ResponseData, error) {
+	single := *req
+	single.N = 0
+	return p.Provider.Chat(ctx, &single)
+}
+
+func TestTopCachesEveryCandidate(t *testing.T) {
+	provider := fake.New()
+	provider.On("").Respond("Paris.").Respond("paris").Respond("Lyon.")
+	client := &llm.Client{
+		Provider: ignoringN{provider},
+		Cache:    &llm.ResponseCache{Backend: llm.NewLRUCache(10), Force: true},
+	}
+	capital := llm.LLMWithResult(echo, client, llm.Options{Top: 3, Selector: llm.MajorityVote()})
+
+	for i := 0; i < 2; i++ {
+		result, err := capital(context.Background(), "Capital of France?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Candidates) != 3 {
+			t.Fatalf("call %d: got %d candidates, want 3", i, len(result.Candidates))
+		}
+		if !strings.EqualFold(strings.TrimSuffix(result.Content, "."), "paris") {
+			t.Errorf("call %d: got %q, want the majority", i, result.Content)
+		}
+	}
+	// The second call is answered by the cache alone
+	if n := len(provider.Requests()); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestSelectorOutOfRange(t *testing.T) {
+	provider := fake.New()
+	provider.On("").Respond("Paris.").Respond("Lyon.")
+	wrong := llm.SelectorFunc(func(ctx context.Context, prompt string, candidates []llm.Candidate) (int, error) {
+		return len(candidates), nil
+	})
+	result, err := llm.LLMWithResult(echo, &llm.Client{Provider: ignoringN{provider}}, llm.Options{Top: 2, Selector: wrong})(context.Background(), "Capital of France?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Selected != 0 || result.Content != result.Candidates[0].Content {
+		t.Errorf("got candidate %d, want the first one", result.Selected)
+	}
+}
@@ -0,0 +1,188 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans
+const tracerName = "github.com/desarso/go_llm_functions/helpers"
+
+// Attributes of the OpenTelemetry GenAI semantic conventions
+const (
+	attrOperation     = attribute.Key("gen_ai.operation.name")
+	attrSystem        = attribute.Key("gen_ai.system")
+	attrAgentName     = attribute.Key("gen_ai.agent.name")
+	attrRequestModel  = attribute.Key("gen_ai.request.model")
+	attrTemperature   = attribute.Key("gen_ai.request.temperature")
+	attrTopP          = attribute.Key("gen_ai.request.top_p")
+	attrMaxTokens     = attribute.Key("gen_ai.request.max_tokens")
+	attrStopSequences = attribute.Key("gen_ai.request.stop_sequences")
+	attrChoiceCount   = attribute.Key("gen_ai.request.choice.count")
+	attrResponseID    = attribute.Key("gen_ai.response.id")
+	attrResponseModel = attribute.Key("gen_ai.response.model")
+	attrFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+	attrInputTokens   = attribute.Key("gen_ai.usage.input_tokens")
+	attrOutputTokens  = attribute.Key("gen_ai.usage.output_tokens")
+	attrToolName      = attribute.Key("gen_ai.tool.name")
+	attrToolCallID    = attribute.Key("gen_ai.tool.call.id")
+	attrErrorType     = attribute.Key("error.type")
+	attrFunctionVer   = attribute.Key("llm.function.version")
+	attrStreaming     = attribute.Key("llm.stream")
+	attrCacheHits     = attribute.Key("llm.usage.cache_hits")
+	attrTotalCost     = attribute.Key("llm.usage.cost")
+	attrTotalRequests = attribute.Key("llm.usage.requests")
+	attrTotalTokens   = attribute.Key("llm.usage.total_tokens")
+)
+
+// tracer returns the tracer of the client, from the global TracerProvider
+// when the client has none
+func (c *Client) tracer() trace.Tracer {
+	if c != nil && c.TracerProvider != nil {
+		return c.TracerProvider.Tracer(tracerName)
+	}
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// system names the provider of the client for gen_ai.system
+func (c *Client) system() string {
+	switch c.provider().(type) {
+	case *OpenAIProvider:
+		return "openai"
+	case *AnthropicProvider:
+		return "anthropic"
+	case *GeminiProvider:
+		return "gemini"
+	case *OllamaProvider:
+		return "ollama"
+	}
+	return "_OTHER"
+}
+
+// startInvocation starts the span of a call to an LLM function
+func (c *Client) startInvocation(ctx context.Context, name, version string, options Options) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attrOperation.String("invoke_agent"),
+		attrSystem.String(c.system()),
+		attrAgentName.String(name),
+		attrRequestModel.String(c.model(options)),
+	}
+	if version != "" {
+		attrs = append(attrs, attrFunctionVer.String(version))
+	}
+	return c.tracer().Start(ctx, "invoke_agent "+name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endInvocation records the totals of the call on its span and ends it
+func endInvocation(span trace.Span, result *Result, err error) {
+	if result != nil {
+		span.SetAttributes(
+			attrTotalRequests.Int(result.Usage.Requests),
+			attrTotalTokens.Int(result.Usage.TotalTokens),
+			attrTotalCost.Float64(result.Usage.Cost),
+			attrCacheHits.Int(result.Usage.CacheHits),
+		)
+	}
+	endSpan(span, err)
+}
+
+// startChat starts the span of a chat request
+func (c *Client) startChat(ctx context.Context, req *Request) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attrOperation.String("chat"),
+		attrSystem.String(c.system()),
+		attrRequestModel.String(req.Model),
+		attrStreaming.Bool(req.Stream),
+	}
+	if req.Temperature != nil {
+		attrs = append(attrs, attrTemperature.Float64(*req.Temperature))
+	}
+	if req.TopP != nil {
+		attrs = append(attrs, attrTopP.Float64(*req.TopP))
+	}
+	if req.MaxTokens > 0 {
+		attrs = append(attrs, attrMaxTokens.Int(req.MaxTokens))
+	}
+	if len(req.Stop) > 0 {
+		attrs = append(attrs, attrStopSequences.StringSlice(req.Stop))
+	}
+	if req.N > 1 {
+		attrs = append(attrs, attrChoiceCount.Int(req.N))
+	}
+	return c.tracer().Start(ctx, "chat "+req.Model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endChat records the response on the span of a chat request and ends it
+func endChat(span trace.Span, response *ResponseData, err error) {
+	if response != nil {
+		span.SetAttributes(
+			attrResponseID.String(response.Id),
+			attrResponseModel.String(response.Model),
+		)
+		reasons := make([]string, 0, len(response.Choices))
+		for _, choice := range response.Choices {
+			if choice != nil && choice.FinishReason != "" {
+				reasons = append(reasons, choice.FinishReason)
+			}
+		}
+		span.SetAttributes(attrFinishReasons.StringSlice(reasons))
+		if response.Usage != nil {
+			span.SetAttributes(
+				attrInputTokens.Int(int(response.Usage.PromptTokens)),
+				attrOutputTokens.Int(int(response.Usage.CompletionTokens)),
+			)
+		}
+	}
+	endSpan(span, err)
+}
+
+// startTool starts the span of a tool execution
+func (c *Client) startTool(ctx context.Context, call *ToolInvocation) (context.Context, trace.Span) {
+	return c.tracer().Start(ctx, "execute_tool "+call.Name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attrOperation.String("execute_tool"),
+			attrToolName.String(call.Name),
+			attrToolCallID.String(call.ID),
+		),
+	)
+}
+
+// endSpan marks the span as failed when err is set and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attrErrorType.String(errorType(err)))
+	}
+	span.End()
+}
+
+// errorType classifies an error for the error.type attribute
+func errorType(err error) string {
+	var apiErr *APIError
+	var budgetErr *BudgetExceededError
+	switch {
+	case errors.As(err, &apiErr):
+		return fmt.Sprint(apiErr.StatusCode)
+	case errors.As(err, &budgetErr):
+		return "budget_exceeded"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "_OTHER"
+}
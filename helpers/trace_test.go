@@ -0,0 +1,110 @@
+package llm_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	llm "github.com/desarso/go_llm_functions/helpers"
+	"github.com/desarso/go_llm_functions/helpers/fake"
+)
+
+func forecast(city string) string { return "Weather in " + city + "?" }
+
+// attrs returns the attributes of a span by key
+func attrs(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	out := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		out[kv.Key] = kv.Value
+	}
+	return out
+}
+
+// TestSpans checks the spans of a call with a tool. The chat requests and
+// the tool executions are children of the invocation, in the order they ran.
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tracerProvider.Shutdown(context.Background())
+
+	provider := fake.New()
+	provider.On("").CallTool("lookup", map[string]string{"city": "Paris"}).Respond("Sunny.")
+	client := &llm.Client{Provider: provider, Model: "test-model", TracerProvider: tracerProvider}
+
+	f := llm.LLMWithResult(forecast, client, llm.CreateTool("lookup", "Weather of a city", lookup))
+	if _, err := f(context.Background(), "Paris"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spans are exported as they end, the invocation last
+	spans := exporter.GetSpans()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	want := []string{"chat test-model", "execute_tool lookup", "chat test-model", "invoke_agent "}
+	if len(spans) != len(want) {
+		t.Fatalf("got spans %v, want %v", names, want)
+	}
+	for i, name := range names {
+		if !strings.HasPrefix(name, want[i]) {
+			t.Fatalf("got spans %v, want %v", names, want)
+		}
+	}
+
+	invocation := spans[3]
+	if invocation.Parent.IsValid() {
+		t.Errorf("got parent %v for the invocation, want a root span", invocation.Parent)
+	}
+	for _, span := range spans[:3] {
+		if span.Parent.SpanID() != invocation.SpanContext.SpanID() || span.SpanContext.TraceID() != invocation.SpanContext.TraceID() {
+			t.Errorf("%s is not a child of the invocation", span.Name)
+		}
+	}
+
+	checks := []struct {
+		span tracetest.SpanStub
+		want map[attribute.Key]attribute.Value
+	}{
+		{invocation, map[attribute.Key]attribute.Value{
+			"gen_ai.operation.name": attribute.StringValue("invoke_agent"),
+			"gen_ai.request.model":  attribute.StringValue("test-model"),
+			"llm.usage.requests":    attribute.IntValue(2),
+		}},
+		{spans[0], map[attribute.Key]attribute.Value{
+			"gen_ai.operation.name":          attribute.StringValue("chat"),
+			"gen_ai.request.model":           attribute.StringValue("test-model"),
+			"gen_ai.response.finish_reasons": attribute.StringSliceValue([]string{"tool_calls"}),
+		}},
+		{spans[1], map[attribute.Key]attribute.Value{
+			"gen_ai.operation.name": attribute.StringValue("execute_tool"),
+			"gen_ai.tool.name":      attribute.StringValue("lookup"),
+		}},
+		{spans[2], map[attribute.Key]attribute.Value{
+			"gen_ai.response.finish_reasons": attribute.StringSliceValue([]string{"stop"}),
+		}},
+	}
+	for _, check := range checks {
+		got := attrs(check.span)
+		for key, want := range check.want {
+			if got[key] != want {
+				t.Errorf("%s: got %s = %v, want %v", check.span.Name, key, got[key].Emit(), want.Emit())
+			}
+		}
+	}
+	if name := attrs(invocation)["gen_ai.agent.name"].AsString(); !strings.HasSuffix(name, "forecast") {
+		t.Errorf("got agent name %q, want the function name", name)
+	}
+	if id := attrs(spans[1])["gen_ai.tool.call.id"].AsString(); id == "" {
+		t.Error("the tool span has no call ID")
+	}
+	for _, span := range []tracetest.SpanStub{spans[0], spans[2]} {
+		if attrs(span)["gen_ai.usage.input_tokens"].AsInt64() == 0 {
+			t.Errorf("%s has no input tokens", span.Name)
+		}
+	}
+}
@@ -1,13 +1,82 @@
 package llm
 
-import "google.golang.org/protobuf/runtime/protoimpl"
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/runtime/protoimpl"
+)
 
 type Request struct {
-	Model      string    `json:"model"`
-	Messages   []Message `json:"messages"`
-	Stream     bool      `json:"stream"`
-	ToolChoice string    `json:"tool_choice,omitempty"`
-	Tools      []Tool    `json:"tools,omitempty"` // Added field for tools
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"` // Ask for the usage in the last chunk of a stream
+	N             int            `json:"n,omitempty"`              // Number of completions to generate
+	ToolChoice    ToolChoice     `json:"tool_choice,omitempty"`
+	Tools         []*Tool        `json:"tools,omitempty"` // Added field for tools
+
+	// Sampling
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+
+	// OpenRouter routing
+	Models   []string             `json:"models,omitempty"`   // Fallback models tried by OpenRouter
+	Route    string               `json:"route,omitempty"`    // "fallback"
+	Provider *ProviderPreferences `json:"provider,omitempty"` // Provider routing preferences
+
+	// Reasoning models
+	IncludeReasoning *bool      `json:"include_reasoning,omitempty"`
+	Reasoning        *Reasoning `json:"reasoning,omitempty"`
+}
+
+// ToolChoice tells the model whether to call tools: "auto", "none",
+// "required", or the name of the tool it must call
+type ToolChoice string
+
+// forced reports whether the choice names a tool
+func (c ToolChoice) forced() bool {
+	switch c {
+	case "", "auto", "none", "required":
+		return false
+	}
+	return true
+}
+
+// MarshalJSON writes a forced tool in the object form of OpenAI
+func (c ToolChoice) MarshalJSON() ([]byte, error) {
+	if !c.forced() {
+		return json.Marshal(string(c))
+	}
+	return json.Marshal(map[string]interface{}{
+		"type":     "function",
+		"function": map[string]string{"name": string(c)},
+	})
+}
+
+// UnmarshalJSON reads both the string and the object form
+func (c *ToolChoice) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = ToolChoice(s)
+		return nil
+	}
+	var object struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("error decoding tool_choice: %w", err)
+	}
+	*c = ToolChoice(object.Function.Name)
+	return nil
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
 }
 
 type Tool struct {
@@ -17,6 +86,7 @@ type Tool struct {
 	Description   string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"` // A description of what the tool does
 	Function      *Function              `protobuf:"bytes,4,opt,name=function,proto3" json:"function,omitempty"`       // A nested struct for the function details
 	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 type Message struct {
@@ -25,6 +95,8 @@ type Message struct {
 	Content       string                 `protobuf:"bytes,2,opt,name=content,proto3" json:"content,omitempty"`
 	ToolCalls     []*ToolCall            `protobuf:"bytes,4,rep,name=tool_calls,json=toolCalls,proto3" json:"tool_calls,omitempty"`
 	ToolCallID    string                 `protobuf:"bytes,5,opt,name=tool_call_id,json=toolCallId,proto3" json:"tool_call_id,omitempty"`
+	Reasoning     string                 `protobuf:"bytes,6,opt,name=reasoning,proto3" json:"reasoning,omitempty"`                              // Thinking of reasoning models, kept apart from the content
+	ReasoningData string                 `protobuf:"bytes,7,opt,name=reasoning_data,json=reasoningData,proto3" json:"reasoning_data,omitempty"` // Opaque data a provider needs to get its reasoning back, like signed thinking
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -74,6 +146,7 @@ type ResponseData struct {
 	SystemFingerprint string                 `protobuf:"bytes,5,opt,name=system_fingerprint,json=systemFingerprint,proto3" json:"system_fingerprint,omitempty"`
 	Choices           []*Choice              `protobuf:"bytes,6,rep,name=choices,proto3" json:"choices,omitempty"`
 	XGroq             *XGroq                 `protobuf:"bytes,7,opt,name=x_groq,json=xGroq,proto3" json:"x_groq,omitempty"`
+	Usage             *Usage                 `protobuf:"bytes,8,opt,name=usage,proto3" json:"usage,omitempty"`
 	unknownFields     protoimpl.UnknownFields
 	sizeCache         protoimpl.SizeCache
 }
@@ -95,6 +168,7 @@ type Usage struct {
 	CompletionTime   float32                `protobuf:"fixed32,5,opt,name=completion_time,json=completionTime,proto3" json:"completion_time,omitempty"`
 	TotalTokens      int32                  `protobuf:"varint,6,opt,name=total_tokens,json=totalTokens,proto3" json:"total_tokens,omitempty"`
 	TotalTime        float32                `protobuf:"fixed32,7,opt,name=total_time,json=totalTime,proto3" json:"total_time,omitempty"`
+	Cost             float64                `protobuf:"fixed64,8,opt,name=cost,proto3" json:"cost,omitempty"` // Cost in dollars, reported by OpenRouter
 	unknownFields    protoimpl.UnknownFields
 	sizeCache        protoimpl.SizeCache
 }
@@ -114,6 +188,8 @@ type Delta struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Content       string                 `protobuf:"bytes,1,opt,name=content,proto3" json:"content,omitempty"`                      // Content as a string, for text updates
 	ToolCalls     []*ToolCall            `protobuf:"bytes,2,rep,name=tool_calls,json=toolCalls,proto3" json:"tool_calls,omitempty"` // List of tool calls in the delta.
+	Reasoning     string                 `protobuf:"bytes,3,opt,name=reasoning,proto3" json:"reasoning,omitempty"`                  // Reasoning text for reasoning models
+	ReasoningData string                 `protobuf:"bytes,4,opt,name=reasoning_data,json=reasoningData,proto3" json:"reasoning_data,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -0,0 +1,177 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func testToolCall() *ToolCall {
+	return &ToolCall{
+		Id:       "call_1",
+		Type:     "function",
+		Index:    1,
+		Function: &Function{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+	}
+}
+
+func testMessage() *Message {
+	return &Message{
+		Role:      "assistant",
+		Content:   "Let me check.",
+		Reasoning: "The user wants the weather.",
+		ToolCalls: []*ToolCall{testToolCall()},
+	}
+}
+
+func testResponse() *ResponseData {
+	return &ResponseData{
+		Id:      "resp_1",
+		Object:  "chat.completion",
+		Created: 1700000000,
+		Model:   "gpt-4o",
+		Choices: []*Choice{
+			{Index: 0, FinishReason: "tool_calls", Message: testMessage()},
+			{Index: 1, Delta: &Delta{Content: "It is", Reasoning: "sunny?"}},
+		},
+		Usage: &Usage{PromptTokens: 12, CompletionTokens: 5, TotalTokens: 17},
+	}
+}
+
+func TestProtoMessagesRoundTrip(t *testing.T) {
+	messages := map[string]proto.Message{
+		"Message":      testMessage(),
+		"ToolCall":     testToolCall(),
+		"ResponseData": testResponse(),
+	}
+
+	for name, message := range messages {
+		t.Run(name+"/json", func(t *testing.T) {
+			data, err := json.Marshal(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := message.ProtoReflect().New().Interface()
+			if err := json.Unmarshal(data, decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(message, decoded) {
+				t.Errorf("got %v, want %v", decoded, message)
+			}
+		})
+
+		t.Run(name+"/proto", func(t *testing.T) {
+			data, err := proto.Marshal(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := message.ProtoReflect().New().Interface()
+			if err := proto.Unmarshal(data, decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(message, decoded) {
+				t.Errorf("got %v, want %v", decoded, message)
+			}
+		})
+
+		t.Run(name+"/protojson", func(t *testing.T) {
+			data, err := protojson.Marshal(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := message.ProtoReflect().New().Interface()
+			if err := protojson.Unmarshal(data, decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(message, decoded) {
+				t.Errorf("got %v, want %v", decoded, message)
+			}
+		})
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	temperature := 0.2
+	include := true
+	req := &Request{
+		Model:         "gpt-4o",
+		Messages:      []Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Weather?"}},
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+		ToolChoice:    "auto",
+		Tools: []*Tool{{
+			Type: "function",
+			Function: &Function{
+				Name: "get_weather",
+				Parameters: &Parameters{
+					Type:       "object",
+					Properties: map[string]*Field{"city": {Type: "string", Description: "City name"}},
+					Required:   []string{"city"},
+				},
+			},
+		}},
+		Temperature:      &temperature,
+		MaxTokens:        100,
+		Stop:             []string{"\n\n"},
+		Models:           []string{"gpt-4o-mini"},
+		Route:            "fallback",
+		IncludeReasoning: &include,
+		Reasoning:        &Reasoning{Effort: "low"},
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Request
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages and tools are proto messages, compare them with proto.Equal
+	if len(decoded.Messages) != len(req.Messages) {
+		t.Fatalf("got %d messages, want %d", len(decoded.Messages), len(req.Messages))
+	}
+	for i := range req.Messages {
+		if !proto.Equal(&req.Messages[i], &decoded.Messages[i]) {
+			t.Errorf("message %d: got %v, want %v", i, &decoded.Messages[i], &req.Messages[i])
+		}
+	}
+	if len(decoded.Tools) != 1 || !proto.Equal(req.Tools[0], decoded.Tools[0]) {
+		t.Errorf("got tools %v, want %v", decoded.Tools, req.Tools)
+	}
+
+	again, err := json.Marshal(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Errorf("got %s, want %s", again, data)
+	}
+}
+
+func TestToolChoiceJSON(t *testing.T) {
+	tests := map[ToolChoice]string{
+		"auto":        `"auto"`,
+		"required":    `"required"`,
+		"get_weather": `{"function":{"name":"get_weather"},"type":"function"}`,
+	}
+	for choice, want := range tests {
+		data, err := json.Marshal(choice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("got %s, want %s", data, want)
+		}
+		var decoded ToolChoice
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded != choice {
+			t.Errorf("got %q, want %q", decoded, choice)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// Price is the cost of a model in dollars per million tokens
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// Pricer computes the cost of a request
+type Pricer interface {
+	Cost(model string, usage *Usage) (float64, bool)
+}
+
+// PriceTable is a Pricer with a fixed price per model
+type PriceTable map[string]Price
+
+func (t PriceTable) Cost(model string, usage *Usage) (float64, bool) {
+	price, ok := t[model]
+	if !ok || usage == nil {
+		return 0, false
+	}
+	cost := float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion
+	return cost / 1e6, true
+}
+
+// UsageTotals adds up the token usage and cost of several requests
+type UsageTotals struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	Cost             float64 // In dollars, 0 when unknown
+	CacheHits        int     // Requests answered from the response cache, not counted in Requests
+	CacheMisses      int     // Cacheable requests that were sent
+}
+
+// Add adds the totals of other
+func (t *UsageTotals) Add(other UsageTotals) {
+	t.Requests += other.Requests
+	t.PromptTokens += other.PromptTokens
+	t.CompletionTokens += other.CompletionTokens
+	t.TotalTokens += other.TotalTokens
+	t.Cost += other.Cost
+	t.CacheHits += other.CacheHits
+	t.CacheMisses += other.CacheMisses
+}
+
+// responseUsage returns the usage of a response, Groq used to only report it
+// under x_groq
+func responseUsage(response *ResponseData) *Usage {
+	if response.Usage != nil {
+		return response.Usage
+	}
+	if response.XGroq != nil {
+		return response.XGroq.Usage
+	}
+	return nil
+}
+
+// usageOf converts the usage of a response to totals, pricing it with the
+// price table of the client or else the cost reported by the provider
+func (c *Client) usageOf(model string, response *ResponseData) UsageTotals {
+	totals := UsageTotals{Requests: 1}
+	usage := responseUsage(response)
+	if usage == nil {
+		return totals
+	}
+
+	totals.PromptTokens = int(usage.PromptTokens)
+	totals.CompletionTokens = int(usage.CompletionTokens)
+	totals.TotalTokens = int(usage.TotalTokens)
+	if totals.TotalTokens == 0 {
+		totals.TotalTokens = totals.PromptTokens + totals.CompletionTokens
+	}
+
+	if response.Model != "" {
+		model = response.Model
+	}
+	totals.Cost = usage.Cost
+	if c != nil && c.Prices != nil {
+		if cost, ok := c.Prices.Cost(model, usage); ok {
+			totals.Cost = cost
+		}
+	}
+	return totals
+}
+
+// usageTracker adds up the usage of every request made for a single call,
+// including parallel candidates and judges
+type usageTracker struct {
+	mu     sync.Mutex
+	totals UsageTotals
+}
+
+type usageKey struct{}
+
+// withUsageTracker starts tracking the usage of the requests made with ctx
+func withUsageTracker(ctx context.Context) (context.Context, *usageTracker) {
+	tracker := &usageTracker{}
+	return context.WithValue(ctx, usageKey{}, tracker), tracker
+}
+
+func (t *usageTracker) add(totals UsageTotals) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.totals.Add(totals)
+}
+
+func (t *usageTracker) get() UsageTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.totals
+}
+
+// recordUsage adds the tokens and cost of a response to the call and to the
+// client. The request itself was counted by reserveRequest.
+func (c *Client) recordUsage(ctx context.Context, model string, response *ResponseData) UsageTotals {
+	totals := c.usageOf(model, response)
+	totals.Requests = 0
+	c.recordTotals(ctx, totals)
+	return totals
+}
+
+// recordTotals adds usage to the call and to the client
+func (c *Client) recordTotals(ctx context.Context, totals UsageTotals) {
+	if tracker, ok := ctx.Value(usageKey{}).(*usageTracker); ok {
+		tracker.add(totals)
+	}
+	if c != nil {
+		c.usageMu.Lock()
+		c.usage.Add(totals)
+		c.usageMu.Unlock()
+	}
+	c.recordBudgets(ctx, totals)
+}
+
+// Usage returns the usage of every request made through the client
+func (c *Client) Usage() UsageTotals {
+	c.usageMu.Lock()
+	defer c.usageMu.Unlock()
+	return c.usage
+}
+
+// ResetUsage clears the usage returned by Usage
+func (c *Client) ResetUsage() {
+	c.usageMu.Lock()
+	defer c.usageMu.Unlock()
+	c.usage = UsageTotals{}
+}